@@ -6,60 +6,143 @@ import (
 	"flag"
 	"fmt"
 	. "github.com/wisbery/tarolas/server"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
 var defaultConfigurationFileName = "./config/config.json"
 
+// checkConfig is the flag indicating if the configuration is only validated, without starting the server.
+var checkConfig = flag.Bool("check-config", false, "validate configuration and exit")
+
+// printConfig is the flag indicating if the effective configuration is only printed, without starting the server.
+var printConfig = flag.Bool("print-config", false, "print effective configuration with secrets redacted and exit")
+
+// overrides collects configuration options given in command line.
+var overrides optionOverrides
+
+func init() {
+	flag.Var(&overrides, "set", "override configuration option given as path=value, e.g. trash.enabled=true (may be repeated)")
+}
+
+// optionOverrides is the list of 'path=value' configuration overrides given in command line.
+type optionOverrides []string
+
+func (o *optionOverrides) String() string {
+	return strings.Join(*o, ", ")
+}
+
+func (o *optionOverrides) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
 // showUsageAndExit shows the usage of this service.
 func showUsage() {
 }
 
+// configurationFileName is the name of configuration file given in command line.
+var configurationFileName = flag.String("config", "", "path to configuration file")
+
+// loadConfiguration reads the configuration from the file, and applies overrides from environment
+// and command line. It is used both when the server starts and when the configuration is reloaded.
+func loadConfiguration() (*Configuration, error) {
+	// try to read the configuration file
+	data, err := os.ReadFile(*configurationFileName)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while reading tarolas configuration file\n%v", err)
+	}
+	// try to parse data read from configuration file
+	cfg, err := ParseConfiguration(data, *configurationFileName)
+	if err != nil {
+		return nil, fmt.Errorf("error occured while parsing tarolas configuration file:\n%v", err)
+	}
+	// apply overrides from environment and command line
+	if err = cfg.ApplyEnvironment(os.Environ()); err != nil {
+		return nil, fmt.Errorf("error occured while reading tarolas configuration from environment:\n%v", err)
+	}
+	for _, override := range overrides {
+		path, value, ok := strings.Cut(override, "=")
+		if !ok {
+			err = fmt.Errorf("%s: expected path=value", override)
+		} else {
+			err = cfg.Set(strings.TrimSpace(path), value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error occured while reading tarolas configuration from command line:\n%v", err)
+		}
+	}
+	return cfg, nil
+}
+
 // readConfiguration reads server's configuration from configuration file specified in command line.
 // To correctly start tarolas server, there must be given one command line parameter named '--config'.
-// This parameter must specify existing JSON file with configuration parameters.
+// This parameter must specify existing JSON, YAML (.yaml, .yml) or TOML (.toml) file with configuration
+// parameters. Options read from file are overridden by 'TAROLAS_*' environment variables, and then
+// by '--set' command line flags. The configuration is validated and all found problems are printed.
 // When something goes wrong, the error message and usage is printed,
 // and then the file server terminates with exit code 1.
 func readConfiguration() (*Configuration, error) {
-	// prepare configuration flag
-	cfgFileName := flag.String("config", "", "path to configuration file")
 	flag.Parse()
 	// check if configuration file name is given
-	if *cfgFileName == "" {
-		cfgFileName = &defaultConfigurationFileName
+	if *configurationFileName == "" {
+		configurationFileName = &defaultConfigurationFileName
 	}
-	// try to read the configuration file
-	data, err := os.ReadFile(*cfgFileName)
+	cfg, err := loadConfiguration()
 	if err != nil {
-		fmt.Printf("error occured while reading tarolas configuration file\n%v\n\n", err)
+		slog.Error("tarolas configuration not loaded", slog.String("error", err.Error()))
 		showUsage()
 		return nil, err
 	}
-	// try to load JSON data read from configuration file
-	var cfg Configuration
-	err = json.Unmarshal(data, &cfg)
-	if err != nil {
-		fmt.Printf("error occured while parsing tarolas configuration file:\n%v\n\n", err)
-		showUsage()
+	// print the effective configuration without validation, so that problems may be investigated
+	if *printConfig {
+		data, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s\n", data)
+		return cfg, nil
+	}
+	// check all options, root directory access and free space
+	if err = cfg.Validate(); err != nil {
+		slog.Error("tarolas configuration is not valid", slog.String("error", err.Error()))
 		return nil, err
 	}
-	// TODO check here if root directory exists and the server has read/write/delete access in this directory
-	// TODO add read/write/delete checks
-	return &cfg, nil
+	// the same file and overrides are used when the configuration is reloaded
+	cfg.SetLoader(loadConfiguration)
+	return cfg, nil
 }
 
 // Function main starts the server.
 func main() {
 	var httpServer *http.Server
 	osSignals := make(chan os.Signal, 1)
-	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
-	if configuration, err := readConfiguration(); err == nil {
-		httpServer = StartServer(configuration)
-		sig := <-osSignals
-		fmt.Printf("\nTarolas received signal [%v], gracefully exiting...\n", sig)
-		StopServer(httpServer)
+	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	configuration, err := readConfiguration()
+	if err != nil {
+		os.Exit(1)
+	}
+	if *printConfig {
+		return
+	}
+	if *checkConfig {
+		slog.Info("tarolas configuration is valid")
+		return
+	}
+	httpServer = StartServer(configuration)
+	// reload the configuration on SIGHUP, exit on other signals
+	sig := <-osSignals
+	for sig == syscall.SIGHUP {
+		slog.Info("received signal, reloading configuration", slog.String("signal", sig.String()))
+		if err = ReloadConfiguration(configuration); err != nil {
+			slog.Error("configuration not reloaded, current configuration is kept", slog.String("error", err.Error()))
+		}
+		sig = <-osSignals
 	}
+	slog.Info("received signal, gracefully exiting", slog.String("signal", sig.String()))
+	StopServer(configuration, httpServer)
 }
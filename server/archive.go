@@ -0,0 +1,225 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	archiveFormatZip   = "zip"    // ZIP archive.
+	archiveFormatTar   = "tar"    // Uncompressed tar archive.
+	archiveFormatTarGz = "tar.gz" // Tar archive compressed with gzip.
+)
+
+// archiveContentTypes maps supported archive formats to content types.
+var archiveContentTypes = map[string]string{
+	archiveFormatZip:   "application/zip",
+	archiveFormatTar:   "application/x-tar",
+	archiveFormatTarGz: "application/gzip",
+}
+
+// archiveFilter decides which files are included in archive.
+// Patterns are matched (see path.Match) against the relative path of the file
+// and against its base name. When no include patterns are given, all files
+// are included. Exclude patterns take precedence over include patterns.
+type archiveFilter struct {
+	include []string
+	exclude []string
+}
+
+// matches returns true when the file with specified relative path should be included in archive.
+func (f *archiveFilter) matches(relativeName string) bool {
+	if matchesAny(f.exclude, relativeName) {
+		return false
+	}
+	return len(f.include) == 0 || matchesAny(f.include, relativeName)
+}
+
+// matchesAny returns true when any of the patterns matches the relative path or the base name.
+func matchesAny(patterns []string, relativeName string) bool {
+	baseName := path.Base(relativeName)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, relativeName); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, baseName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveEntryWriter writes single archive entry, the content is nil for directories.
+type archiveEntryWriter func(relativeName string, info os.FileInfo, content io.Reader) error
+
+// writeArchive streams the content of the directory with specified name as an archive
+// in requested format. Files are read and written one by one, nothing is buffered on disk.
+// Errors encountered after the response status was sent can only be logged.
+func writeArchive(cfg *Configuration, w http.ResponseWriter, name string, format string, filter *archiveFilter) *ErrorDto {
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		return errorDto(errInvalidParameterValue, "format ("+format+")")
+	}
+	fullName := prepareAbsolutePath(cfg, name)
+	if fileInfo, err := os.Stat(fullName); err != nil || !fileInfo.IsDir() {
+		return errorDto(errReadingDirectoryContentFailed, name)
+	}
+	archiveName := filepath.Base(fullName)
+	if fullName == cfg.RootDirectory {
+		archiveName = "root"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(archiveName+"."+format))
+	w.WriteHeader(200)
+	var writeEntry archiveEntryWriter
+	var closeArchive func() error
+	switch format {
+	case archiveFormatZip:
+		zipWriter := zip.NewWriter(w)
+		writeEntry = zipEntryWriter(zipWriter)
+		closeArchive = zipWriter.Close
+	case archiveFormatTar:
+		tarWriter := tar.NewWriter(w)
+		writeEntry = tarEntryWriter(tarWriter)
+		closeArchive = tarWriter.Close
+	case archiveFormatTarGz:
+		gzipWriter := gzip.NewWriter(w)
+		tarWriter := tar.NewWriter(gzipWriter)
+		writeEntry = tarEntryWriter(tarWriter)
+		closeArchive = func() error {
+			if err := tarWriter.Close(); err != nil {
+				return err
+			}
+			return gzipWriter.Close()
+		}
+	}
+	err := filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		if filePath == fullName {
+			return nil
+		}
+		relativeName := filepath.ToSlash(strings.TrimPrefix(filePath, fullName+string(filepath.Separator)))
+		if info.IsDir() {
+			if matchesAny(filter.exclude, relativeName) {
+				return filepath.SkipDir
+			}
+			// directories are written only when no include patterns are given, otherwise
+			// they are created implicitly by the paths of included files
+			if len(filter.include) == 0 {
+				return writeEntry(relativeName, info, nil)
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !filter.matches(relativeName) {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				logError(err)
+			}
+		}()
+		return writeEntry(relativeName, info, file)
+	})
+	if err == nil {
+		err = closeArchive()
+	}
+	if err != nil {
+		logError(err)
+	}
+	return nil
+}
+
+// zipEntryWriter creates entry writer for ZIP archives.
+func zipEntryWriter(zipWriter *zip.Writer) archiveEntryWriter {
+	return func(relativeName string, info os.FileInfo, content io.Reader) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = relativeName
+		if content == nil {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		entry, err := zipWriter.CreateHeader(header)
+		if err != nil || content == nil {
+			return err
+		}
+		// content appended while archiving is not included, sizes are calculated by the writer
+		if _, err = io.CopyN(entry, content, info.Size()); err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+}
+
+// tarEntryWriter creates entry writer for tar archives.
+func tarEntryWriter(tarWriter *tar.Writer) archiveEntryWriter {
+	return func(relativeName string, info os.FileInfo, content io.Reader) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = relativeName
+		if content == nil {
+			header.Name += "/"
+		}
+		if err = tarWriter.WriteHeader(header); err != nil || content == nil {
+			return err
+		}
+		// exactly the size written in the header is copied, even when the file was changed meanwhile,
+		// the content of the file that shrank is padded with zeros, so the archive stays readable
+		written, err := io.CopyN(tarWriter, content, header.Size)
+		if err == io.EOF {
+			_, err = io.CopyN(tarWriter, zeroReader{}, header.Size-written)
+		}
+		return err
+	}
+}
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+// Read fills the whole buffer with zeros.
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// contentDisposition returns the value of 'Content-Disposition' header of the attachment with specified
+// file name. The name is given in ASCII form and in UTF-8 encoded form for clients supporting RFC 6266.
+func contentDisposition(fileName string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+	var encoded strings.Builder
+	for _, b := range []byte(fileName) {
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			encoded.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return "attachment; filename=\"" + fallback + "\"; filename*=UTF-8''" + encoded.String()
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		fileName string
+		expected string
+	}{
+		{"root.zip", `attachment; filename="root.zip"; filename*=UTF-8''root.zip`},
+		{"my files.tar", `attachment; filename="my files.tar"; filename*=UTF-8''my%20files.tar`},
+		{`a"b\c;d.zip`, `attachment; filename="a_b_c;d.zip"; filename*=UTF-8''a%22b%5Cc%3Bd.zip`},
+		{"zdjęcia.tar.gz", `attachment; filename="zdj_cia.tar.gz"; filename*=UTF-8''zdj%C4%99cia.tar.gz`},
+		{"line\r\nbreak.zip", `attachment; filename="line__break.zip"; filename*=UTF-8''line%0D%0Abreak.zip`},
+	}
+	for _, test := range tests {
+		if actual := contentDisposition(test.fileName); actual != test.expected {
+			t.Errorf("%q: expected %q, actual %q", test.fileName, test.expected, actual)
+		}
+	}
+}
+
+// changedFileInfo returns information of the file written with specified content,
+// the file is then overwritten with the changed content.
+func changedFileInfo(t *testing.T, content string, changed string) os.FileInfo {
+	t.Helper()
+	fullName := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(fullName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fullName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(fullName, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestArchiveEntryOfGrowingFile(t *testing.T) {
+	info := changedFileInfo(t, "abc", "abcdef")
+
+	var tarArchive bytes.Buffer
+	tarWriter := tar.NewWriter(&tarArchive)
+	if err := tarEntryWriter(tarWriter)("a.txt", info, strings.NewReader("abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(&tarArchive)
+	if _, err := tarReader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := io.ReadAll(tarReader); err != nil || string(content) != "abc" {
+		t.Errorf("tar: expected content %q, actual %q (%v)", "abc", content, err)
+	}
+
+	var zipArchive bytes.Buffer
+	zipWriter := zip.NewWriter(&zipArchive)
+	if err := zipEntryWriter(zipWriter)("a.txt", info, strings.NewReader("abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(zipArchive.Bytes()), int64(zipArchive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := zipReader.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := io.ReadAll(entry); err != nil || string(content) != "abc" {
+		t.Errorf("zip: expected content %q, actual %q (%v)", "abc", content, err)
+	}
+}
+
+func TestArchiveEntryOfShrinkingFile(t *testing.T) {
+	info := changedFileInfo(t, "abcdef", "abc")
+
+	var tarArchive bytes.Buffer
+	tarWriter := tar.NewWriter(&tarArchive)
+	if err := tarEntryWriter(tarWriter)("a.txt", info, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarEntryWriter(tarWriter)("b.txt", info, strings.NewReader("abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// missing content is padded with zeros and the following entries stay readable
+	tarReader := tar.NewReader(&tarArchive)
+	for _, expected := range []string{"abc\x00\x00\x00", "abcdef"} {
+		if _, err := tarReader.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := io.ReadAll(tarReader); err != nil || string(content) != expected {
+			t.Errorf("tar: expected content %q, actual %q (%v)", expected, content, err)
+		}
+	}
+	if _, err := tarReader.Next(); err != io.EOF {
+		t.Errorf("tar: expected end of archive, actual %v", err)
+	}
+
+	zipWriter := zip.NewWriter(io.Discard)
+	if err := zipEntryWriter(zipWriter)("a.txt", info, strings.NewReader("abc")); err != nil {
+		t.Errorf("zip: %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	batchOpExists        = "exists"     // Checks if file exists.
+	batchOpDelete        = "delete"     // Deletes file.
+	batchOpMkdir         = "mkdir"      // Creates directory.
+	batchOpMove          = "move"       // Moves file or directory.
+	batchOpCopy          = "copy"       // Copies file or directory.
+	batchOpChecksum      = "checksum"   // Calculates file checksum.
+	batchOpWrite         = "write"      // Writes small content to file.
+	batchStatusOk        = "ok"         // Operation succeeded.
+	batchStatusFailed    = "failed"     // Operation failed.
+	batchStatusSkipped   = "skipped"    // Operation was not executed, because previous operation failed in atomic mode.
+	batchStatusReverted  = "rolledBack" // Operation succeeded, but was rolled back in atomic mode.
+	maxBatchOperations   = 1000         // Maximum number of operations in single batch.
+	maxBatchRequestSize  = 16 << 20     // Maximum size of batch request body in bytes.
+	maxBatchContentSize  = 1 << 20      // Maximum size of content written by single operation in bytes.
+	batchBackupDirPrefix = "batch-"     // Prefix of the temporary directory holding backups in atomic mode.
+)
+
+// BatchOperation defines single operation executed in batch.
+type BatchOperation struct {
+	Op      string `json:"op"                 api:"Operation: exists, delete, mkdir, move, copy, checksum or write."`
+	Name    string `json:"name"               api:"Name of the file or directory."`
+	Target  string `json:"target,omitempty"   api:"Name of the target file or directory for move and copy."`
+	All     bool   `json:"all,omitempty"      api:"Flag indicating if parent directories should be created by mkdir."`
+	Content string `json:"content,omitempty"  api:"Base64 encoded content written to file from its beginning, original content beyond its length is kept."`
+}
+
+// BatchResult stores the result of single operation executed in batch.
+type BatchResult struct {
+	Op        string     `json:"op"                   api:"Operation."`
+	Name      string     `json:"name"                 api:"Name of the file or directory."`
+	Status    string     `json:"status"               api:"Operation status: ok, failed, skipped or rolledBack."`
+	File      *File      `json:"file,omitempty"       api:"File details."`
+	Directory *Directory `json:"directory,omitempty"  api:"Directory details."`
+	Error     *ErrorDto  `json:"error,omitempty"      api:"Problem encountered while executing the operation."`
+}
+
+// BatchResultsDto is the implementation of DTO for batch results.
+type BatchResultsDto struct {
+	Data []*BatchResult `json:"data"  api:"Results of executed operations in the order of execution."`
+}
+
+// batchTransaction keeps the information required to undo operations executed in atomic mode.
+// When backup directory is empty, the batch is not atomic and nothing is undone.
+type batchTransaction struct {
+	cfg       *Configuration
+	actor     string   // Principal or client address executing the batch, owner of created files.
+	backupDir string   // Directory where backups of overwritten and deleted files are kept.
+	backups   int      // Number of backups created so far, used for naming backup files.
+	undo      []func() // Functions undoing successful operations, in the order of execution.
+}
+
+// atomic returns true when the operations should be undone after failure.
+func (t *batchTransaction) atomic() bool {
+	return t.backupDir != ""
+}
+
+// backup preserves the file with specified absolute name in backup directory and returns
+// the name of the backup. Hard link is used when possible, otherwise the file is copied.
+func (t *batchTransaction) backup(fullName string) (string, error) {
+	t.backups++
+	backupName := filepath.Join(t.backupDir, strconv.Itoa(t.backups))
+	if err := os.Link(fullName, backupName); err == nil {
+		return backupName, nil
+	}
+	return backupName, copyFile(fullName, backupName)
+}
+
+// rollback undoes all successful operations in reverse order.
+func (t *batchTransaction) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+// undoRename returns undo function that renames the file back to its original name.
+func undoRename(from, to string) func() {
+	return func() {
+		if err := os.Rename(from, to); err != nil {
+			logError(err)
+		}
+	}
+}
+
+// undoRemove returns undo function that removes created file or directory.
+func undoRemove(fullName string) func() {
+	return func() {
+		if err := os.RemoveAll(fullName); err != nil {
+			logError(err)
+		}
+	}
+}
+
+// batchExecute executes all operations in the given order. In atomic mode, the execution
+// stops at the first failed operation and all previously completed operations are rolled back.
+func batchExecute(cfg *Configuration, req *http.Request, atomic bool) ([]*BatchResult, *ErrorDto) {
+	defer func() {
+		if err := req.Body.Close(); err != nil {
+			logError(err)
+		}
+	}()
+	var operations []*BatchOperation
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxBatchRequestSize)).Decode(&operations); err != nil {
+		return nil, bodyError(err, errInvalidRequestBody, err.Error())
+	}
+	if len(operations) > maxBatchOperations {
+		return nil, errorDto(errInvalidRequestBody, "too many operations ("+strconv.Itoa(len(operations))+")")
+	}
+	transaction := batchTransaction{cfg: cfg, actor: requestActor(req)}
+	if atomic {
+		directory := filepath.Join(cfg.stateDirectory(), tmpDirectory)
+		var err error
+		if err = os.MkdirAll(directory, 0755); err == nil {
+			transaction.backupDir, err = os.MkdirTemp(directory, batchBackupDirPrefix)
+		}
+		if err != nil {
+			logError(err)
+			return nil, errorDto(errExecutingBatchFailed, errMsgCheckServerLogForDetails)
+		}
+		defer func() {
+			if err := os.RemoveAll(transaction.backupDir); err != nil {
+				logError(err)
+			}
+		}()
+	}
+	results := make([]*BatchResult, 0, len(operations))
+	failed := false
+	for _, operation := range operations {
+		result := &BatchResult{Op: operation.Op, Name: operation.Name}
+		results = append(results, result)
+		if failed && atomic {
+			result.Status = batchStatusSkipped
+			continue
+		}
+		result.File, result.Directory, result.Error = transaction.execute(operation)
+		if result.Error == nil {
+			result.Status = batchStatusOk
+		} else {
+			result.Status = batchStatusFailed
+			failed = true
+		}
+	}
+	if failed && atomic {
+		transaction.rollback()
+		for _, result := range results {
+			if result.Status == batchStatusOk {
+				result.Status = batchStatusReverted
+			}
+		}
+	}
+	return results, nil
+}
+
+// execute executes single operation.
+func (t *batchTransaction) execute(operation *BatchOperation) (*File, *Directory, *ErrorDto) {
+	if errorDto := checkName(t.cfg, operation.Name); errorDto != nil {
+		return nil, nil, errorDto
+	}
+	switch operation.Op {
+	case batchOpExists:
+		file, errorDto := fileExists(t.cfg, operation.Name)
+		return file, nil, errorDto
+	case batchOpChecksum:
+		file, errorDto := fileChecksum(t.cfg, operation.Name)
+		return file, nil, errorDto
+	case batchOpDelete:
+		file, errorDto := t.delete(operation.Name)
+		return file, nil, errorDto
+	case batchOpMkdir:
+		directory, errorDto := t.mkdir(operation.Name, operation.All)
+		return nil, directory, errorDto
+	case batchOpWrite:
+		file, errorDto := t.write(operation.Name, operation.Content)
+		return file, nil, errorDto
+	case batchOpMove, batchOpCopy:
+		if errorDto := checkName(t.cfg, operation.Target); errorDto != nil {
+			return nil, nil, errorDto
+		}
+		file, errorDto := t.moveOrCopy(operation.Name, operation.Target, operation.Op == batchOpMove)
+		return file, nil, errorDto
+	}
+	return nil, nil, errorDto(errUnknownBatchOperation, operation.Op)
+}
+
+// delete deletes the file. In atomic mode the file is backed up before deletion,
+// or moved to trash directly when trash is enabled, so it can be restored from there.
+func (t *batchTransaction) delete(name string) (*File, *ErrorDto) {
+	fullName := prepareAbsolutePath(t.cfg, name)
+	fileInfo, err := os.Stat(fullName)
+	if !t.atomic() || err != nil || fileInfo.IsDir() {
+		// nothing to undo, errors are reported the same way as for single file deletion
+		return fileDelete(t.cfg, name)
+	}
+	if t.cfg.Trash.Enabled {
+		item, err := moveToTrash(t.cfg, name, fileInfo)
+		if err != nil {
+			logError(err)
+			return nil, errorDto(errMovingToTrashFailed, name)
+		}
+		t.undo = append(t.undo, func() {
+			if _, errorDto := trashRestore(t.cfg, item.Id, "", t.actor); errorDto != nil {
+				logError(fmt.Errorf("%s: %s", errorDto.Title, errorDto.Detail))
+			}
+		})
+		size := fileInfo.Size()
+		return &File{Name: &name, Size: &size}, nil
+	}
+	backupName, err := t.backup(fullName)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errExecutingBatchFailed, name)
+	}
+	file, errorDto := fileDelete(t.cfg, name)
+	if errorDto == nil {
+		t.undo = append(t.undo, undoRename(backupName, fullName))
+	}
+	return file, errorDto
+}
+
+// mkdir creates the directory, in atomic mode the topmost created directory is removed on rollback.
+func (t *batchTransaction) mkdir(name string, all bool) (*Directory, *ErrorDto) {
+	fullName := prepareAbsolutePath(t.cfg, name)
+	topmost := fullName
+	for parent := filepath.Dir(topmost); parent != topmost; parent = filepath.Dir(topmost) {
+		if _, err := os.Stat(parent); err == nil {
+			break
+		}
+		topmost = parent
+	}
+	directory, errorDto := createDirectory(t.cfg, name, all)
+	if errorDto == nil && t.atomic() {
+		t.undo = append(t.undo, undoRemove(topmost))
+	}
+	return directory, errorDto
+}
+
+// write writes decoded content to the file, the same way as single file writing: the file
+// is overwritten from its beginning and the original content beyond the length of the new
+// content is kept. New content is written to a temporary file in state directory, which then
+// replaces the original file, so the backup stays intact.
+func (t *batchTransaction) write(name string, content string) (*File, *ErrorDto) {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, errorDto(errInvalidRequestBody, "content of "+name)
+	}
+	if len(data) > maxBatchContentSize {
+		return nil, errorDto(errInvalidRequestBody, "content of "+name+" is too large")
+	}
+	fullName := prepareAbsolutePath(t.cfg, name)
+	if info, err := os.Stat(filepath.Dir(fullName)); err != nil || !info.IsDir() {
+		return nil, errorDto(errOpeningFileForWritingFailed, name)
+	}
+	fileInfo, err := os.Stat(fullName)
+	exists := err == nil
+	if exists && fileInfo.IsDir() {
+		return nil, errorDto(errNotAFile, name)
+	}
+	finalSize := int64(len(data))
+	if exists {
+		finalSize = max(finalSize, fileInfo.Size())
+	}
+	reservation, quotaError := t.cfg.quotas.reserve([]quotaChange{{name: name, size: finalSize}}, t.actor)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	defer reservation.release()
+	var backupName string
+	if exists && t.atomic() {
+		if backupName, err = t.backup(fullName); err != nil {
+			logError(err)
+			return nil, errorDto(errExecutingBatchFailed, name)
+		}
+	}
+	temporary, err := createTemporaryFile(t.cfg, "batch-*")
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errOpeningFileForWritingFailed, name)
+	}
+	defer func() {
+		_ = temporary.Close()
+		_ = os.Remove(temporary.Name())
+	}()
+	if _, err = temporary.Write(data); err != nil {
+		logError(err)
+		return nil, errorDto(errWritingFileFailed, name)
+	}
+	if err = preserveVersion(t.cfg, name, versionReasonWrite, false); err != nil {
+		logError(err)
+		return nil, errorDto(errPreservingVersionFailed, name)
+	}
+	t.cfg.events.expect(name)
+	if err = replaceFile(temporary, fullName); err != nil {
+		logError(err)
+		return nil, errorDto(errWritingFileFailed, name)
+	}
+	reservation.commit()
+	if t.atomic() {
+		if exists {
+			t.undo = append(t.undo, undoRename(backupName, fullName))
+		} else {
+			t.undo = append(t.undo, undoRemove(fullName))
+		}
+	}
+	return &File{Name: &name, Size: &finalSize}, nil
+}
+
+// moveOrCopy moves or copies file or directory to target. Existing targets are never overwritten.
+func (t *batchTransaction) moveOrCopy(name string, target string, move bool) (*File, *ErrorDto) {
+	fullName := prepareAbsolutePath(t.cfg, name)
+	fullTarget := prepareAbsolutePath(t.cfg, target)
+	fileInfo, err := os.Stat(fullName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errorDto(errFileNotFound, name)
+		}
+		logError(err)
+		return nil, errorDto(errRetrievingFileInfoFailed, name)
+	}
+	if _, err := os.Lstat(fullTarget); err == nil {
+		return nil, errorDto(errFileAlreadyExists, target)
+	}
+	if fullName == t.cfg.RootDirectory || isSameOrParent(fullName, fullTarget) {
+		return nil, errorDto(errInvalidParameterValue, "target ("+target+")")
+	}
+	var reservation *quotaReservation
+	if !move {
+		// copied files are new content, the space is reserved before copying
+		changes, err := treeChanges(fullName, target)
+		if err != nil {
+			logError(err)
+			return nil, errorDto(errMovingOrCopyingFailed, name)
+		}
+		var quotaError *ErrorDto
+		if reservation, quotaError = t.cfg.quotas.reserve(changes, t.actor); quotaError != nil {
+			return nil, quotaError
+		}
+		defer reservation.release()
+	}
+	if move {
+		err = os.Rename(fullName, fullTarget)
+	} else {
+		err = copyTree(fullName, fullTarget)
+	}
+	if err != nil {
+		logError(err)
+		if !move {
+			_ = os.RemoveAll(fullTarget)
+		}
+		return nil, errorDto(errMovingOrCopyingFailed, name)
+	}
+	reservation.commit()
+	if t.atomic() {
+		if move {
+			t.undo = append(t.undo, undoRename(fullTarget, fullName))
+		} else {
+			t.undo = append(t.undo, undoRemove(fullTarget))
+		}
+	}
+	if fileInfo.IsDir() {
+		return &File{Name: &target}, nil
+	}
+	size := fileInfo.Size()
+	return &File{Name: &target, Size: &size}, nil
+}
+
+// isSameOrParent returns true when both absolute paths are equal
+// or the first one is a parent of the second one.
+func isSameOrParent(parent, child string) bool {
+	return child == parent || strings.HasPrefix(child, parent+string(filepath.Separator))
+}
+
+// copyTree copies file or directory with all its content.
+func copyTree(source, destination string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, relative)
+		if info.IsDir() {
+			return os.Mkdir(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// batchEvents returns change events for successfully executed operations.
+func batchEvents(results []*BatchResult) []*Event {
+	events := make([]*Event, 0)
+	for _, result := range results {
+		if result.Status != batchStatusOk {
+			continue
+		}
+		switch result.Op {
+		case batchOpDelete:
+			events = append(events, &Event{Type: EventDelete, Name: result.Name})
+		case batchOpMkdir:
+			events = append(events, &Event{Type: EventCreate, Name: result.Name, Directory: true})
+		case batchOpWrite:
+			events = append(events, &Event{Type: EventWrite, Name: result.Name, Size: *result.File.Size})
+		case batchOpMove:
+			events = append(events, &Event{Type: EventMove, Name: result.Name, Target: *result.File.Name, Directory: result.File.Size == nil})
+		case batchOpCopy:
+			events = append(events, &Event{Type: EventCreate, Name: *result.File.Name, Directory: result.File.Size == nil})
+		}
+	}
+	return events
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchWrite(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	fullName := filepath.Join(cfg.RootDirectory, "a.txt")
+	for _, atomic := range []bool{false, true} {
+		if err := os.WriteFile(fullName, []byte("abcdef"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(fullName, 0640); err != nil {
+			t.Fatal(err)
+		}
+		transaction := batchTransaction{cfg: cfg}
+		if atomic {
+			transaction.backupDir = t.TempDir()
+		}
+		file, errorDto := transaction.write("/a.txt", base64.StdEncoding.EncodeToString([]byte("xy")))
+		if errorDto != nil {
+			t.Fatal(errorDto)
+		}
+		// the file is overwritten from its beginning, the same way as by single file writing
+		if data, _ := os.ReadFile(fullName); string(data) != "xycdef" || *file.Size != 6 {
+			t.Errorf("atomic %v: unexpected content %q, size %d", atomic, data, *file.Size)
+		}
+		if info, err := os.Stat(fullName); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("atomic %v: permissions not kept: %v (%v)", atomic, info.Mode(), err)
+		}
+		// temporary files are not left in the directory of the written file
+		if entries, err := os.ReadDir(cfg.RootDirectory); err != nil || len(entries) != 2 {
+			t.Errorf("atomic %v: unexpected directory content %v (%v)", atomic, entries, err)
+		}
+		transaction.rollback()
+		if data, _ := os.ReadFile(fullName); string(data) != "xycdef" && !atomic || string(data) != "abcdef" && atomic {
+			t.Errorf("atomic %v: unexpected content after rollback %q", atomic, data)
+		}
+	}
+	transaction := batchTransaction{cfg: cfg}
+	if _, errorDto := transaction.write("/missing/a.txt", ""); errorDto == nil || errorDto.Code != errOpeningFileForWritingFailed.Code {
+		t.Errorf("expected opening file failure, actual %v", errorDto)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxCachedChecksums = 10000 // Number of cached checksums above which the cache is cleared.
+
+// cachedChecksum is the checksum of the file with the size and modification time it was calculated for.
+type cachedChecksum struct {
+	size     int64
+	modified time.Time
+	digest   string
+}
+
+// checksumCache holds checksums of recently read files, so that unchanged files are not read again.
+// Cached checksum is used only when the size and modification time of the file did not change,
+// checksums of files changed by handlers or detected by the watcher are evicted.
+type checksumCache struct {
+	cfg     *Configuration
+	mutex   sync.Mutex
+	entries map[string]cachedChecksum // Checksums by full file names.
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+func newChecksumCache(cfg *Configuration) *checksumCache {
+	return &checksumCache{cfg: cfg, entries: make(map[string]cachedChecksum)}
+}
+
+// update evicts checksums of the file, or of all files in the directory, changed by the event.
+func (c *checksumCache) update(event *Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if event.Type == EventOverflow {
+		c.entries = make(map[string]cachedChecksum)
+		return
+	}
+	for _, name := range []string{event.Name, event.Target} {
+		if name == "" {
+			continue
+		}
+		fullName := prepareAbsolutePath(c.cfg, name)
+		delete(c.entries, fullName)
+		if event.Directory || event.Type == EventDelete || event.Type == EventMove {
+			prefix := strings.TrimSuffix(fullName, string(filepath.Separator)) + string(filepath.Separator)
+			for cachedName := range c.entries {
+				if strings.HasPrefix(cachedName, prefix) {
+					delete(c.entries, cachedName)
+				}
+			}
+		}
+	}
+}
+
+// digest returns the SHA256 checksum of the opened file with specified full name.
+func (c *checksumCache) digest(fullName string, file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if c != nil {
+		c.mutex.Lock()
+		cached, ok := c.entries[fullName]
+		c.mutex.Unlock()
+		if ok && cached.size == info.Size() && cached.modified.Equal(info.ModTime()) {
+			c.hits.Add(1)
+			return cached.digest, nil
+		}
+		c.misses.Add(1)
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("%x", hash.Sum(nil))
+	if c != nil {
+		c.mutex.Lock()
+		if len(c.entries) >= maxCachedChecksums {
+			c.entries = make(map[string]cachedChecksum)
+		}
+		c.entries[fullName] = cachedChecksum{size: info.Size(), modified: info.ModTime(), digest: digest}
+		c.mutex.Unlock()
+	}
+	return digest, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumCacheEvictsChangedFiles(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cache := newChecksumCache(cfg)
+	for _, name := range []string{"/a.txt", "/dir/b.txt", "/dir/sub/c.txt", "/directory.txt"} {
+		cache.entries[prepareAbsolutePath(cfg, name)] = cachedChecksum{digest: name}
+	}
+	tests := []struct {
+		event    *Event
+		expected []string
+	}{
+		{&Event{Type: EventWrite, Name: "/missing.txt"}, []string{"/a.txt", "/dir/b.txt", "/dir/sub/c.txt", "/directory.txt"}},
+		{&Event{Type: EventMove, Name: "/dir/sub", Target: "/other", Directory: true}, []string{"/a.txt", "/dir/b.txt", "/directory.txt"}},
+		{&Event{Type: EventDelete, Name: "/dir"}, []string{"/a.txt", "/directory.txt"}},
+		{&Event{Type: EventAppend, Name: "/a.txt"}, []string{"/directory.txt"}},
+		{&Event{Type: EventOverflow}, []string{}},
+	}
+	for _, test := range tests {
+		cache.update(test.event)
+		if len(cache.entries) != len(test.expected) {
+			t.Errorf("%s %s: expected %d cached checksums, actual %d", test.event.Type, test.event.Name, len(test.expected), len(cache.entries))
+		}
+		for _, name := range test.expected {
+			if _, ok := cache.entries[prepareAbsolutePath(cfg, name)]; !ok {
+				t.Errorf("%s %s: checksum of %s evicted", test.event.Type, test.event.Name, name)
+			}
+		}
+	}
+}
+
+func TestChecksumCacheDetectsChangedFile(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cache := newChecksumCache(cfg)
+	fullName := filepath.Join(cfg.RootDirectory, "a.txt")
+	digest := func() string {
+		file, err := os.Open(fullName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		digest, err := cache.digest(fullName, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+	if err := os.WriteFile(fullName, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	first := digest()
+	if digest() != first || cache.hits.Load() != 1 {
+		t.Errorf("checksum of unchanged file not read from cache")
+	}
+	if err := os.WriteFile(fullName, []byte("xyz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.update(&Event{Type: EventWrite, Name: "/a.txt"})
+	if digest() == first {
+		t.Errorf("checksum of changed file read from cache")
+	}
+}
@@ -1,9 +1,14 @@
 package server
 
-import "fmt"
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
 
 const (
-	version = "0.0.8" // File server version.
+	version               = "0.0.8"    // File server version.
+	defaultStateDirectory = ".tarolas" // Name of the default state directory, created in root directory.
 )
 
 // Configuration structure stores all configuration options available for the server.
@@ -11,21 +16,298 @@ const (
 // RootDirectory defines the directory that is a parent for all other directories and files stored
 // on server. All directory or file names used in API calls should be relative to root directory.
 // UrlPrefix defines the prefix that will be prepended to all API endpoints.
+// StateDirectory defines the directory where the server keeps its own data, like file versions.
+// It must be on the same file system as the root directory, files are moved between them.
 type Configuration struct {
-	ServerPort    int    `json:"serverPort"`    // Port number on which the server will be waiting for requests.
-	RootDirectory string `json:"rootDirectory"` // Name of the root directory, where all content will be stored.
-	UrlPrefix     string `json:"urlPrefix"`     // Prefix that will be prepended to all API endpoints.
+	ServerPort     int                      `json:"serverPort"`     // Port number on which the server will be waiting for requests.
+	Listeners      []ListenerConfiguration  `json:"listeners"`      // Addresses and sockets on which the server will be waiting for requests, replace the port when given.
+	RootDirectory  string                   `json:"rootDirectory"`  // Name of the root directory, where all content will be stored.
+	UrlPrefix      string                   `json:"urlPrefix"`      // Prefix that will be prepended to all API endpoints.
+	StateDirectory string                   `json:"stateDirectory"` // Name of the directory for server's own data, defaults to '.tarolas' in root directory.
+	Versioning     VersioningConfiguration  `json:"versioning"`     // File versioning options.
+	Trash          TrashConfiguration       `json:"trash"`          // Trash bin options.
+	Extract        ExtractConfiguration     `json:"extract"`        // Archive extraction limits.
+	Watch          WatchConfiguration       `json:"watch"`          // Change notification options.
+	Webhooks       WebhooksConfiguration    `json:"webhooks"`       // Outgoing webhooks notified about changes.
+	Journal        JournalConfiguration     `json:"journal"`        // Journal of mutating operations.
+	Replication    ReplicationConfiguration `json:"replication"`    // Replication from primary server.
+	Search         SearchConfiguration      `json:"search"`         // Search options.
+	Usage          UsageConfiguration       `json:"usage"`          // Disk usage reporting options.
+	Auth           AuthConfiguration        `json:"auth"`           // Authentication of clients.
+	Quotas         []QuotaConfiguration     `json:"quotas"`         // Storage quotas.
+	Transfer       TransferConfiguration    `json:"transfer"`       // Upload size limits and bandwidth throttling.
+	RateLimits     []RateLimitConfiguration `json:"rateLimits"`     // Limits of request rates per route.
+	Tls            TlsConfiguration         `json:"tls"`            // TLS and client certificate options.
+	Timeouts       TimeoutsConfiguration    `json:"timeouts"`       // Connection and shutdown timeouts.
+	Cors           CorsConfiguration        `json:"cors"`           // Cross-origin resource sharing policy.
+	Logging        LoggingConfiguration     `json:"logging"`        // Application and access logging.
+	events         *eventHub                // Hub distributing change events, created when the server starts.
+	journal        *journal                 // Journal of mutating operations, opened when the server starts.
+	replication    *replica                 // Replica following the primary, started when the server starts.
+	search         *searchIndex             // Index of file names, built when the server starts.
+	usage          *usageCache              // Cached disk usage, created when the server starts.
+	quotas         *quotaTracker            // Usage of quotas, tracked when quotas are configured.
+	bandwidths     *principalBandwidths     // Bandwidth limits of principals, created when the server starts.
+	rateLimits     *rateLimiter             // Request rate limiter, created when rate limits are configured.
+	transfers      *transferTracker         // Requests being processed, created when the server starts.
+	live           *liveConfiguration       // Configuration currently used, replaced when reloaded.
+	checksums      *checksumCache           // Checksums of recently read files, created when the server starts.
+	metrics        *metrics                 // Statistics of processed requests, created when the server starts.
+	closers        []func()                 // Stop recording of changes, called when all requests are completed.
+}
+
+// VersioningConfiguration defines directories where previous content of overwritten
+// and deleted files is preserved, and how long the preserved versions are kept.
+type VersioningConfiguration struct {
+	Directories   []string `json:"directories"`   // Directories (including subdirectories) with enabled versioning.
+	KeepLast      int      `json:"keepLast"`      // Maximum number of versions kept for a single file, 0 means no limit.
+	KeepDays      int      `json:"keepDays"`      // Maximum age of versions in days, 0 means no limit.
+	PruneInterval int      `json:"pruneInterval"` // Interval in seconds between retention checks, defaults to one hour.
+}
+
+// TrashConfiguration defines whether deleted files and directories are moved to trash
+// instead of being deleted permanently, and how long they are kept in trash.
+type TrashConfiguration struct {
+	Enabled        bool `json:"enabled"`        // Flag indicating if deleted content is moved to trash.
+	ExpireDays     int  `json:"expireDays"`     // Number of days the content is kept in trash, defaults to 30, negative means forever.
+	ExpireInterval int  `json:"expireInterval"` // Interval in seconds between expiry checks, defaults to one hour.
+}
+
+// ExtractConfiguration defines limits protecting the server against archive bombs,
+// when archives uploaded by clients are extracted.
+type ExtractConfiguration struct {
+	MaxEntries   int   `json:"maxEntries"`   // Maximum number of entries in archive, defaults to 10000.
+	MaxTotalSize int64 `json:"maxTotalSize"` // Maximum total size of extracted content in bytes, defaults to 1GiB.
+}
+
+// WatchConfiguration defines how changes of files and directories are detected.
+// Changes made through tarolas API are always reported, changes made directly
+// in the file system are reported only when enabled.
+type WatchConfiguration struct {
+	FileSystem   bool `json:"fileSystem"`   // Flag indicating if changes made outside tarolas are detected.
+	PollInterval int  `json:"pollInterval"` // Interval in seconds between snapshots, when native notifications are not available, defaults to 2.
+	History      int  `json:"history"`      // Number of recent events kept for resuming subscribers, defaults to 1024.
+}
+
+// WebhooksConfiguration defines webhook subscriptions and how failed deliveries are retried.
+// Undelivered notifications are kept in state directory, so they survive server restarts.
+type WebhooksConfiguration struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"` // Webhook subscriptions.
+	MaxAttempts   int                   `json:"maxAttempts"`   // Maximum number of delivery attempts, defaults to 10.
+	RetryDelay    int                   `json:"retryDelay"`    // Delay in seconds before the first retry, doubled after each attempt, defaults to 1.
+	MaxRetryDelay int                   `json:"maxRetryDelay"` // Maximum delay in seconds between retries, defaults to one hour.
+	Timeout       int                   `json:"timeout"`       // Timeout in seconds of single delivery attempt, defaults to 10.
+}
+
+// WebhookSubscription defines single webhook notified with POST request about changes.
+type WebhookSubscription struct {
+	Id        string   `json:"id"`                   // Identifier of the subscription, defaults to its URL.
+	Url       string   `json:"url"`                  // URL receiving notifications.
+	Directory string   `json:"directory"`            // Only changes in this directory and its subdirectories are notified, all when empty.
+	Events    []string `json:"events"`               // Types of notified events (create, write, append, delete, move), all when empty.
+	Secret    string   `json:"secret" secret:"true"` // Secret used to sign notifications with HMAC SHA256.
+}
+
+// JournalConfiguration defines whether mutating operations are recorded in the journal,
+// and how the journal segments are rotated.
+type JournalConfiguration struct {
+	Enabled        bool  `json:"enabled"`        // Flag indicating if mutating operations are recorded.
+	MaxSegmentSize int64 `json:"maxSegmentSize"` // Size in bytes after which new segment is started, defaults to 64MiB.
+	MaxSegments    int   `json:"maxSegments"`    // Number of kept segments, the oldest are removed, 0 means all are kept.
+}
+
+// ReplicationConfiguration defines the primary server followed by this server as a replica.
+// Replica applies changes recorded in the journal of the primary and rejects changes
+// requested by clients, until it is promoted. The primary must have the journal enabled.
+type ReplicationConfiguration struct {
+	Primary      string `json:"primary"`              // URL of the primary server including URL prefix, server is a replica when not empty.
+	PollInterval int    `json:"pollInterval"`         // Interval in seconds between polls of the primary journal, defaults to 1.
+	BatchSize    int    `json:"batchSize"`            // Number of journal entries read in single request, defaults to 1000.
+	Timeout      int    `json:"timeout"`              // Timeout in seconds of single request to the primary, defaults to 60.
+	ApiKey       string `json:"apiKey" secret:"true"` // API key sent to the primary, when the primary requires authentication.
+}
+
+// SearchConfiguration defines how files are searched. When the index is enabled, names and attributes
+// of all files are kept in memory, so queries not searching file content do not walk the directory tree.
+type SearchConfiguration struct {
+	Index          bool  `json:"index"`          // Flag indicating if the name index is built.
+	IndexInterval  int   `json:"indexInterval"`  // Interval in seconds between rebuilds of the index, defaults to 300.
+	Workers        int   `json:"workers"`        // Number of files searched for content concurrently, defaults to the number of CPUs.
+	MaxContentSize int64 `json:"maxContentSize"` // Maximum size of files searched for content in bytes, defaults to 16MiB.
+}
+
+// UsageConfiguration defines how long the cached disk usage is used, before it is read from disk again.
+type UsageConfiguration struct {
+	CacheTtl int `json:"cacheTtl"` // Number of seconds after which the usage is read from disk again, defaults to 300.
+}
+
+// AuthConfiguration defines API keys identifying principals. Requests without valid credentials
+// are processed anonymously, unless authentication is required.
+type AuthConfiguration struct {
+	Required bool                  `json:"required"` // Flag indicating if requests without valid credentials are rejected.
+	Keys     []ApiKeyConfiguration `json:"keys"`     // API keys of principals.
+	Admins   []string              `json:"admins"`   // Principals allowed to use administrative endpoints (configuration reload, trash purge, journal, replica promotion).
+	Exempt   []string              `json:"exempt"`   // Routes processed without required authentication, defaults to health, readiness and version.
+}
+
+// ApiKeyConfiguration defines single API key, sent as bearer token or in 'X-Api-Key' header.
+type ApiKeyConfiguration struct {
+	Principal string `json:"principal"`         // Name of the principal identified by the key.
+	Key       string `json:"key" secret:"true"` // Secret key.
+}
+
+// QuotaConfiguration defines limits of total size and number of files owned by the principal,
+// stored in the directory, or both when both are given. The owner of a file is the principal
+// (or the client address, when not authenticated) who created it.
+type QuotaConfiguration struct {
+	Principal string `json:"principal"` // Principal whose files are limited, all principals when empty.
+	Directory string `json:"directory"` // Directory (including subdirectories) whose content is limited, whole store when empty.
+	MaxBytes  int64  `json:"maxBytes"`  // Maximum total size of files in bytes, 0 means no limit.
+	MaxFiles  int64  `json:"maxFiles"`  // Maximum number of files, 0 means no limit.
+}
+
+// TransferConfiguration defines the maximum size of request bodies and limits of transfer rates.
+// Connection rates are shared by all requests sent over single connection, principal rates
+// by all requests of single principal (or single client address, when not authenticated).
+// Download rates apply to reading files, shared files and archives.
+type TransferConfiguration struct {
+	MaxUploadSize          int64            `json:"maxUploadSize"`          // Maximum size of request body in bytes, 0 means no limit. File content is sent base64 encoded, so the body is about 4/3 of the file size.
+	MaxUploadSizes         map[string]int64 `json:"maxUploadSizes"`         // Maximum size of request body by route (e.g. '/file/write'), overrides the global limit.
+	ConnectionUploadRate   int64            `json:"connectionUploadRate"`   // Maximum upload rate of single connection in bytes per second, 0 means no limit.
+	ConnectionDownloadRate int64            `json:"connectionDownloadRate"` // Maximum download rate of single connection in bytes per second, 0 means no limit.
+	PrincipalUploadRate    int64            `json:"principalUploadRate"`    // Maximum upload rate of single principal in bytes per second, 0 means no limit.
+	PrincipalDownloadRate  int64            `json:"principalDownloadRate"`  // Maximum download rate of single principal in bytes per second, 0 means no limit.
 }
 
-// DisplaySummary prints current configuration setting to standard output.
+// RateLimitConfiguration defines the number of requests per second single client (principal,
+// or client address when not authenticated) may send to the route. Requests above the limit
+// are rejected with status 429. The rule without route applies to all routes without own rule,
+// requests to all these routes are counted together.
+type RateLimitConfiguration struct {
+	Route string  `json:"route"` // Limited route (e.g. '/file/exists'), all routes without own rule when empty.
+	Rate  float64 `json:"rate"`  // Number of requests per second.
+	Burst int     `json:"burst"` // Number of requests allowed at once, defaults to the rate rounded up.
+}
+
+// TlsConfiguration defines the certificate of the server, when given, requests are served over HTTPS.
+// Rotated certificates are reloaded without restart. Client certificates may be verified against
+// the bundle of certificate authorities, subjects of verified certificates identify principals.
+type TlsConfiguration struct {
+	CertFile            string                            `json:"certFile"`            // Name of PEM file with server certificate chain, HTTPS is enabled when not empty.
+	KeyFile             string                            `json:"keyFile"`             // Name of PEM file with server private key.
+	ReloadInterval      int                               `json:"reloadInterval"`      // Interval in seconds between checks of rotated certificates, defaults to 10.
+	ClientAuth          string                            `json:"clientAuth"`          // Client certificate verification (optional, required), disabled when empty.
+	ClientCaFile        string                            `json:"clientCaFile"`        // Name of PEM file with certificate authorities of client certificates.
+	Subjects            []CertificateSubjectConfiguration `json:"subjects"`            // Principals identified by client certificate subjects.
+	CommonNamePrincipal bool                              `json:"commonNamePrincipal"` // Flag indicating if the common name of unmapped client certificate is the principal.
+}
+
+// CertificateSubjectConfiguration maps the subject of client certificate to principal.
+type CertificateSubjectConfiguration struct {
+	Subject   string `json:"subject"`   // Distinguished name (e.g. 'CN=backup,O=Example') or common name of the subject.
+	Principal string `json:"principal"` // Name of the principal identified by the certificate.
+}
+
+// TimeoutsConfiguration defines timeouts of client connections and how long the server waits
+// for requests being processed when shutting down. Read and write timeouts limit the whole
+// request, so they should allow transferring the largest expected files.
+type TimeoutsConfiguration struct {
+	ReadHeader int `json:"readHeader"` // Number of seconds for reading request headers, 0 means no limit.
+	Read       int `json:"read"`       // Number of seconds for reading the whole request, 0 means no limit.
+	Write      int `json:"write"`      // Number of seconds for writing the response, 0 means no limit.
+	Idle       int `json:"idle"`       // Number of seconds idle keep-alive connection is kept open, defaults to read timeout.
+	Shutdown   int `json:"shutdown"`   // Number of seconds the server waits for requests being processed when shutting down, defaults to 30.
+}
+
+// LoggingConfiguration defines the level and format of log records, and the file they are written to.
+// Records are written to standard error when no file is given.
+type LoggingConfiguration struct {
+	Level    string `json:"level"`    // Minimal level of logged records: 'debug', 'info' (default), 'warn' or 'error'.
+	Format   string `json:"format"`   // Format of log records: 'text' (default) or 'json'.
+	Access   bool   `json:"access"`   // Flag indicating if each request is logged (at 'info' level).
+	File     string `json:"file"`     // Name of the log file.
+	MaxSize  int64  `json:"maxSize"`  // Size of the log file in bytes, after which the file is rotated, defaults to 100 MiB.
+	MaxFiles int    `json:"maxFiles"` // Number of rotated log files kept, defaults to 5.
+}
+
+// CorsConfiguration defines which web pages (origins) may send cross-origin requests.
+// No CORS headers are sent when no origins are allowed.
+type CorsConfiguration struct {
+	Origins        []string `json:"origins"`        // Allowed origins, exact or with '*' wildcards, e.g. 'https://*.example.com', '*' allows any origin.
+	Methods        []string `json:"methods"`        // Allowed methods, all methods accepted by routes are allowed when empty.
+	Headers        []string `json:"headers"`        // Allowed request headers, common headers and API key headers are allowed when empty.
+	ExposedHeaders []string `json:"exposedHeaders"` // Response headers exposed to scripts.
+	Credentials    bool     `json:"credentials"`    // Flag indicating if requests with credentials (cookies, client certificates) are allowed.
+	MaxAge         int      `json:"maxAge"`         // Number of seconds preflight response may be cached, 0 means not specified.
+}
+
+// ListenerConfiguration defines single address or socket on which the server waits for requests.
+// Exactly one of TCP address, Unix socket or inherited systemd socket should be given.
+type ListenerConfiguration struct {
+	Address string `json:"address"` // TCP address in host:port form, e.g. '127.0.0.1:8080'.
+	Socket  string `json:"socket"`  // Path of Unix socket.
+	Mode    string `json:"mode"`    // Octal mode of Unix socket file, e.g. '0660'.
+	Owner   string `json:"owner"`   // User name or identifier owning Unix socket file.
+	Group   string `json:"group"`   // Group name or identifier owning Unix socket file.
+	Systemd bool   `json:"systemd"` // Flag indicating if sockets passed by systemd socket activation are used.
+	Name    string `json:"name"`    // Name of systemd socket (FileDescriptorName), all not used by other listeners when empty.
+}
+
+// describe returns the human readable description of the listener.
+func (l *ListenerConfiguration) describe() string {
+	switch {
+	case l.Systemd && l.Name != "":
+		return "systemd socket " + l.Name
+	case l.Systemd:
+		return "systemd sockets"
+	case l.Socket != "":
+		return "unix:" + l.Socket
+	}
+	return l.Address
+}
+
+// stateDirectory returns the absolute path to the directory where the server keeps its own data.
+func (c *Configuration) stateDirectory() string {
+	if c.StateDirectory == "" {
+		return filepath.Join(c.RootDirectory, defaultStateDirectory)
+	}
+	return filepath.Clean(c.StateDirectory)
+}
+
+// isStatePath returns true when the given absolute path points to the state directory or its content.
+func (c *Configuration) isStatePath(fullName string) bool {
+	stateDirectory := c.stateDirectory()
+	return fullName == stateDirectory || strings.HasPrefix(fullName, stateDirectory+string(filepath.Separator))
+}
+
+// DisplaySummary logs current configuration settings.
 func (c *Configuration) DisplaySummary() {
-	fmt.Printf("Tarolas - the lightweight file server v%s\n", version)
-	fmt.Printf("  configuration:\n")
-	fmt.Printf("    - port           : %d\n", c.ServerPort)
-	fmt.Printf("    - root directory : %s\n", c.RootDirectory)
-	urlPrefix := c.UrlPrefix
-	if urlPrefix == "" {
-		urlPrefix = "(none)"
-	}
-	fmt.Printf("    - URL prefix     : %s\n", urlPrefix)
+	attributes := make([]any, 0, 10)
+	attributes = append(attributes, slog.String("version", version))
+	if len(c.Listeners) == 0 {
+		attributes = append(attributes, slog.Int("port", c.ServerPort))
+	}
+	for i := range c.Listeners {
+		attributes = append(attributes, slog.String("listener", c.Listeners[i].describe()))
+	}
+	attributes = append(attributes,
+		slog.String("rootDirectory", c.RootDirectory),
+		slog.String("urlPrefix", c.UrlPrefix),
+		slog.String("stateDirectory", c.stateDirectory()))
+	if len(c.Versioning.Directories) > 0 {
+		attributes = append(attributes, slog.Any("versioning", c.Versioning.Directories))
+	}
+	if c.Trash.Enabled {
+		attributes = append(attributes, slog.Bool("trash", true))
+	}
+	if c.Journal.Enabled {
+		attributes = append(attributes, slog.Bool("journal", true))
+	}
+	if c.Tls.CertFile != "" {
+		attributes = append(attributes, slog.String("tls", c.Tls.CertFile))
+	}
+	if c.Replication.Primary != "" {
+		attributes = append(attributes, slog.String("replicaOf", c.Replication.Primary))
+	}
+	slog.Info("Tarolas - the lightweight file server started", attributes...)
 }
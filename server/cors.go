@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultCorsHeaders lists request headers allowed in cross-origin requests, when not configured.
+var defaultCorsHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization", "X-Api-Key"}
+
+// allowsOrigin returns true when the origin matches any of allowed origins. Origins are matched
+// exactly or with '*' wildcards not crossing slashes, e.g. 'https://*.example.com', single '*' allows any origin.
+func (c *CorsConfiguration) allowsOrigin(origin string) bool {
+	for _, pattern := range c.Origins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(origin)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMethod returns true when cross-origin requests may use the method accepted by the route.
+func (c *CorsConfiguration) allowsMethod(method string) bool {
+	if len(c.Methods) == 0 {
+		return true
+	}
+	for _, allowed := range c.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// headers returns request headers allowed in cross-origin requests.
+func (c *CorsConfiguration) headers() []string {
+	if len(c.Headers) == 0 {
+		return defaultCorsHeaders
+	}
+	return c.Headers
+}
+
+// allowsHeaders returns true when all headers listed in preflight request are allowed.
+func (c *CorsConfiguration) allowsHeaders(requested string) bool {
+	for _, name := range strings.Split(requested, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		allowed := false
+		for _, header := range c.headers() {
+			if header == "*" || strings.EqualFold(header, name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// applyCors sets CORS headers of the response to request sent to the route accepting specified method.
+// Preflight requests are answered here, false is returned when the request is completely processed.
+// Requests from origins not allowed get no CORS headers, so browsers reject them.
+func applyCors(cfg *Configuration, method string, w http.ResponseWriter, req *http.Request) bool {
+	cors := &cfg.Cors
+	origin := req.Header.Get("Origin")
+	if len(cors.Origins) > 0 {
+		w.Header().Add("Vary", "Origin")
+	}
+	allowed := origin != "" && cors.allowsOrigin(origin) && cors.allowsMethod(method)
+	if req.Method != HttpOPTIONS {
+		if allowed {
+			setCorsOrigin(cors, w, origin)
+			if len(cors.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			}
+		}
+		return true
+	}
+	// preflight request, the route accepts single method
+	w.Header().Set("Allow", method+", "+HttpOPTIONS)
+	requestedMethod := req.Header.Get("Access-Control-Request-Method")
+	if allowed && requestedMethod == method && cors.allowsHeaders(req.Header.Get("Access-Control-Request-Headers")) {
+		setCorsOrigin(cors, w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", method)
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.headers(), ", "))
+		if cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
+
+// setCorsOrigin sets the origin and credentials allowed in the response.
+func setCorsOrigin(cors *CorsConfiguration, w http.ResponseWriter, origin string) {
+	if len(cors.Origins) == 1 && cors.Origins[0] == "*" && !cors.Credentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if cors.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -52,6 +53,9 @@ func directoryTree(cfg *Configuration) (*Directory, error) {
 		if err != nil {
 			return err
 		}
+		if cfg.isStatePath(path) {
+			return filepath.SkipDir
+		}
 		if path != rootDirectory {
 			parentDir := filepath.Dir(path)
 			if info.IsDir() {
@@ -82,6 +86,9 @@ func directoryList(cfg *Configuration, name string) ([]string, *ErrorDto) {
 		if err != nil {
 			return err
 		}
+		if cfg.isStatePath(path) {
+			return filepath.SkipDir
+		}
 		if path != fullName {
 			if info.IsDir() {
 				dirList = append(dirList, strings.TrimPrefix(path, fullName))
@@ -109,6 +116,9 @@ func directoryContent(cfg *Configuration, name string) (*Directory, *ErrorDto) {
 		return nil, errorDto(errReadingDirectoryContentFailed, name)
 	}
 	for _, fileEntry := range entries {
+		if cfg.isStatePath(filepath.Join(fullName, fileEntry.Name())) {
+			continue
+		}
 		fileInfo, err := fileEntry.Info()
 		if err != nil {
 			return nil, errorDto(errReadingDirectoryContentFailed, name)
@@ -148,12 +158,19 @@ func createDirectory(cfg *Configuration, name string, all bool) (*Directory, *Er
 // If 'all' flag is 'false' the directory must be empty. Deleting root directory has no effect.
 // If 'all' flag is 'true' the directory may contain other directories or files.
 // Deleting root directory removes all its content omitting root directory itself.
+// When trash is enabled, deleted content is moved to trash instead of being removed.
 func deleteDirectory(cfg *Configuration, name string, all bool) (*Directory, *ErrorDto) {
 	fullName := prepareAbsolutePath(cfg, name)
+	if all && cfg.Trash.Enabled {
+		return deleteDirectoryToTrash(cfg, name)
+	}
 	if all {
 		if fileInfos, err := ioutil.ReadDir(fullName); err == nil {
 			for _, fileInfo := range fileInfos {
-				fileName := prepareAbsolutePath(cfg, fileInfo.Name())
+				fileName := filepath.Join(fullName, fileInfo.Name())
+				if cfg.isStatePath(fileName) {
+					continue
+				}
 				if fileInfo.IsDir() {
 					if err = os.RemoveAll(fileName); err != nil {
 						return nil, errorDto(errDeletingDirectoryFailed, fileName)
@@ -177,3 +194,40 @@ func deleteDirectory(cfg *Configuration, name string, all bool) (*Directory, *Er
 		return nil, errorDto(errDeletingDirectoryFailed, name)
 	}
 }
+
+// deleteDirectoryToTrash moves directory with specified name and all its content to trash.
+// Deleting root directory moves each of its children to trash as separate item,
+// omitting root directory itself.
+func deleteDirectoryToTrash(cfg *Configuration, name string) (*Directory, *ErrorDto) {
+	fullName := prepareAbsolutePath(cfg, name)
+	fileInfo, err := os.Stat(fullName)
+	if err != nil || !fileInfo.IsDir() {
+		return nil, errorDto(errReadingDirectoryContentFailed, name)
+	}
+	if fullName != cfg.RootDirectory {
+		if _, err := moveToTrash(cfg, name, fileInfo); err != nil {
+			logError(err)
+			return nil, errorDto(errMovingToTrashFailed, name)
+		}
+		return &Directory{Name: filepath.Base(fullName)}, nil
+	}
+	entries, err := os.ReadDir(fullName)
+	if err != nil {
+		return nil, errorDto(errReadingDirectoryContentFailed, name)
+	}
+	for _, entry := range entries {
+		if cfg.isStatePath(filepath.Join(fullName, entry.Name())) {
+			continue
+		}
+		childName := path.Join(name, entry.Name())
+		childInfo, err := entry.Info()
+		if err == nil {
+			_, err = moveToTrash(cfg, childName, childInfo)
+		}
+		if err != nil {
+			logError(err)
+			return nil, errorDto(errMovingToTrashFailed, childName)
+		}
+	}
+	return &Directory{Name: RootSymbol}, nil
+}
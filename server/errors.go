@@ -1,6 +1,10 @@
 package server
 
-import "log"
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
 
 const (
 	errMsgCheckServerLogForDetails = "check server log for details"
@@ -32,6 +36,41 @@ var (
 	errNotAFile                        = ErrorDto{"400", "10462", "not a file", ""}
 	errInvalidParameterValue           = ErrorDto{"400", "10901", "invalid parameter value", ""}
 	errWalkingDirectoryTreeFailed      = ErrorDto{"400", "10177", "walking directory tree failed", ""}
+	errReservedName                    = ErrorDto{"400", "10512", "name refers to reserved server area", ""}
+	errReadingVersionsFailed           = ErrorDto{"400", "10524", "reading file versions failed", ""}
+	errVersionNotFound                 = ErrorDto{"400", "10531", "version not found", ""}
+	errPreservingVersionFailed         = ErrorDto{"400", "10537", "preserving file version failed", ""}
+	errRestoringVersionFailed          = ErrorDto{"400", "10543", "restoring file version failed", ""}
+	errMovingToTrashFailed             = ErrorDto{"400", "10551", "moving to trash failed", ""}
+	errReadingTrashFailed              = ErrorDto{"400", "10557", "reading trash content failed", ""}
+	errTrashItemNotFound               = ErrorDto{"400", "10562", "trash item not found", ""}
+	errRestoreTargetExists             = ErrorDto{"400", "10568", "restore target already exists", ""}
+	errRestoringTrashItemFailed        = ErrorDto{"400", "10573", "restoring trash item failed", ""}
+	errPurgingTrashFailed              = ErrorDto{"400", "10579", "purging trash failed", ""}
+	errExtractingArchiveFailed         = ErrorDto{"400", "10584", "extracting archive failed", ""}
+	errArchiveLimitExceeded            = ErrorDto{"400", "10589", "archive limit exceeded", ""}
+	errUnsafeArchiveEntry              = ErrorDto{"400", "10593", "unsafe archive entry", ""}
+	errFileAlreadyExists               = ErrorDto{"400", "10598", "file already exists", ""}
+	errFileOrDirectoryTypeConflict     = ErrorDto{"400", "10603", "file and directory with the same name", ""}
+	errInvalidRequestBody              = ErrorDto{"400", "10611", "invalid request body", ""}
+	errUnknownBatchOperation           = ErrorDto{"400", "10617", "unknown batch operation", ""}
+	errExecutingBatchFailed            = ErrorDto{"400", "10622", "executing batch failed", ""}
+	errMovingOrCopyingFailed           = ErrorDto{"400", "10628", "moving or copying failed", ""}
+	errWatchingNotAvailable            = ErrorDto{"400", "10634", "watching not available", ""}
+	errJournalNotEnabled               = ErrorDto{"400", "10641", "journal not enabled", ""}
+	errReadingJournalFailed            = ErrorDto{"400", "10647", "reading journal failed", ""}
+	errReadOnlyReplica                 = ErrorDto{"403", "10652", "server is a read-only replica", ""}
+	errNotAReplica                     = ErrorDto{"400", "10658", "server is not a replica", ""}
+	errPromotingReplicaFailed          = ErrorDto{"400", "10663", "promoting replica failed", ""}
+	errReadingStatsFailed              = ErrorDto{"400", "10671", "reading storage statistics failed", ""}
+	errUnauthorized                    = ErrorDto{"401", "10677", "valid credentials required", ""}
+	errQuotaExceeded                   = ErrorDto{"413", "10682", "quota exceeded", ""}
+	errRequestTooLarge                 = ErrorDto{"413", "10689", "request body too large", ""}
+	errTooManyRequests                 = ErrorDto{"429", "10694", "too many requests", ""}
+	errServerDraining                  = ErrorDto{"503", "10697", "server is shutting down", ""}
+	errReloadingConfigurationFailed    = ErrorDto{"400", "10701", "reloading configuration failed", ""}
+	errAdministratorRequired           = ErrorDto{"403", "10703", "administrator privileges required", ""}
+	errServerNotReady                  = ErrorDto{"503", "10709", "server is not ready", ""}
 )
 
 type ErrorDto struct {
@@ -61,5 +100,11 @@ func errorsDto(errorDto *ErrorDto) *ErrorsDto {
 
 // TODO add documentation
 func logError(err error) {
-	_ = log.Output(2, err.Error())
+	slog.Error(err.Error())
+}
+
+// logFatal logs the error and terminates the server.
+func logFatal(err error) {
+	slog.Error(fmt.Sprint(err))
+	os.Exit(1)
 }
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	EventCreate          = "create"        // File or directory was created.
+	EventWrite           = "write"         // File content was written.
+	EventAppend          = "append"        // File content was appended.
+	EventDelete          = "delete"        // File or directory was deleted.
+	EventMove            = "move"          // File or directory was moved.
+	EventOverflow        = "overflow"      // Some events were lost, subscriber should resynchronize.
+	eventSourceApi       = "api"           // Event emitted by tarolas handlers.
+	eventSourceFs        = "filesystem"    // Event detected by watching the file system.
+	eventSourceReplica   = "replication"   // Event emitted by replica applying changes made on the primary.
+	defaultEventHistory  = 1024            // Default number of recent events kept for resuming subscribers.
+	subscriberBufferSize = 256             // Number of events buffered for single subscriber.
+	apiEventSuppression  = 2 * time.Second // Minimal period in which file system events duplicating handler events are skipped.
+)
+
+// Event describes single change of a file or directory.
+type Event struct {
+	Id        uint64    `json:"id"                   api:"Sequential event identifier."`
+	Type      string    `json:"type"                 api:"Event type: create, write, append, delete, move or overflow."`
+	Name      string    `json:"name"                 api:"Name of the changed file or directory."`
+	Target    string    `json:"target,omitempty"     api:"New name of moved file or directory."`
+	Directory bool      `json:"directory,omitempty"  api:"Flag indicating if the changed item is a directory."`
+	Size      int64     `json:"size,omitempty"       api:"File size after the change."`
+	Digest    string    `json:"digest,omitempty"     api:"File checksum (SHA256) after the change, when journal is enabled."`
+	Actor     string    `json:"actor,omitempty"      api:"Principal or client address that made the change."`
+	Time      time.Time `json:"time"                 api:"The time when the change was registered."`
+	Source    string    `json:"source"               api:"Event source: api, filesystem or replication."`
+}
+
+// eventSubscriber receives events published by event hub.
+type eventSubscriber struct {
+	events chan *Event
+	closed bool
+}
+
+// eventListener receives published events in its own goroutine. Events are queued
+// without limit, so publishing never waits for the listener and no event is lost.
+type eventListener struct {
+	mutex  sync.Mutex
+	queue  []*Event      // Events not yet processed by the listener.
+	wake   chan struct{} // Signals that events were queued or the listener was stopped.
+	closed bool          // No more events are queued.
+	done   chan struct{} // Closed when all events were processed.
+}
+
+// push queues the event for the listener.
+func (l *eventListener) push(event *Event) {
+	l.mutex.Lock()
+	l.queue = append(l.queue, event)
+	l.mutex.Unlock()
+	l.signal()
+}
+
+// stop lets the listener finish after all queued events are processed.
+func (l *eventListener) stop() {
+	l.mutex.Lock()
+	l.closed = true
+	l.mutex.Unlock()
+	l.signal()
+}
+
+// signal wakes up the listener goroutine, when it is not already woken up.
+func (l *eventListener) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run calls the function for every queued event, until the listener is stopped.
+func (l *eventListener) run(function func(*Event)) {
+	defer close(l.done)
+	for {
+		l.mutex.Lock()
+		events, closed := l.queue, l.closed
+		l.queue = nil
+		l.mutex.Unlock()
+		for _, event := range events {
+			function(event)
+		}
+		if len(events) == 0 {
+			if closed {
+				return
+			}
+			<-l.wake
+		}
+	}
+}
+
+// eventHub distributes events to subscribers and keeps the history of recent events,
+// so subscribers can resume from the last received event.
+type eventHub struct {
+	mutex       sync.Mutex
+	nextId      uint64
+	history     []*Event                      // Ring buffer of recent events.
+	subscribers map[*eventSubscriber]struct{} // Currently connected subscribers.
+	apiChanges  map[string]time.Time          // Names recently changed by tarolas handlers.
+	suppression time.Duration                 // Period in which file system events duplicating handler events are skipped.
+	listeners   []*eventListener              // Listeners receiving every published event.
+	recorders   []func(*Event)                // Functions called for every event before publishing completes.
+	recording   sync.Mutex                    // Keeps recorders called in the order of publishing.
+	closed      bool
+}
+
+// newEventHub creates event hub keeping specified number of recent events.
+// File system events duplicating handler events are skipped during the suppression period.
+func newEventHub(historySize int, suppression time.Duration) *eventHub {
+	if historySize <= 0 {
+		historySize = defaultEventHistory
+	}
+	if suppression < apiEventSuppression {
+		suppression = apiEventSuppression
+	}
+	return &eventHub{
+		suppression: suppression,
+		nextId:      1,
+		history:     make([]*Event, 0, historySize),
+		subscribers: make(map[*eventSubscriber]struct{}),
+		apiChanges:  make(map[string]time.Time),
+	}
+}
+
+// publish assigns the identifier to the event and delivers it to all subscribers.
+// Subscribers that can not keep up are disconnected, they may resume later
+// using the identifier of the last received event. Recorders are called
+// before returning, so the change is recorded before the request is completed.
+func (h *eventHub) publish(event *Event) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	if !h.deliver(event) {
+		h.mutex.Unlock()
+		return
+	}
+	recorders := h.recorders
+	// recorders are called outside the lock, so subscribers are not delayed by writing to disk,
+	// the recording lock taken before unlocking keeps the order of publishing
+	h.recording.Lock()
+	h.mutex.Unlock()
+	defer h.recording.Unlock()
+	for _, record := range recorders {
+		record(event)
+	}
+}
+
+// deliver assigns the identifier to the event and delivers it to all listeners and subscribers.
+// Returns false when the event was skipped. Must be called with the mutex held.
+func (h *eventHub) deliver(event *Event) bool {
+	if h.closed {
+		return false
+	}
+	now := time.Now().UTC()
+	if event.Source == eventSourceFs {
+		// changes made by handlers are also reported by file system watcher, skip duplicates
+		if changed, ok := h.apiChanges[event.Name]; ok && now.Sub(changed) < h.suppression {
+			return false
+		}
+	} else {
+		if event.Source == "" {
+			event.Source = eventSourceApi
+		}
+		h.changed(event.Name, now)
+		if event.Target != "" {
+			h.changed(event.Target, now)
+		}
+	}
+	event.Id = h.nextId
+	event.Time = now
+	h.nextId++
+	if len(h.history) < cap(h.history) {
+		h.history = append(h.history, event)
+	} else {
+		h.history[(event.Id-1)%uint64(cap(h.history))] = event
+	}
+	// neither listeners nor subscribers block publishing, subscribers that can not keep up are disconnected
+	for _, listener := range h.listeners {
+		listener.push(event)
+	}
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber.events <- event:
+		default:
+			h.unsubscribeLocked(subscriber)
+		}
+	}
+	return true
+}
+
+// changed registers the name changed by tarolas handlers at specified time, so the file system
+// events of the change are skipped as duplicates. Must be called with the mutex held.
+func (h *eventHub) changed(name string, now time.Time) {
+	h.apiChanges[name] = now
+	if len(h.apiChanges) > cap(h.history) {
+		for name, changed := range h.apiChanges {
+			if now.Sub(changed) >= h.suppression {
+				delete(h.apiChanges, name)
+			}
+		}
+	}
+}
+
+// expect registers the file with specified name as about to be changed by tarolas handler.
+// It must be called before the file is changed, so file system events reported before
+// the handler publishes its event are skipped as duplicates too.
+func (h *eventHub) expect(name string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.closed {
+		h.changed(path.Clean(name), time.Now().UTC())
+	}
+}
+
+// record registers the function called for every published event, in the order of publishing.
+// The function is called before publishing completes, so it should be used only when the event
+// must be processed before the change is reported to the client, like writing to the journal.
+func (h *eventHub) record(function func(*Event)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.recorders = append(h.recorders, function)
+}
+
+// listen registers the function called for every published event, in the order of publishing.
+// The function is called in separate goroutine, so slow listeners do not delay publishing.
+func (h *eventHub) listen(function func(*Event)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	listener := &eventListener{wake: make(chan struct{}, 1), closed: h.closed, done: make(chan struct{})}
+	if !h.closed {
+		h.listeners = append(h.listeners, listener)
+	}
+	go listener.run(function)
+}
+
+// subscribe registers new subscriber. When 'since' is greater than zero, all events
+// published after the event with this identifier are delivered first. When some of them
+// are no longer available, an overflow event is delivered instead.
+func (h *eventHub) subscribe(since uint64) *eventSubscriber {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	subscriber := &eventSubscriber{events: make(chan *Event, subscriberBufferSize+cap(h.history))}
+	if h.closed {
+		close(subscriber.events)
+		subscriber.closed = true
+		return subscriber
+	}
+	if since > 0 && since+1 < h.nextId {
+		oldest := h.nextId - uint64(len(h.history))
+		if since+1 < oldest {
+			subscriber.events <- &Event{Id: oldest - 1, Type: EventOverflow, Time: time.Now().UTC(), Source: eventSourceApi}
+			since = oldest - 1
+		}
+		for id := since + 1; id < h.nextId; id++ {
+			subscriber.events <- h.history[(id-1)%uint64(cap(h.history))]
+		}
+	}
+	h.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+// unsubscribe disconnects the subscriber, its event channel is closed.
+func (h *eventHub) unsubscribe(subscriber *eventSubscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.unsubscribeLocked(subscriber)
+}
+
+// unsubscribeLocked disconnects the subscriber, the caller must hold the lock.
+func (h *eventHub) unsubscribeLocked(subscriber *eventSubscriber) {
+	if !subscriber.closed {
+		subscriber.closed = true
+		delete(h.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// close disconnects all subscribers, no more events are published.
+// Waits until listeners process all already published events.
+func (h *eventHub) close() {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return
+	}
+	h.closed = true
+	for subscriber := range h.subscribers {
+		h.unsubscribeLocked(subscriber)
+	}
+	listeners := h.listeners
+	for _, listener := range listeners {
+		listener.stop()
+	}
+	h.mutex.Unlock()
+	for _, listener := range listeners {
+		<-listener.done
+	}
+}
+
+// eventMatches returns true when the event concerns the directory with specified name.
+// Non-recursive watching reports changes of the directory itself and its direct children.
+func eventMatches(event *Event, name string, recursive bool) bool {
+	if event.Type == EventOverflow {
+		return true
+	}
+	return nameMatches(event.Name, name, recursive) || (event.Target != "" && nameMatches(event.Target, name, recursive))
+}
+
+// nameMatches returns true when the file with specified name is located in watched directory.
+func nameMatches(fileName string, name string, recursive bool) bool {
+	if name == "/" && recursive {
+		return true
+	}
+	if fileName == name {
+		return true
+	}
+	if recursive {
+		return len(fileName) > len(name) && fileName[:len(name)] == name && fileName[len(name)] == '/'
+	}
+	return path.Dir(fileName) == name
+}
+
+// notify publishes events emitted by tarolas handlers while processing the request.
+// When journal is enabled, the size and the checksum of changed files are recorded.
+func notify(cfg *Configuration, req *http.Request, events ...*Event) {
+	actor := requestActor(req)
+	for _, event := range events {
+		event.Name = path.Clean(event.Name)
+		if event.Target != "" {
+			event.Target = path.Clean(event.Target)
+		}
+		event.Actor = actor
+		if cfg.Journal.Enabled && !event.Directory && (event.Type == EventCreate || event.Type == EventWrite || event.Type == EventAppend) {
+			if file, errorDto := fileChecksum(cfg, event.Name); errorDto == nil {
+				event.Size = *file.Size
+				event.Digest = *file.Checksum
+			}
+		}
+		cfg.events.publish(event)
+	}
+}
+
+// writeEventType returns the type of event emitted after writing the file with specified name,
+// it must be called before the file is written.
+func writeEventType(cfg *Configuration, name string) string {
+	if _, err := os.Stat(prepareAbsolutePath(cfg, name)); err == nil {
+		return EventWrite
+	}
+	return EventCreate
+}
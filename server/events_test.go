@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowListenerDoesNotBlockPublishing(t *testing.T) {
+	hub := newEventHub(0, 0)
+	blocked := make(chan struct{})
+	received := make([]uint64, 0)
+	hub.listen(func(event *Event) {
+		<-blocked
+		received = append(received, event.Id)
+	})
+	count := 4 * defaultEventHistory
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < count; i++ {
+			hub.publish(&Event{Type: EventWrite, Name: "/a.txt"})
+		}
+		hub.unsubscribe(hub.subscribe(0))
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked by slow listener")
+	}
+	close(blocked)
+	hub.close()
+	if len(received) != count {
+		t.Fatalf("expected %d events, actual %d", count, len(received))
+	}
+	for i, id := range received {
+		if id != uint64(i+1) {
+			t.Fatalf("expected event %d, actual %d", i+1, id)
+		}
+	}
+}
+
+func TestExpectedChangeSuppressesFileSystemEvents(t *testing.T) {
+	hub := newEventHub(0, 0)
+	defer hub.close()
+	subscriber := hub.subscribe(0)
+	// file system event reported after the file was replaced, but before the handler published its event
+	hub.expect("/a.txt")
+	hub.publish(&Event{Type: EventCreate, Name: "/a.txt", Source: eventSourceFs})
+	hub.publish(&Event{Type: EventWrite, Name: "/a.txt"})
+	hub.publish(&Event{Type: EventCreate, Name: "/b.txt", Source: eventSourceFs})
+	for _, expected := range []string{"/a.txt", "/b.txt"} {
+		event := <-subscriber.events
+		if event.Name != expected || event.Source == eventSourceFs && expected == "/a.txt" {
+			t.Errorf("expected event of %s, actual %s event of %s", expected, event.Source, event.Name)
+		}
+	}
+}
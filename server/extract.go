@@ -0,0 +1,379 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	tmpDirectory           = "tmp"     // Name of the directory for temporary files, created in state directory.
+	defaultMaxEntries      = 10000     // Default maximum number of entries in extracted archive.
+	defaultMaxTotalSize    = 1 << 30   // Default maximum total size of extracted content in bytes.
+	overwritePolicyFail    = "fail"    // Extraction fails when any extracted file already exists.
+	overwritePolicySkip    = "skip"    // Already existing files are left untouched.
+	overwritePolicyReplace = "replace" // Already existing files are replaced.
+)
+
+var (
+	errTooManyEntries  = errors.New("archive contains too many entries")
+	errTooLargeContent = errors.New("archive content is too large")
+	errUnsafeEntryName = errors.New("archive entry name points outside target directory")
+)
+
+// ExtractReport stores the result of archive extraction.
+type ExtractReport struct {
+	Name        string   `json:"name"         api:"Name of the target directory."`
+	Directories []string `json:"directories"  api:"Names of created directories."`
+	Created     []string `json:"created"      api:"Names of created files."`
+	Replaced    []string `json:"replaced"     api:"Names of replaced files."`
+	Skipped     []string `json:"skipped"      api:"Names of files skipped, because they already exist."`
+}
+
+// ExtractReportDto is the implementation of DTO for archive extraction report.
+type ExtractReportDto struct {
+	Data *ExtractReport `json:"data"  api:"Extraction report."`
+}
+
+// extractLimits guards the extraction against archive bombs.
+type extractLimits struct {
+	entries   int   // Number of entries that may still be extracted.
+	totalSize int64 // Number of bytes that may still be extracted.
+}
+
+// newExtractLimits creates extraction limits from configuration.
+func newExtractLimits(cfg *Configuration) *extractLimits {
+	limits := extractLimits{entries: cfg.Extract.MaxEntries, totalSize: cfg.Extract.MaxTotalSize}
+	if limits.entries <= 0 {
+		limits.entries = defaultMaxEntries
+	}
+	if limits.totalSize <= 0 {
+		limits.totalSize = defaultMaxTotalSize
+	}
+	return &limits
+}
+
+// nextEntry registers next archive entry, fails when the number of entries exceeds the limit.
+func (l *extractLimits) nextEntry() error {
+	if l.entries--; l.entries < 0 {
+		return errTooManyEntries
+	}
+	return nil
+}
+
+// copy copies the content of the archive entry, fails when the total size exceeds the limit.
+// Declared entry sizes are not trusted, only the bytes actually read are counted.
+func (l *extractLimits) copy(destination io.Writer, source io.Reader) error {
+	written, err := io.Copy(destination, io.LimitReader(source, l.totalSize+1))
+	if l.totalSize -= written; l.totalSize < 0 {
+		return errTooLargeContent
+	}
+	return err
+}
+
+// safeEntryPath converts archive entry name into a path inside the staging directory.
+// Absolute names and names escaping the staging directory (zip slip) are rejected.
+func safeEntryPath(staging string, entryName string) (string, error) {
+	entryName = strings.ReplaceAll(entryName, "\\", "/")
+	if strings.HasPrefix(entryName, "/") || strings.Contains(entryName, ":") {
+		return "", errUnsafeEntryName
+	}
+	cleanName := path.Clean(entryName)
+	if cleanName == "." {
+		// the top level directory entry, like './' in archives created by tar
+		return staging, nil
+	}
+	if cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", errUnsafeEntryName
+	}
+	fullName := filepath.Join(staging, filepath.FromSlash(cleanName))
+	if !strings.HasPrefix(fullName, staging+string(filepath.Separator)) {
+		return "", errUnsafeEntryName
+	}
+	return fullName, nil
+}
+
+// extractEntry writes single archive entry into staging directory.
+func extractEntry(staging string, limits *extractLimits, entryName string, isDir bool, content io.Reader) error {
+	if err := limits.nextEntry(); err != nil {
+		return err
+	}
+	fullName, err := safeEntryPath(staging, entryName)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return os.MkdirAll(fullName, 0755)
+	}
+	if fullName == staging {
+		return errUnsafeEntryName
+	}
+	if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fullName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err = limits.copy(file, content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// extractZip extracts ZIP archive from the spooled file into staging directory.
+// Only directories and regular files are extracted, other entries are ignored.
+func extractZip(spool *os.File, size int64, staging string, limits *extractLimits) error {
+	reader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return err
+	}
+	if len(reader.File) > limits.entries {
+		return errTooManyEntries
+	}
+	for _, entry := range reader.File {
+		mode := entry.Mode()
+		if !mode.IsDir() && !mode.IsRegular() {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = extractEntry(staging, limits, entry.Name, mode.IsDir(), content)
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar extracts tar archive read from the stream into staging directory.
+// Only directories and regular files are extracted, other entries are ignored.
+func extractTar(source io.Reader, staging string, limits *extractLimits) error {
+	reader := tar.NewReader(source)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(staging, limits, header.Name, true, nil)
+		case tar.TypeReg:
+			err = extractEntry(staging, limits, header.Name, false, reader)
+		default:
+			err = limits.nextEntry()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// spoolBody copies the request body into temporary file, ZIP archives require random access.
+func spoolBody(cfg *Configuration, req *http.Request, limits *extractLimits) (*os.File, int64, error) {
+	spool, err := createTemporaryFile(cfg, "extract-*.zip")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(spool, io.LimitReader(req.Body, limits.totalSize+1))
+	if err == nil && size > limits.totalSize {
+		err = errTooLargeContent
+	}
+	if err != nil {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+		return nil, 0, err
+	}
+	return spool, size, nil
+}
+
+// directoryExtract unpacks the archive sent in request body into directory with specified name.
+// The archive is first unpacked into staging directory, so when any of the limits
+// is exceeded or any entry is unsafe, the target directory stays untouched.
+// Then the extracted files are moved into the target directory according
+// to the overwrite policy.
+func directoryExtract(cfg *Configuration, req *http.Request, name string, format string, overwrite string) (*ExtractReport, *ErrorDto) {
+	defer func() {
+		if err := req.Body.Close(); err != nil {
+			logError(err)
+		}
+	}()
+	if _, ok := archiveContentTypes[format]; !ok {
+		return nil, errorDto(errInvalidParameterValue, "format ("+format+")")
+	}
+	if overwrite != overwritePolicyFail && overwrite != overwritePolicySkip && overwrite != overwritePolicyReplace {
+		return nil, errorDto(errInvalidParameterValue, "overwrite ("+overwrite+")")
+	}
+	fullName := prepareAbsolutePath(cfg, name)
+	if fileInfo, err := os.Stat(fullName); err != nil || !fileInfo.IsDir() {
+		return nil, errorDto(errReadingDirectoryContentFailed, name)
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.stateDirectory(), tmpDirectory), 0755); err != nil {
+		logError(err)
+		return nil, errorDto(errExtractingArchiveFailed, errMsgCheckServerLogForDetails)
+	}
+	staging, err := os.MkdirTemp(filepath.Join(cfg.stateDirectory(), tmpDirectory), "extract-")
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errExtractingArchiveFailed, errMsgCheckServerLogForDetails)
+	}
+	defer func() {
+		if err := os.RemoveAll(staging); err != nil {
+			logError(err)
+		}
+	}()
+	limits := newExtractLimits(cfg)
+	switch format {
+	case archiveFormatZip:
+		var spool *os.File
+		var size int64
+		if spool, size, err = spoolBody(cfg, req, limits); err == nil {
+			err = extractZip(spool, size, staging, limits)
+			_ = spool.Close()
+			_ = os.Remove(spool.Name())
+		}
+	case archiveFormatTar:
+		err = extractTar(req.Body, staging, limits)
+	case archiveFormatTarGz:
+		var gzipReader *gzip.Reader
+		if gzipReader, err = gzip.NewReader(req.Body); err == nil {
+			err = extractTar(gzipReader, staging, limits)
+		}
+	}
+	if err != nil {
+		logError(err)
+		switch err {
+		case errTooManyEntries, errTooLargeContent:
+			return nil, errorDto(errArchiveLimitExceeded, err.Error())
+		case errUnsafeEntryName:
+			return nil, errorDto(errUnsafeArchiveEntry, err.Error())
+		}
+		return nil, bodyError(err, errExtractingArchiveFailed, errMsgCheckServerLogForDetails)
+	}
+	return moveExtracted(cfg, staging, name, overwrite, requestActor(req))
+}
+
+// moveExtracted moves files from staging directory into target directory,
+// created files are owned by specified owner. When moving fails partway,
+// the report of already moved files is returned together with the error.
+func moveExtracted(cfg *Configuration, staging string, name string, overwrite string, owner string) (*ExtractReport, *ErrorDto) {
+	report := ExtractReport{Name: name, Directories: []string{}, Created: []string{}, Replaced: []string{}, Skipped: []string{}}
+	type extracted struct {
+		source string
+		name   string
+		isDir  bool
+		exists bool
+		size   int64
+	}
+	files := make([]extracted, 0)
+	// collect extracted files and check conflicts before anything is moved
+	var conflict *ErrorDto
+	err := filepath.Walk(staging, func(source string, info os.FileInfo, err error) error {
+		if err != nil || source == staging {
+			return err
+		}
+		fileName := path.Join(name, filepath.ToSlash(strings.TrimPrefix(source, staging)))
+		fullName := prepareAbsolutePath(cfg, fileName)
+		if cfg.isStatePath(fullName) {
+			conflict = errorDto(errReservedName, fileName)
+			return filepath.SkipAll
+		}
+		targetInfo, err := os.Stat(fullName)
+		exists := err == nil
+		if exists && targetInfo.IsDir() != info.IsDir() {
+			conflict = errorDto(errFileOrDirectoryTypeConflict, fileName)
+			return filepath.SkipAll
+		}
+		if exists && !info.IsDir() && overwrite == overwritePolicyFail {
+			conflict = errorDto(errFileAlreadyExists, fileName)
+			return filepath.SkipAll
+		}
+		files = append(files, extracted{source: source, name: fileName, isDir: info.IsDir(), exists: exists, size: info.Size()})
+		return nil
+	})
+	if conflict != nil {
+		return nil, conflict
+	}
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errExtractingArchiveFailed, errMsgCheckServerLogForDetails)
+	}
+	changes := make([]quotaChange, 0, len(files))
+	for _, file := range files {
+		if !file.isDir && !(file.exists && overwrite == overwritePolicySkip) {
+			changes = append(changes, quotaChange{name: file.name, size: file.size})
+		}
+	}
+	reservation, quotaError := cfg.quotas.reserve(changes, owner)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	// files moved before a failure are accounted for too
+	defer reservation.commit()
+	for _, file := range files {
+		if file.isDir {
+			if err := os.MkdirAll(prepareAbsolutePath(cfg, file.name), 0755); err != nil {
+				logError(err)
+				return &report, errorDto(errCreatingDirectoriesFailed, file.name)
+			}
+			if !file.exists {
+				report.Directories = append(report.Directories, file.name)
+			}
+			continue
+		}
+		if file.exists && overwrite == overwritePolicySkip {
+			report.Skipped = append(report.Skipped, file.name)
+			continue
+		}
+		fullName := prepareAbsolutePath(cfg, file.name)
+		if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+			logError(err)
+			return &report, errorDto(errCreatingDirectoriesFailed, file.name)
+		}
+		if file.exists {
+			if err := preserveVersion(cfg, file.name, versionReasonWrite, false); err != nil {
+				logError(err)
+				return &report, errorDto(errPreservingVersionFailed, file.name)
+			}
+		}
+		if err := os.Rename(file.source, fullName); err != nil {
+			logError(err)
+			return &report, errorDto(errExtractingArchiveFailed, file.name)
+		}
+		if file.exists {
+			report.Replaced = append(report.Replaced, file.name)
+		} else {
+			report.Created = append(report.Created, file.name)
+		}
+	}
+	return &report, nil
+}
+
+// events returns change events for created directories and extracted files.
+func (r *ExtractReport) events() []*Event {
+	events := make([]*Event, 0, len(r.Directories)+len(r.Created)+len(r.Replaced))
+	for _, name := range r.Directories {
+		events = append(events, &Event{Type: EventCreate, Name: name, Directory: true})
+	}
+	for _, name := range r.Created {
+		events = append(events, &Event{Type: EventCreate, Name: name})
+	}
+	for _, name := range r.Replaced {
+		events = append(events, &Event{Type: EventWrite, Name: name})
+	}
+	return events
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSafeEntryPath(t *testing.T) {
+	staging := filepath.Join(t.TempDir(), "staging")
+	tests := []struct {
+		entryName string
+		expected  string // Empty when the name is rejected.
+	}{
+		{"a.txt", filepath.Join(staging, "a.txt")},
+		{"dir/a.txt", filepath.Join(staging, "dir", "a.txt")},
+		{"./dir/", filepath.Join(staging, "dir")},
+		{"dir/../a.txt", filepath.Join(staging, "a.txt")},
+		{"dir\\a.txt", filepath.Join(staging, "dir", "a.txt")},
+		{".", staging},
+		{"./", staging},
+		{"..", ""},
+		{"../a.txt", ""},
+		{"dir/../../a.txt", ""},
+		{"..\\a.txt", ""},
+		{"/etc/passwd", ""},
+		{"\\etc\\passwd", ""},
+		{"C:/Windows/a.txt", ""},
+		{"C:a.txt", ""},
+	}
+	for _, test := range tests {
+		actual, err := safeEntryPath(staging, test.entryName)
+		if test.expected == "" {
+			if err != errUnsafeEntryName {
+				t.Errorf("%q: expected unsafe name, actual %q (%v)", test.entryName, actual, err)
+			}
+		} else if err != nil || actual != test.expected {
+			t.Errorf("%q: expected %q, actual %q (%v)", test.entryName, test.expected, actual, err)
+		}
+	}
+}
+
+// archiveEntry describes entry of the archive created by the test.
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+// tarArchive creates tar archive with specified entries.
+func tarArchive(t *testing.T, entries ...archiveEntry) *bytes.Buffer {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Size: int64(len(entry.content)), Linkname: entry.linkname, Mode: 0644}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &archive
+}
+
+// zipArchive creates ZIP archive with specified entries spooled in a file, as extracted by the server.
+func zipArchive(t *testing.T, entries ...archiveEntry) (*os.File, int64) {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		switch entry.typeflag {
+		case tar.TypeDir:
+			header.SetMode(os.ModeDir | 0755)
+		case tar.TypeSymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			entry.content = entry.linkname
+		default:
+			header.SetMode(0644)
+		}
+		content, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = content.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	spool, err := os.CreateTemp(t.TempDir(), "*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = spool.Close() })
+	if _, err = spool.Write(archive.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return spool, int64(archive.Len())
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []archiveEntry
+		limits    extractLimits
+		expected  error
+		extracted []string // Files expected in staging directory.
+	}{
+		{
+			name:      "files and directories",
+			entries:   []archiveEntry{{name: "dir/", typeflag: tar.TypeDir}, {name: "dir/a.txt", typeflag: tar.TypeReg, content: "abc"}, {name: "b.txt", typeflag: tar.TypeReg}},
+			limits:    extractLimits{entries: 3, totalSize: 3},
+			extracted: []string{"b.txt", "dir", "dir/a.txt"},
+		},
+		{
+			name:     "parent directory entry",
+			entries:  []archiveEntry{{name: "a.txt", typeflag: tar.TypeReg}, {name: "../evil.txt", typeflag: tar.TypeReg, content: "evil"}},
+			limits:   extractLimits{entries: 10, totalSize: 100},
+			expected: errUnsafeEntryName,
+		},
+		{
+			name:     "nested parent directory entry",
+			entries:  []archiveEntry{{name: "dir/../../evil.txt", typeflag: tar.TypeReg, content: "evil"}},
+			limits:   extractLimits{entries: 10, totalSize: 100},
+			expected: errUnsafeEntryName,
+		},
+		{
+			name:     "absolute entry",
+			entries:  []archiveEntry{{name: "/tmp/evil.txt", typeflag: tar.TypeReg, content: "evil"}},
+			limits:   extractLimits{entries: 10, totalSize: 100},
+			expected: errUnsafeEntryName,
+		},
+		{
+			name:      "symbolic link entry",
+			entries:   []archiveEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}, {name: "a.txt", typeflag: tar.TypeReg, content: "abc"}},
+			limits:    extractLimits{entries: 10, totalSize: 100},
+			extracted: []string{"a.txt"},
+		},
+		{
+			name:     "too many entries",
+			entries:  []archiveEntry{{name: "a.txt", typeflag: tar.TypeReg}, {name: "b.txt", typeflag: tar.TypeReg}, {name: "c.txt", typeflag: tar.TypeReg}},
+			limits:   extractLimits{entries: 2, totalSize: 100},
+			expected: errTooManyEntries,
+		},
+		{
+			name:     "too large content",
+			entries:  []archiveEntry{{name: "a.txt", typeflag: tar.TypeReg, content: "abc"}, {name: "b.txt", typeflag: tar.TypeReg, content: "def"}},
+			limits:   extractLimits{entries: 10, totalSize: 5},
+			expected: errTooLargeContent,
+		},
+	}
+	for _, test := range tests {
+		formats := map[string]func(staging string, limits *extractLimits) error{
+			"tar": func(staging string, limits *extractLimits) error {
+				return extractTar(tarArchive(t, test.entries...), staging, limits)
+			},
+			"zip": func(staging string, limits *extractLimits) error {
+				spool, size := zipArchive(t, test.entries...)
+				return extractZip(spool, size, staging, limits)
+			},
+		}
+		for format, extract := range formats {
+			parent := t.TempDir()
+			staging := filepath.Join(parent, "staging")
+			if err := os.Mkdir(staging, 0755); err != nil {
+				t.Fatal(err)
+			}
+			limits := test.limits
+			if err := extract(staging, &limits); err != test.expected {
+				t.Errorf("%s (%s): expected error %v, actual %v", test.name, format, test.expected, err)
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil.txt")); err == nil {
+				t.Errorf("%s (%s): file written outside staging directory", test.name, format)
+			}
+			if test.expected != nil {
+				continue
+			}
+			extracted := make([]string, 0)
+			_ = filepath.Walk(staging, func(fullName string, info os.FileInfo, err error) error {
+				if err == nil && fullName != staging {
+					if !info.IsDir() && !info.Mode().IsRegular() {
+						t.Errorf("%s (%s): unexpected file type of %s", test.name, format, fullName)
+					}
+					relative, _ := filepath.Rel(staging, fullName)
+					extracted = append(extracted, filepath.ToSlash(relative))
+				}
+				return nil
+			})
+			if len(extracted) != len(test.extracted) {
+				t.Errorf("%s (%s): expected %v, actual %v", test.name, format, test.extracted, extracted)
+				continue
+			}
+			for i := range extracted {
+				if extracted[i] != test.extracted[i] {
+					t.Errorf("%s (%s): expected %v, actual %v", test.name, format, test.extracted, extracted)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestMoveExtractedFailingPartway(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir(), StateDirectory: t.TempDir()}
+	cfg.Versioning.Directories = []string{"/x"}
+	staging := t.TempDir()
+	for _, name := range []string{"a.txt", "b/c.txt", "d.txt", "x/d.txt"} {
+		fullName := filepath.Join(staging, name)
+		if name == "x/d.txt" {
+			fullName = filepath.Join(cfg.RootDirectory, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullName, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the existing file can not be preserved as a version, so replacing it fails
+	if err := os.WriteFile(filepath.Join(cfg.StateDirectory, versionsDirectory), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, errorDto := moveExtracted(cfg, staging, "/x", overwritePolicyReplace, "")
+	if errorDto == nil || errorDto.Code != errPreservingVersionFailed.Code {
+		t.Errorf("expected preserving version failure, actual %v", errorDto)
+	}
+	if report == nil {
+		t.Fatal("report of moved files not returned")
+	}
+	expected := []*Event{
+		{Type: EventCreate, Name: "/x/b", Directory: true},
+		{Type: EventCreate, Name: "/x/a.txt"},
+		{Type: EventCreate, Name: "/x/b/c.txt"},
+	}
+	if events := report.events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, actual %v", expected, events)
+	}
+}
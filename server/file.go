@@ -1,12 +1,11 @@
 package server
 
 import (
-	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
@@ -31,23 +30,100 @@ func fileWrite(cfg *Configuration, req *http.Request, name string) (*File, *Erro
 		}
 	}()
 	fullName := prepareAbsolutePath(cfg, name)
-	if file, err := os.OpenFile(fullName, os.O_CREATE|os.O_WRONLY, 0755); err == nil {
-		defer func() {
-			if err := file.Close(); err != nil {
-				logError(err)
+	// the file can not be written, when its directory does not exist or a directory has the same name
+	if info, err := os.Stat(filepath.Dir(fullName)); err != nil || !info.IsDir() {
+		return nil, errorDto(errOpeningFileForWritingFailed, name)
+	}
+	if info, err := os.Stat(fullName); err == nil && info.IsDir() {
+		return nil, errorDto(errOpeningFileForWritingFailed, name)
+	}
+	reservation, quotaError := cfg.quotas.reserveStream(name, requestActor(req), false)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	defer reservation.release()
+	// the content is written into temporary file, the file is replaced only when the whole content was received
+	temporary, err := createTemporaryFile(cfg, "write-*")
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errOpeningFileForWritingFailed, name)
+	}
+	defer func() {
+		_ = temporary.Close()
+		_ = os.Remove(temporary.Name())
+	}()
+	decoder := base64.NewDecoder(base64.StdEncoding, req.Body)
+	if exceeded, err := copyWithinQuota(temporary, decoder, reservation); exceeded {
+		return nil, errorDto(errQuotaExceeded, name)
+	} else if err != nil {
+		return nil, bodyError(err, errWritingFileFailed, name)
+	}
+	// the current content is preserved as a version only when the new content was fully received
+	if err = preserveVersion(cfg, name, versionReasonWrite, false); err != nil {
+		logError(err)
+		return nil, errorDto(errPreservingVersionFailed, name)
+	}
+	cfg.events.expect(name)
+	if err = replaceFile(temporary, fullName); err != nil {
+		logError(err)
+		return nil, errorDto(errWritingFileFailed, name)
+	}
+	reservation.commit()
+	fileInfo, _ := os.Stat(fullName)
+	size := fileInfo.Size()
+	return &File{Name: &name, Size: &size}, nil
+}
+
+// createTemporaryFile creates temporary file in the state directory, which is on the same
+// file system as the root directory, so the file can be renamed into the root directory.
+func createTemporaryFile(cfg *Configuration, pattern string) (*os.File, error) {
+	directory := filepath.Join(cfg.stateDirectory(), tmpDirectory)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(directory, pattern)
+}
+
+// replaceFile renames the written temporary file over the file with specified full name.
+// The file is overwritten from its beginning, so the original content beyond the length
+// of the new content is kept, and the original permissions are kept.
+func replaceFile(temporary *os.File, fullName string) error {
+	if original, err := os.Open(fullName); err == nil {
+		info, err := original.Stat()
+		if err == nil {
+			var written int64
+			if written, err = temporary.Seek(0, io.SeekCurrent); err == nil && info.Size() > written {
+				if _, err = original.Seek(written, io.SeekStart); err == nil {
+					_, err = io.Copy(temporary, original)
+				}
 			}
-		}()
-		decoder := base64.NewDecoder(base64.StdEncoding, req.Body)
-		if _, err := io.Copy(file, decoder); err == nil {
-			fileInfo, _ := file.Stat()
-			size := fileInfo.Size()
-			return &File{Name: &name, Size: &size}, nil
-		} else {
-			return nil, errorDto(errWritingFileFailed, name)
 		}
-	} else {
-		return nil, errorDto(errOpeningFileForWritingFailed, name)
+		_ = original.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return installFile(temporary, fullName)
+}
+
+// installFile renames the written temporary file over the file with specified full name,
+// the whole original content is replaced and the original permissions are kept.
+func installFile(temporary *os.File, fullName string) error {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(fullName); err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := temporary.Chmod(mode); err != nil {
+		return err
 	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), fullName)
 }
 
 // readFile reads file part defined by offset and size. The returned content is base64 encoded.
@@ -108,6 +184,13 @@ func fileAppend(cfg *Configuration, req *http.Request, name string) (*File, *Err
 		}
 	}()
 	fullName := prepareAbsolutePath(cfg, name)
+	reservation, quotaError := cfg.quotas.reserveStream(name, requestActor(req), true)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	defer reservation.release()
+	originalInfo, statErr := os.Stat(fullName)
+	cfg.events.expect(name)
 	if file, err := os.OpenFile(fullName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0755); err == nil {
 		defer func() {
 			if err := file.Close(); err != nil {
@@ -115,13 +198,29 @@ func fileAppend(cfg *Configuration, req *http.Request, name string) (*File, *Err
 			}
 		}()
 		decoder := base64.NewDecoder(base64.StdEncoding, req.Body)
-		if _, err := io.Copy(file, decoder); err == nil {
+		exceeded, err := copyWithinQuota(file, decoder, reservation)
+		if exceeded || err != nil {
+			// remove the partially appended content, so the file is left as it was
+			var restoreErr error
+			if statErr == nil {
+				restoreErr = file.Truncate(originalInfo.Size())
+			} else {
+				restoreErr = os.Remove(fullName)
+			}
+			if restoreErr != nil {
+				logError(restoreErr)
+			}
+		}
+		if exceeded {
+			return nil, errorDto(errQuotaExceeded, name)
+		} else if err == nil {
+			reservation.commit()
 			fileInfo, _ := file.Stat()
 			size := fileInfo.Size()
 			return &File{Name: &name, Size: &size}, nil
 		} else {
 			logError(err)
-			return nil, errorDto(errAppendingFileFailed, name)
+			return nil, bodyError(err, errAppendingFileFailed, name)
 		}
 	} else {
 		logError(err)
@@ -130,6 +229,8 @@ func fileAppend(cfg *Configuration, req *http.Request, name string) (*File, *Err
 }
 
 // fileDelete deletes file with specified name.
+// When trash is enabled, the file is moved to trash. Otherwise, when versioning
+// is enabled for this file, the file is moved to versions area.
 func fileDelete(cfg *Configuration, name string) (*File, *ErrorDto) {
 	fullName := prepareAbsolutePath(cfg, name)
 	if fileInfo, err := os.Stat(fullName); err == nil {
@@ -137,7 +238,17 @@ func fileDelete(cfg *Configuration, name string) (*File, *ErrorDto) {
 			return nil, errorDto(errNotAFile, name)
 		} else {
 			size := fileInfo.Size()
-			if err := os.Remove(fullName); err == nil {
+			if cfg.Trash.Enabled {
+				if _, err = moveToTrash(cfg, name, fileInfo); err != nil {
+					logError(err)
+					return nil, errorDto(errMovingToTrashFailed, name)
+				}
+			} else if isVersioned(cfg, name) {
+				err = preserveVersion(cfg, name, versionReasonDelete, true)
+			} else {
+				err = os.Remove(fullName)
+			}
+			if err == nil {
 				return &File{Name: &name, Size: &size}, nil
 			} else {
 				logError(err)
@@ -182,11 +293,9 @@ func fileChecksum(cfg *Configuration, name string) (*File, *ErrorDto) {
 				logError(err)
 			}
 		}()
-		hash := sha256.New()
-		if _, err := io.Copy(hash, file); err == nil {
+		if checksum, err := cfg.checksums.digest(fullName, file); err == nil {
 			fileInfo, _ := file.Stat()
 			size := fileInfo.Size()
-			checksum := fmt.Sprintf("%x", hash.Sum(nil))
 			return &File{Name: &name, Size: &size, Checksum: &checksum}, nil
 		} else {
 			logError(err)
@@ -217,7 +326,7 @@ func writeSharedFileContent(cfg *Configuration, w http.ResponseWriter, name stri
 			}
 			// detect file content
 			buffer := make([]byte, 512)
-			if _, err = file.Read(buffer); err != nil {
+			if _, err = file.Read(buffer); err != nil && err != io.EOF {
 				return errorDto(errReadingFileFailed, name)
 			}
 			contentType := http.DetectContentType(buffer)
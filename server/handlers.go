@@ -3,12 +3,13 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 // handlerDirectoryRead processes requests that read single directory content.
 func handlerDirectoryRead(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if directory, errorDto := directoryContent(cfg, name); errorDto == nil {
 			writeResultDirectory(w, directory)
 		} else {
@@ -27,7 +28,7 @@ func handlerDirectoryTree(cfg *Configuration, w http.ResponseWriter, _ *http.Req
 
 // handlerDirectoryList processes requests that list whole directory tree with full relative paths.
 func handlerDirectoryList(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if dirList, errorDto := directoryList(cfg, name); errorDto == nil {
 			writeResultData(w, DirectoryListDto{dirList})
 		} else {
@@ -40,13 +41,14 @@ func handlerDirectoryList(cfg *Configuration, w http.ResponseWriter, req *http.R
 func handlerDirectoryCreate(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
 	var name, all string
 	var ok bool
-	if name, ok = requiredNameParam(w, req); !ok {
+	if name, ok = requiredNameParam(cfg, w, req); !ok {
 		return
 	}
 	if all, ok = optionalSingleParam(w, req, "all", "false"); !ok {
 		return
 	}
 	if directory, errorDto := createDirectory(cfg, name, strings.ToLower(all) == "true"); errorDto == nil {
+		notify(cfg, req, &Event{Type: EventCreate, Name: name, Directory: true})
 		writeResultDirectory(w, directory)
 	} else {
 		writeResultError(w, errorDto)
@@ -58,7 +60,7 @@ func handlerDirectoryDelete(cfg *Configuration, w http.ResponseWriter, req *http
 	var name, all string
 	var ok bool
 	// check if single and required directory name is given as parameter
-	if name, ok = requiredNameParam(w, req); !ok {
+	if name, ok = requiredNameParam(cfg, w, req); !ok {
 		return
 	}
 	// get deep delete flag or set it to false if not present
@@ -67,15 +69,76 @@ func handlerDirectoryDelete(cfg *Configuration, w http.ResponseWriter, req *http
 	}
 	// delete directory and optionally its whole content
 	if directory, errorDto := deleteDirectory(cfg, name, strings.ToLower(all) == "true"); errorDto == nil {
+		notify(cfg, req, &Event{Type: EventDelete, Name: name, Directory: true})
 		writeResultDirectory(w, directory)
 	} else {
 		writeResultError(w, errorDto)
 	}
 }
 
+// handlerDirectoryArchive processes requests that download directory content as an archive.
+func handlerDirectoryArchive(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if format, ok := optionalSingleParam(w, req, "format", archiveFormatZip); ok {
+			if errorDto := writeArchive(cfg, w, name, strings.ToLower(format), archiveFilterParams(req)); errorDto != nil {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerDirectoryManifest processes requests that read the manifest of all files in directory.
+func handlerDirectoryManifest(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if digest, ok := optionalSingleParam(w, req, "digest", "false"); ok {
+			if errorDto := writeManifest(cfg, w, name, strings.ToLower(digest) == "true", archiveFilterParams(req)); errorDto != nil {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerDirectoryDiff processes requests that compare the manifest sent by the client with directory content.
+func handlerDirectoryDiff(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if mode, ok := optionalSingleParam(w, req, "mode", diffModeSync); ok {
+			if diff, errorDto := directoryDiff(cfg, req, name, strings.ToLower(mode), archiveFilterParams(req)); errorDto == nil {
+				writeResultData(w, ManifestDiffDto{diff})
+			} else {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerDirectoryExtract processes requests that upload an archive and extract it into directory.
+func handlerDirectoryExtract(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if format, ok := optionalSingleParam(w, req, "format", archiveFormatZip); ok {
+			if overwrite, ok := optionalSingleParam(w, req, "overwrite", overwritePolicyFail); ok {
+				report, errorDto := directoryExtract(cfg, req, name, strings.ToLower(format), strings.ToLower(overwrite))
+				// files moved before a failure are notified too
+				if report != nil {
+					notify(cfg, req, report.events()...)
+				}
+				if errorDto == nil {
+					writeResultData(w, ExtractReportDto{report})
+				} else {
+					writeResultError(w, errorDto)
+				}
+			}
+		}
+	}
+}
+
+// archiveFilterParams collects include and exclude patterns for archive from request parameters.
+func archiveFilterParams(req *http.Request) *archiveFilter {
+	return &archiveFilter{include: optionalListParam(req, "include"), exclude: optionalListParam(req, "exclude")}
+}
+
 // TODO add documentation
 func handlerFileRead(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if offset, ok := requiredIntParam(w, req, "offset"); ok {
 			if size, ok := requiredIntParam(w, req, "size"); ok {
 				if errorDto := readFile(cfg, w, name, offset, size); errorDto != nil {
@@ -86,10 +149,12 @@ func handlerFileRead(cfg *Configuration, w http.ResponseWriter, req *http.Reques
 	}
 }
 
-// TODO add documentation
+// handlerFileWrite processes requests that write the content of specified file.
 func handlerFileWrite(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		eventType := writeEventType(cfg, name)
 		if file, errorDto := fileWrite(cfg, req, name); errorDto == nil {
+			notify(cfg, req, &Event{Type: eventType, Name: name, Size: *file.Size})
 			writeResultFile(w, file)
 		} else {
 			writeResultError(w, errorDto)
@@ -97,10 +162,11 @@ func handlerFileWrite(cfg *Configuration, w http.ResponseWriter, req *http.Reque
 	}
 }
 
-// TODO add documentation
+// handlerFileAppend processes requests that append the content of specified file.
 func handlerFileAppend(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if file, errorDto := fileAppend(cfg, req, name); errorDto == nil {
+			notify(cfg, req, &Event{Type: EventAppend, Name: name, Size: *file.Size})
 			writeResultFile(w, file)
 		} else {
 			writeResultError(w, errorDto)
@@ -110,8 +176,9 @@ func handlerFileAppend(cfg *Configuration, w http.ResponseWriter, req *http.Requ
 
 // handlerFileDelete processes requests that delete specified file.
 func handlerFileDelete(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if file, errorDto := fileDelete(cfg, name); errorDto == nil {
+			notify(cfg, req, &Event{Type: EventDelete, Name: name})
 			writeResultFile(w, file)
 		} else {
 			writeResultError(w, errorDto)
@@ -121,7 +188,7 @@ func handlerFileDelete(cfg *Configuration, w http.ResponseWriter, req *http.Requ
 
 // handlerFileExists processes requests that check if specified file exists.
 func handlerFileExists(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if file, errorDto := fileExists(cfg, name); errorDto == nil {
 			writeResultFile(w, file)
 		} else {
@@ -132,7 +199,7 @@ func handlerFileExists(cfg *Configuration, w http.ResponseWriter, req *http.Requ
 
 // handlerFileChecksum processes requests that calculate file checksum.
 func handlerFileChecksum(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
-	if name, ok := requiredNameParam(w, req); ok {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
 		if file, errorDto := fileChecksum(cfg, name); errorDto == nil {
 			writeResultFile(w, file)
 		} else {
@@ -142,10 +209,215 @@ func handlerFileChecksum(cfg *Configuration, w http.ResponseWriter, req *http.Re
 }
 
 // handlerFileShared processes requests that read file contents shared as link.
+// When the 'format' parameter is given, the shared directory is downloaded as an archive.
 func handlerFileShared(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
 	uriPrefix := cfg.UrlPrefix + routeFileShared
-	name := "/" + strings.TrimPrefix(req.RequestURI, uriPrefix)
+	name := "/" + strings.TrimPrefix(req.URL.Path, uriPrefix)
+	if cfg.isStatePath(prepareAbsolutePath(cfg, name)) {
+		writeResultError(w, errorDto(errReservedName, name))
+		return
+	}
+	if format, ok := optionalSingleParam(w, req, "format", ""); !ok {
+		return
+	} else if format != "" {
+		if errorDto := writeArchive(cfg, w, name, strings.ToLower(format), archiveFilterParams(req)); errorDto != nil {
+			writeResultError(w, errorDto)
+		}
+		return
+	}
 	if errorDto := writeSharedFileContent(cfg, w, name); errorDto != nil {
 		writeResultError(w, errorDto)
 	}
 }
+
+// handlerFileVersions processes requests that list preserved versions of specified file.
+func handlerFileVersions(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if versions, errorDto := fileVersions(cfg, name); errorDto == nil {
+			writeResultData(w, FileVersionsDto{versions})
+		} else {
+			writeResultError(w, errorDto)
+		}
+	}
+}
+
+// handlerFileRestore processes requests that restore specified version of a file.
+func handlerFileRestore(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if version, ok := requiredSingleParam(w, req, "version"); ok {
+			eventType := writeEventType(cfg, name)
+			if file, errorDto := fileRestore(cfg, name, version, requestActor(req)); errorDto == nil {
+				notify(cfg, req, &Event{Type: eventType, Name: name, Size: *file.Size})
+				writeResultFile(w, file)
+			} else {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerTrashList processes requests that list the content of trash.
+func handlerTrashList(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	if items, errorDto := trashList(cfg); errorDto == nil {
+		writeResultData(w, TrashItemsDto{items})
+	} else {
+		writeResultError(w, errorDto)
+	}
+}
+
+// handlerTrashRestore processes requests that restore deleted file or directory from trash.
+func handlerTrashRestore(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if id, ok := requiredSingleParam(w, req, "id"); ok {
+		if name, ok := optionalNameParam(cfg, w, req, "name"); ok {
+			if item, errorDto := trashRestore(cfg, id, name, requestActor(req)); errorDto == nil {
+				notify(cfg, req, &Event{Type: EventCreate, Name: item.Name, Directory: item.Directory, Size: item.Size})
+				writeResultData(w, TrashItemDto{item})
+			} else {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerTrashPurge processes requests that permanently delete single item or whole trash content.
+func handlerTrashPurge(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if id, ok := optionalSingleParam(w, req, "id", ""); ok {
+		if items, errorDto := trashPurge(cfg, id); errorDto == nil {
+			writeResultData(w, TrashItemsDto{items})
+		} else {
+			writeResultError(w, errorDto)
+		}
+	}
+}
+
+// handlerBatch processes requests that execute multiple operations at once.
+func handlerBatch(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if atomic, ok := optionalSingleParam(w, req, "atomic", "false"); ok {
+		if results, errorDto := batchExecute(cfg, req, strings.ToLower(atomic) == "true"); errorDto == nil {
+			notify(cfg, req, batchEvents(results)...)
+			writeResultData(w, BatchResultsDto{results})
+		} else {
+			writeResultError(w, errorDto)
+		}
+	}
+}
+
+// handlerWatch processes requests that stream change notifications.
+func handlerWatch(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if recursive, ok := optionalSingleParam(w, req, "recursive", "false"); ok {
+			if since, ok := sinceParam(w, req); ok {
+				if errorDto := watchEvents(cfg, w, req, name, strings.ToLower(recursive) == "true", since); errorDto != nil {
+					writeResultError(w, errorDto)
+				}
+			}
+		}
+	}
+}
+
+// handlerJournalRead processes requests that read the journal of mutating operations.
+func handlerJournalRead(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if from, ok := optionalIntParam(w, req, "from", 1); ok {
+		if limit, ok := optionalIntParam(w, req, "limit", defaultJournalReadLimit); ok {
+			if from < 0 {
+				writeResultError(w, errorDto(errInvalidParameterValue, "from ("+strconv.FormatInt(from, 10)+")"))
+			} else if journal, errorDto := journalRead(cfg, uint64(from), int(limit)); errorDto == nil {
+				writeResultData(w, journal)
+			} else {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerDirectoryUsage processes requests that report disk usage of directory.
+func handlerDirectoryUsage(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if name, ok := requiredNameParam(cfg, w, req); ok {
+		if top, ok := optionalIntParam(w, req, "top", defaultUsageTop); ok {
+			if usage, errorDto := directoryUsage(cfg, name, int(max(0, min(top, maxUsageTop)))); errorDto == nil {
+				writeResultData(w, DirectoryUsageDto{usage})
+			} else {
+				writeResultError(w, errorDto)
+			}
+		}
+	}
+}
+
+// handlerStats processes requests that report storage statistics.
+func handlerStats(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	if stats, errorDto := storageStats(cfg); errorDto == nil {
+		writeResultData(w, StorageStatsDto{stats})
+	} else {
+		writeResultError(w, errorDto)
+	}
+}
+
+// handlerQuotaUsage processes requests that report usage of storage quotas.
+func handlerQuotaUsage(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if principal, ok := optionalSingleParam(w, req, "principal", ""); ok {
+		writeResultData(w, QuotaUsageDto{quotaUsage(cfg, principal)})
+	}
+}
+
+// handlerRateLimitStatus processes requests that report the state of rate limits.
+func handlerRateLimitStatus(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	writeResultData(w, RateLimitStateDto{rateLimitState(cfg)})
+}
+
+// handlerMetrics processes requests that report metrics in Prometheus text format.
+func handlerMetrics(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	writeMetrics(cfg, w)
+}
+
+// handlerHealth processes requests checking if the server process is alive.
+func handlerHealth(_ *Configuration, w http.ResponseWriter, _ *http.Request) {
+	writeResultData(w, HealthDto{&Health{Status: "ok"}})
+}
+
+// handlerReady processes requests checking if the server is ready to process requests.
+func handlerReady(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	if problems := readiness(cfg); len(problems) > 0 {
+		writeResultError(w, errorDto(errServerNotReady, strings.Join(problems, "; ")))
+	} else {
+		writeResultData(w, HealthDto{&Health{Status: "ready"}})
+	}
+}
+
+// handlerVersion processes requests reporting the version of the server.
+func handlerVersion(_ *Configuration, w http.ResponseWriter, _ *http.Request) {
+	writeResultData(w, VersionDto{buildVersion()})
+}
+
+// handlerConfigReload processes requests that reload the configuration.
+func handlerConfigReload(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	if result, errorDto := reloadConfiguration(cfg); errorDto == nil {
+		writeResultData(w, ReloadResultDto{result})
+	} else {
+		writeResultError(w, errorDto)
+	}
+}
+
+// handlerSearch processes requests that search files by name, attributes and content.
+func handlerSearch(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+	if query, ok := searchParams(cfg, w, req); ok {
+		if result, errorDto := search(cfg, req, query); errorDto == nil {
+			writeResultData(w, result)
+		} else {
+			writeResultError(w, errorDto)
+		}
+	}
+}
+
+// handlerReplicationStatus processes requests that report the replication role and lag.
+func handlerReplicationStatus(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	writeResultData(w, replicationStatus(cfg))
+}
+
+// handlerReplicationPromote processes requests that promote the replica to primary.
+func handlerReplicationPromote(cfg *Configuration, w http.ResponseWriter, _ *http.Request) {
+	if status, errorDto := replicationPromote(cfg); errorDto == nil {
+		writeResultData(w, status)
+	} else {
+		writeResultError(w, errorDto)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexEntry stores attributes of single indexed file.
+type indexEntry struct {
+	size     int64
+	modified time.Time
+}
+
+// searchIndex keeps names and attributes of all files in memory, for fast repeated name queries.
+// The index is built in background, updated from change events and periodically rebuilt,
+// so that changes not reported by events (when file system watching is disabled) are picked up.
+type searchIndex struct {
+	cfg     *Configuration
+	mutex   sync.RWMutex
+	files   map[string]indexEntry // Indexed files by full name.
+	ready   bool                  // Flag indicating if the index was built at least once.
+	pending []*Event              // Events received while the index is rebuilt, nil when not rebuilding.
+}
+
+// startSearchIndex registers the index as event listener and builds it in background,
+// rebuilding periodically until the returned stop function is called.
+func startSearchIndex(cfg *Configuration) (*searchIndex, func()) {
+	index := &searchIndex{cfg: cfg, files: make(map[string]indexEntry)}
+	cfg.events.listen(index.update)
+	done := make(chan struct{})
+	go func() {
+		interval := time.Duration(valueOrDefault(cfg.Search.IndexInterval, defaultIndexInterval)) * time.Second
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			index.rebuild()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return index, func() { close(done) }
+}
+
+// rebuild walks the whole directory tree and replaces the content of the index.
+// Events received during the walk are applied again to the rebuilt index,
+// so changes of already walked directories are not lost.
+func (x *searchIndex) rebuild() {
+	x.mutex.Lock()
+	x.pending = make([]*Event, 0)
+	x.mutex.Unlock()
+	files := make(map[string]indexEntry)
+	_ = filepath.Walk(x.cfg.RootDirectory, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if x.cfg.isStatePath(fullName) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			files[relativeName(x.cfg, fullName)] = indexEntry{size: info.Size(), modified: info.ModTime().UTC()}
+		}
+		return nil
+	})
+	x.mutex.Lock()
+	x.files = files
+	for _, event := range x.pending {
+		x.apply(event)
+	}
+	x.pending = nil
+	x.ready = true
+	x.mutex.Unlock()
+}
+
+// update applies the change event to the index, and remembers it when the index is rebuilt.
+func (x *searchIndex) update(event *Event) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.pending != nil {
+		x.pending = append(x.pending, event)
+	}
+	x.apply(event)
+}
+
+// apply applies the change event to the index. Must be called with the mutex held.
+func (x *searchIndex) apply(event *Event) {
+	switch event.Type {
+	case EventCreate, EventWrite, EventAppend:
+		x.refresh(event.Name)
+	case EventDelete:
+		x.remove(event.Name)
+	case EventMove:
+		x.remove(event.Name)
+		x.refresh(event.Target)
+	}
+}
+
+// refresh reads attributes of the file, or of all files in the directory, with specified name.
+// Must be called with the mutex held.
+func (x *searchIndex) refresh(name string) {
+	fullName := prepareAbsolutePath(x.cfg, name)
+	info, err := os.Stat(fullName)
+	if err != nil {
+		return
+	}
+	if info.Mode().IsRegular() {
+		x.files[name] = indexEntry{size: info.Size(), modified: info.ModTime().UTC()}
+		return
+	}
+	if info.IsDir() {
+		_ = filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if x.cfg.isStatePath(filePath) {
+				return filepath.SkipDir
+			}
+			if info.Mode().IsRegular() {
+				x.files[relativeName(x.cfg, filePath)] = indexEntry{size: info.Size(), modified: info.ModTime().UTC()}
+			}
+			return nil
+		})
+	}
+}
+
+// remove removes the file, or all files in the directory, with specified name.
+// Must be called with the mutex held.
+func (x *searchIndex) remove(name string) {
+	if _, ok := x.files[name]; ok {
+		delete(x.files, name)
+		return
+	}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for fileName := range x.files {
+		if strings.HasPrefix(fileName, prefix) {
+			delete(x.files, fileName)
+		}
+	}
+}
+
+// find returns indexed files satisfying all criteria other than content.
+// Returns false when the index is not built yet.
+func (x *searchIndex) find(query *searchQuery) ([]*SearchResult, bool) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	if !x.ready {
+		return nil, false
+	}
+	candidates := make([]*SearchResult, 0)
+	for name, entry := range x.files {
+		if query.matches(name, entry.size, entry.modified) {
+			candidates = append(candidates, &SearchResult{Name: name, Size: entry.size, Modified: entry.modified})
+		}
+	}
+	return candidates, true
+}
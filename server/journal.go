@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	journalDirectory         = "journal"  // Name of the directory for journal segments, created in state directory.
+	journalSegmentPrefix     = "segment-" // Prefix of journal segment file names, followed by the sequence number of the first entry.
+	journalSegmentSuffix     = ".log"     // Suffix of journal segment file names.
+	defaultMaxSegmentSize    = 64 << 20   // Default maximum size of single journal segment in bytes.
+	defaultJournalReadLimit  = 1000       // Default number of entries returned by single read.
+	maxJournalReadLimit      = 10000      // Maximum number of entries returned by single read.
+	journalSequenceNameWidth = 20         // Width of the sequence number in segment file names, keeps names sortable.
+)
+
+var errJournalClosed = errors.New("journal closed")
+
+// JournalEntry records single mutating operation.
+type JournalEntry struct {
+	Sequence  uint64    `json:"seq"                  api:"Sequence number of the entry, increasing without gaps."`
+	Op        string    `json:"op"                   api:"Operation: create, write, append, delete or move."`
+	Name      string    `json:"name"                 api:"Name of the changed file or directory."`
+	Target    string    `json:"target,omitempty"     api:"New name of moved file or directory."`
+	Directory bool      `json:"directory,omitempty"  api:"Flag indicating if the changed item is a directory."`
+	Size      int64     `json:"size,omitempty"       api:"File size after the operation."`
+	Digest    string    `json:"digest,omitempty"     api:"File checksum (SHA256) after the operation."`
+	Actor     string    `json:"actor,omitempty"      api:"Principal or client address that made the change."`
+	Time      time.Time `json:"time"                 api:"The time when the operation was recorded."`
+}
+
+// JournalDto is the implementation of DTO for journal entries.
+type JournalDto struct {
+	Data []*JournalEntry `json:"data"  api:"Journal entries in the order of sequence numbers."`
+	Next uint64          `json:"next"  api:"Sequence number to read from in the next request."`
+	Last uint64          `json:"last"  api:"Sequence number of the last recorded entry."`
+}
+
+// journal is an append-only log of mutating operations, stored in segment files.
+// Every entry is synced to disk before the operation is reported as completed.
+// When the current segment exceeds configured size, new segment is started,
+// and the oldest segments above configured count are removed.
+type journal struct {
+	cfg       *Configuration
+	directory string
+	mutex     sync.Mutex
+	segment   *os.File // Currently written segment.
+	size      int64    // Size of currently written segment.
+	last      uint64   // Sequence number of the last recorded entry.
+}
+
+// openJournal opens the journal and recovers the sequence number of the last recorded entry.
+func openJournal(cfg *Configuration) (*journal, error) {
+	j := &journal{cfg: cfg, directory: filepath.Join(cfg.stateDirectory(), journalDirectory)}
+	if err := os.MkdirAll(j.directory, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return j, j.rotate()
+	}
+	lastSegment := segments[len(segments)-1]
+	j.last = lastSegment - 1
+	if err = j.scan(lastSegment, func(entry *JournalEntry) bool {
+		j.last = entry.Sequence
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if j.segment, err = os.OpenFile(j.segmentName(lastSegment), os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	info, err := j.segment.Stat()
+	if err != nil {
+		return nil, err
+	}
+	j.size = info.Size()
+	// terminate incomplete last line left after a crash, so it does not corrupt the next entry
+	if j.size > 0 {
+		last := make([]byte, 1)
+		if file, err := os.Open(j.segmentName(lastSegment)); err == nil {
+			_, err = file.ReadAt(last, j.size-1)
+			_ = file.Close()
+			if err == nil && last[0] != '\n' {
+				if _, err = j.segment.Write([]byte{'\n'}); err != nil {
+					return nil, err
+				}
+				j.size++
+			}
+		}
+	}
+	return j, nil
+}
+
+// segmentName returns the absolute name of the segment starting with specified sequence number.
+func (j *journal) segmentName(first uint64) string {
+	return filepath.Join(j.directory, fmt.Sprintf("%s%0*d%s", journalSegmentPrefix, journalSequenceNameWidth, first, journalSegmentSuffix))
+}
+
+// segments returns the sequence numbers of the first entries of all segments, in ascending order.
+func (j *journal) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(j.directory)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, journalSegmentPrefix) && strings.HasSuffix(name, journalSegmentSuffix) {
+			first, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, journalSegmentPrefix), journalSegmentSuffix), 10, 64)
+			if err == nil {
+				segments = append(segments, first)
+			}
+		}
+	}
+	sort.Slice(segments, func(a, b int) bool { return segments[a] < segments[b] })
+	return segments, nil
+}
+
+// scan reads all entries of the segment, until the callback returns false.
+// Incomplete last line, left after a crash, is ignored.
+func (j *journal) scan(first uint64, callback func(*JournalEntry) bool) error {
+	file, err := os.Open(j.segmentName(first))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logError(err)
+		}
+	}()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !callback(&entry) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// rotate closes the current segment and starts a new one. Oldest segments
+// exceeding the configured number of segments are removed.
+func (j *journal) rotate() error {
+	if j.segment != nil {
+		if err := j.segment.Close(); err != nil {
+			return err
+		}
+	}
+	segment, err := os.OpenFile(j.segmentName(j.last+1), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.segment = segment
+	j.size = 0
+	if maxSegments := j.cfg.Journal.MaxSegments; maxSegments > 0 {
+		segments, err := j.segments()
+		if err != nil {
+			return err
+		}
+		for len(segments) > maxSegments {
+			if err := os.Remove(j.segmentName(segments[0])); err != nil {
+				return err
+			}
+			segments = segments[1:]
+		}
+	}
+	return nil
+}
+
+// record appends the event emitted by handlers or by the replica to the journal,
+// so the promoted replica can be followed by other replicas.
+func (j *journal) record(event *Event) {
+	if event.Source == eventSourceFs || event.Type == EventOverflow {
+		return
+	}
+	if _, err := j.append(&JournalEntry{
+		Op:        event.Type,
+		Name:      event.Name,
+		Target:    event.Target,
+		Directory: event.Directory,
+		Size:      event.Size,
+		Digest:    event.Digest,
+		Actor:     event.Actor,
+		Time:      event.Time,
+	}); err != nil {
+		logError(err)
+	}
+}
+
+// append assigns the next sequence number to the entry and writes it durably to the journal.
+func (j *journal) append(entry *JournalEntry) (uint64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.segment == nil {
+		return 0, errJournalClosed
+	}
+	maxSegmentSize := j.cfg.Journal.MaxSegmentSize
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = defaultMaxSegmentSize
+	}
+	if j.size >= maxSegmentSize {
+		if err := j.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	entry.Sequence = j.last + 1
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+	if _, err = j.segment.Write(data); err != nil {
+		return 0, err
+	}
+	if err = j.segment.Sync(); err != nil {
+		return 0, err
+	}
+	j.size += int64(len(data))
+	j.last = entry.Sequence
+	return entry.Sequence, nil
+}
+
+// lastSequence returns the sequence number of the last recorded entry.
+func (j *journal) lastSequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.last
+}
+
+// read returns at most 'limit' entries, starting with the entry with sequence number 'from'.
+func (j *journal) read(from uint64, limit int) ([]*JournalEntry, error) {
+	entries := make([]*JournalEntry, 0)
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+	// skip segments containing only entries older than requested
+	start := 0
+	for i, first := range segments {
+		if first <= from {
+			start = i
+		}
+	}
+	for _, first := range segments[min(start, len(segments)):] {
+		if err = j.scan(first, func(entry *JournalEntry) bool {
+			if entry.Sequence >= from {
+				entries = append(entries, entry)
+			}
+			return len(entries) < limit
+		}); err != nil {
+			return nil, err
+		}
+		if len(entries) >= limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// close closes the currently written segment.
+func (j *journal) close() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.segment != nil {
+		if err := j.segment.Close(); err != nil {
+			logError(err)
+		}
+		j.segment = nil
+	}
+}
+
+// journalRead reads journal entries starting with specified sequence number.
+func journalRead(cfg *Configuration, from uint64, limit int) (*JournalDto, *ErrorDto) {
+	if cfg.journal == nil {
+		return nil, errorDto(errJournalNotEnabled, errMsgCheckServerLogForDetails)
+	}
+	if limit <= 0 {
+		limit = defaultJournalReadLimit
+	}
+	limit = min(limit, maxJournalReadLimit)
+	last := cfg.journal.lastSequence()
+	entries, err := cfg.journal.read(from, limit)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReadingJournalFailed, errMsgCheckServerLogForDetails)
+	}
+	next := from
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Sequence + 1
+	}
+	return &JournalDto{Data: entries, Next: next, Last: last}, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRecordedBeforeNotifyReturns(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Journal.Enabled = true
+	cfg.checksums = newChecksumCache(cfg)
+	cfg.events = newEventHub(0, 0)
+	var err error
+	if cfg.journal, err = openJournal(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.journal.close()
+	cfg.events.record(cfg.journal.record)
+	req := httptest.NewRequest(HttpPOST, routeFileWrite, nil)
+	for i, name := range []string{"/a.txt", "/b.txt", "/a.txt"} {
+		if err = os.WriteFile(filepath.Join(cfg.RootDirectory, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		notify(cfg, req, &Event{Type: EventWrite, Name: name})
+		// no waiting, the entry must be synced before the request is completed
+		if last := cfg.journal.lastSequence(); last != uint64(i+1) {
+			t.Fatalf("%s: expected %d journal entries, actual %d", name, i+1, last)
+		}
+	}
+	entries, err := cfg.journal.read(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/a.txt", "/b.txt", "/a.txt"}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d journal entries, actual %d", len(expected), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Name != expected[i] || entry.Digest == "" || entry.Time.IsZero() {
+			t.Errorf("unexpected journal entry %+v", entry)
+		}
+	}
+	cfg.events.close()
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxIdleBandwidths = 10000 // Number of principal bandwidth limits above which the idle ones are removed.
+
+// downloadRoutes lists routes whose responses are throttled by download rates.
+var downloadRoutes = map[string]bool{routeFileRead: true, routeFileShared: true, routeDirectoryArchive: true}
+
+// connectionKey is the key of connection bandwidth limits stored in connection context.
+type connectionKey struct{}
+
+// routeOf returns the route of the request without URL prefix, for shared files the route prefix.
+func routeOf(cfg *Configuration, req *http.Request) string {
+	route := strings.TrimPrefix(req.URL.Path, cfg.UrlPrefix)
+	if strings.HasPrefix(route, routeFileShared) {
+		return routeFileShared
+	}
+	return route
+}
+
+// maxUploadSize returns the maximum size of the request body for the route, 0 when not limited.
+func (c *TransferConfiguration) maxUploadSize(route string) int64 {
+	if size, ok := c.MaxUploadSizes[route]; ok {
+		return size
+	}
+	return c.MaxUploadSize
+}
+
+// bandwidth limits the transfer rate using token bucket, with the capacity of one second of transfer.
+// Tokens are reserved in advance, the caller waits until the reserved tokens are refilled.
+type bandwidth struct {
+	rate   float64 // Bytes per second.
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidth(rate int64) *bandwidth {
+	return &bandwidth{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take reserves tokens for transferring specified number of bytes, and waits until they are available.
+func (b *bandwidth) take(ctx context.Context, count int) error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate) - float64(count)
+	b.last = now
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns the largest number of bytes transferred at once, so that no transfer
+// exceeds the bucket capacity of any limit.
+func chunk(size int, limits []*bandwidth) int {
+	for _, limit := range limits {
+		if limit != nil {
+			size = min(size, max(1, int(limit.rate)))
+		}
+	}
+	return size
+}
+
+// connectionBandwidth holds bandwidth limits shared by all requests sent over single connection.
+type connectionBandwidth struct {
+	upload   *bandwidth
+	download *bandwidth
+}
+
+// connectionContext attaches bandwidth limits to every accepted connection.
+func connectionContext(cfg *Configuration) func(context.Context, net.Conn) context.Context {
+	return func(ctx context.Context, _ net.Conn) context.Context {
+		cfg := cfg.current()
+		limits := &connectionBandwidth{}
+		if cfg.Transfer.ConnectionUploadRate > 0 {
+			limits.upload = newBandwidth(cfg.Transfer.ConnectionUploadRate)
+		}
+		if cfg.Transfer.ConnectionDownloadRate > 0 {
+			limits.download = newBandwidth(cfg.Transfer.ConnectionDownloadRate)
+		}
+		return context.WithValue(ctx, connectionKey{}, limits)
+	}
+}
+
+// principalBandwidths holds bandwidth limits shared by all requests of single principal
+// (or single client address, when not authenticated).
+type principalBandwidths struct {
+	mutex     sync.Mutex
+	uploads   map[string]*bandwidth
+	downloads map[string]*bandwidth
+}
+
+func newPrincipalBandwidths() *principalBandwidths {
+	return &principalBandwidths{uploads: make(map[string]*bandwidth), downloads: make(map[string]*bandwidth)}
+}
+
+// get returns the bandwidth limit of the principal, created when missing.
+func (p *principalBandwidths) get(limits map[string]*bandwidth, actor string, rate int64) *bandwidth {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	limit, ok := limits[actor]
+	if !ok {
+		if len(limits) >= maxIdleBandwidths {
+			// limits refilled to full capacity are idle and can be created again when needed
+			now := time.Now()
+			for name, idle := range limits {
+				idle.mutex.Lock()
+				full := idle.tokens+now.Sub(idle.last).Seconds()*idle.rate >= idle.rate
+				idle.mutex.Unlock()
+				if full {
+					delete(limits, name)
+				}
+			}
+		}
+		limit = newBandwidth(rate)
+		limits[actor] = limit
+	}
+	return limit
+}
+
+// throttledReader reads the request body within bandwidth limits.
+type throttledReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	limits []*bandwidth
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	count, err := r.ReadCloser.Read(p[:chunk(len(p), r.limits)])
+	for _, limit := range r.limits {
+		if err := limit.take(r.ctx, count); err != nil {
+			return count, err
+		}
+	}
+	return count, err
+}
+
+// throttledWriter writes the response within bandwidth limits.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	limits []*bandwidth
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		size := chunk(len(p)-written, w.limits)
+		for _, limit := range w.limits {
+			if err := limit.take(w.ctx, size); err != nil {
+				return written, err
+			}
+		}
+		count, err := w.ResponseWriter.Write(p[written : written+size])
+		written += count
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Unwrap returns the original response writer, used by http.ResponseController.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends buffered data to the client.
+func (w *throttledWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// limitTransfer applies configured limits to the request: the size of the request body is checked
+// against the declared content length before anything is read, and limited while reading,
+// the request body and responses of download routes are throttled. Returns false when
+// the request was rejected.
+func limitTransfer(cfg *Configuration, w http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request, bool) {
+	route := routeOf(cfg, req)
+	if maxSize := cfg.Transfer.maxUploadSize(route); maxSize > 0 && req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength > maxSize {
+			writeResultError(w, errorDto(errRequestTooLarge, "maximum size "+strconv.FormatInt(maxSize, 10)+" bytes"))
+			return w, req, false
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, maxSize)
+	}
+	connection, _ := req.Context().Value(connectionKey{}).(*connectionBandwidth)
+	if connection == nil {
+		connection = &connectionBandwidth{}
+	}
+	actor := requestActor(req)
+	if req.Body != nil && req.Body != http.NoBody {
+		limits := make([]*bandwidth, 0, 2)
+		if connection.upload != nil {
+			limits = append(limits, connection.upload)
+		}
+		if rate := cfg.Transfer.PrincipalUploadRate; rate > 0 {
+			limits = append(limits, cfg.bandwidths.get(cfg.bandwidths.uploads, actor, rate))
+		}
+		if len(limits) > 0 {
+			req.Body = &throttledReader{ReadCloser: req.Body, ctx: req.Context(), limits: limits}
+		}
+	}
+	if downloadRoutes[route] {
+		limits := make([]*bandwidth, 0, 2)
+		if connection.download != nil {
+			limits = append(limits, connection.download)
+		}
+		if rate := cfg.Transfer.PrincipalDownloadRate; rate > 0 {
+			limits = append(limits, cfg.bandwidths.get(cfg.bandwidths.downloads, actor, rate))
+		}
+		if len(limits) > 0 {
+			w = &throttledWriter{ResponseWriter: w, ctx: req.Context(), limits: limits}
+		}
+	}
+	return w, req, true
+}
+
+// bodyError converts the error encountered while reading the request body into error DTO,
+// reporting exceeded size limit with its own status.
+func bodyError(err error, fallback ErrorDto, detail string) *ErrorDto {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return errorDto(errRequestTooLarge, "maximum size "+strconv.FormatInt(maxBytesError.Limit, 10)+" bytes")
+	}
+	return errorDto(fallback, detail)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const systemdFirstFd = 3 // The first file descriptor passed by systemd socket activation.
+
+// systemdListeners returns sockets passed by systemd socket activation, with names
+// given in LISTEN_FDNAMES. Environment variables are cleared, so the sockets are taken only once.
+func systemdListeners() ([]net.Listener, []string, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]net.Listener, 0, count)
+	listenerNames := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(systemdFirstFd+i), "LISTEN_FD_"+strconv.Itoa(systemdFirstFd+i))
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("inherited socket %d: %w", systemdFirstFd+i, err)
+		}
+		listeners = append(listeners, listener)
+		listenerNames = append(listenerNames, name)
+	}
+	return listeners, listenerNames, nil
+}
+
+// unixListener listens on Unix socket, replacing stale socket file left by previous run,
+// and sets the mode and the owner of the socket file.
+func unixListener(listener *ListenerConfiguration) (net.Listener, error) {
+	if info, err := os.Lstat(listener.Socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("not a socket: " + listener.Socket)
+		}
+		if err = os.Remove(listener.Socket); err != nil {
+			return nil, err
+		}
+	}
+	unixListener, err := net.Listen("unix", listener.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err = configureSocket(listener); err != nil {
+		_ = unixListener.Close()
+		return nil, err
+	}
+	return unixListener, nil
+}
+
+// configureSocket sets the mode and the owner of the socket file.
+func configureSocket(listener *ListenerConfiguration) error {
+	if listener.Mode != "" {
+		mode, err := strconv.ParseUint(listener.Mode, 8, 32)
+		if err != nil {
+			return errors.New("invalid socket mode: " + listener.Mode)
+		}
+		if err = os.Chmod(listener.Socket, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+	if listener.Owner == "" && listener.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if listener.Owner != "" {
+		if id, err := strconv.Atoi(listener.Owner); err == nil {
+			uid = id
+		} else if owner, err := user.Lookup(listener.Owner); err == nil {
+			uid, _ = strconv.Atoi(owner.Uid)
+		} else {
+			return err
+		}
+	}
+	if listener.Group != "" {
+		if id, err := strconv.Atoi(listener.Group); err == nil {
+			gid = id
+		} else if group, err := user.LookupGroup(listener.Group); err == nil {
+			gid, _ = strconv.Atoi(group.Gid)
+		} else {
+			return err
+		}
+	}
+	return os.Chown(listener.Socket, uid, gid)
+}
+
+// openListeners opens all configured listeners, when none are configured, the server
+// listens on configured port on all interfaces. Inherited systemd sockets are matched by name,
+// listener without name takes all inherited sockets not taken by other listeners.
+func openListeners(cfg *Configuration) ([]net.Listener, error) {
+	if len(cfg.Listeners) == 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.ServerPort))
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+	listeners := make([]net.Listener, 0, len(cfg.Listeners))
+	fail := func(err error) ([]net.Listener, error) {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		return nil, err
+	}
+	var inherited []net.Listener
+	var inheritedNames []string
+	taken := make(map[int]bool)
+	for i := range cfg.Listeners {
+		listener := &cfg.Listeners[i]
+		switch {
+		case listener.Systemd:
+			if inherited == nil {
+				var err error
+				if inherited, inheritedNames, err = systemdListeners(); err != nil {
+					return fail(err)
+				}
+			}
+			found := false
+			for j, name := range inheritedNames {
+				if !taken[j] && (listener.Name == "" || listener.Name == name) {
+					listeners = append(listeners, inherited[j])
+					taken[j] = true
+					found = true
+				}
+			}
+			if !found {
+				return fail(errors.New("no inherited systemd socket named '" + listener.Name + "'"))
+			}
+		case listener.Socket != "":
+			unixListener, err := unixListener(listener)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, unixListener)
+		case listener.Address != "":
+			tcpListener, err := net.Listen("tcp", listener.Address)
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, tcpListener)
+		default:
+			return fail(errors.New("listener " + strconv.Itoa(i+1) + " has no address, socket or systemd flag"))
+		}
+	}
+	for j, listener := range inherited {
+		if !taken[j] {
+			_ = listener.Close()
+		}
+	}
+	return listeners, nil
+}
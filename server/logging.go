@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText          = "text"    // Log records written as 'key=value' pairs.
+	logFormatJson          = "json"    // Log records written as JSON objects.
+	defaultLogFileMaxSize  = 100 << 20 // Default size of log file in bytes, after which the file is rotated.
+	defaultLogFileMaxFiles = 5         // Default number of rotated log files kept.
+	requestIdHeader        = "X-Request-Id"
+	maxRequestIdLength     = 128 // Request identifiers sent by clients longer than this are replaced.
+)
+
+// logLevel is the minimal level of logged records, changed when the configuration is reloaded.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel returns the log level with specified name, info level when the name is empty.
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// configureLogging sets up the default logger according to the configuration, records of
+// the standard logger (used e.g. by the HTTP server) are written by the same logger.
+func configureLogging(cfg *Configuration) error {
+	level, err := parseLogLevel(cfg.Logging.Level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	var output io.Writer = os.Stderr
+	if cfg.Logging.File != "" {
+		maxSize := cfg.Logging.MaxSize
+		if maxSize <= 0 {
+			maxSize = defaultLogFileMaxSize
+		}
+		if output, err = openRotatingFile(cfg.Logging.File, maxSize, valueOrDefault(cfg.Logging.MaxFiles, defaultLogFileMaxFiles)); err != nil {
+			return err
+		}
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	if cfg.Logging.Format == logFormatJson {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(output, options)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(output, options)))
+	}
+	return nil
+}
+
+// requestId returns the identifier of the request sent by the client, or generates a new one.
+func requestId(req *http.Request, id uint64) string {
+	if value := req.Header.Get(requestIdHeader); value != "" && len(value) <= maxRequestIdLength {
+		return value
+	}
+	return strconv.FormatInt(serverStarted.UnixNano(), 36) + "-" + strconv.FormatUint(id, 36)
+}
+
+// serverStarted is the time the server process started, makes generated request identifiers unique across restarts.
+var serverStarted = time.Now()
+
+// logAccess writes the access log record of the completed request.
+func logAccess(cfg *Configuration, current *transfer) {
+	if !cfg.Logging.Access {
+		return
+	}
+	slog.Info("request",
+		slog.String("requestId", current.requestId),
+		slog.String("method", current.method),
+		slog.String("route", current.route),
+		slog.String("name", current.name),
+		slog.Int("status", int(current.status.Load())),
+		slog.Int64("received", current.received.Load()),
+		slog.Int64("sent", current.sent.Load()),
+		slog.Duration("duration", time.Since(current.started)),
+		slog.String("principal", current.principal),
+		slog.String("client", current.actor))
+}
+
+// rotatingFile is the log file renamed when it reaches the maximum size. Rotated files
+// are named with number suffix, e.g. 'tarolas.log.1' is the most recently rotated file.
+type rotatingFile struct {
+	mutex    sync.Mutex
+	name     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingFile opens the log file for appending, the file is created when missing.
+func openRotatingFile(name string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{name: name, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the current file and older rotated files, the oldest file is removed.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	for i := r.maxFiles - 1; i > 0; i-- {
+		_ = os.Rename(r.name+"."+strconv.Itoa(i), r.name+"."+strconv.Itoa(i+1))
+	}
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.name, r.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.name); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// keep logging to the file not rotated, or to standard error when it could not be reopened
+			fmt.Fprintf(os.Stderr, "rotating log file failed: %v\n", err)
+			if err = r.open(); err != nil {
+				return os.Stderr.Write(p)
+			}
+		}
+	}
+	count, err := r.file.Write(p)
+	r.size += int64(count)
+	return count, err
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	diffModeSync           = "sync"   // Newer file wins, nothing is deleted.
+	diffModePush           = "push"   // Server mirrors the client.
+	diffModePull           = "pull"   // Client mirrors the server.
+	maxManifestRequestSize = 64 << 20 // Maximum size of client manifest in bytes.
+)
+
+// ManifestEntry describes single file in directory manifest.
+type ManifestEntry struct {
+	Path   string    `json:"path"              api:"File name relative to the directory."`
+	Size   int64     `json:"size"              api:"File size in bytes."`
+	Mtime  time.Time `json:"mtime"             api:"The time of the last modification of the file."`
+	Digest string    `json:"digest,omitempty"  api:"File checksum (SHA256), when requested."`
+}
+
+// ManifestDto is the implementation of DTO for directory manifest.
+type ManifestDto struct {
+	Data []*ManifestEntry `json:"data"  api:"Manifest entries ordered by path."`
+}
+
+// ManifestDiff lists files that should be transferred or deleted to synchronize
+// the client with the server. All names are relative to the compared directory.
+type ManifestDiff struct {
+	Upload   []string `json:"upload"    api:"Files to be uploaded from client to server."`
+	Download []string `json:"download"  api:"Files to be downloaded from server to client."`
+	Delete   []string `json:"delete"    api:"Files to be deleted: on the server in push mode, on the client in pull mode."`
+}
+
+// ManifestDiffDto is the implementation of DTO for manifest differences.
+type ManifestDiffDto struct {
+	Data *ManifestDiff `json:"data"  api:"Manifest differences."`
+}
+
+// walkManifest calls the callback for every regular file in the directory with specified name,
+// matching the filter, in lexical order of paths.
+func walkManifest(cfg *Configuration, name string, filter *archiveFilter, callback func(*ManifestEntry, string) error) *ErrorDto {
+	fullName := prepareAbsolutePath(cfg, name)
+	if fileInfo, err := os.Stat(fullName); err != nil || !fileInfo.IsDir() {
+		return errorDto(errReadingDirectoryContentFailed, name)
+	}
+	err := filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		if filePath == fullName {
+			return nil
+		}
+		relativeName := filepath.ToSlash(strings.TrimPrefix(filePath, fullName+string(filepath.Separator)))
+		if info.IsDir() {
+			if matchesAny(filter.exclude, relativeName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !filter.matches(relativeName) {
+			return nil
+		}
+		return callback(&ManifestEntry{Path: relativeName, Size: info.Size(), Mtime: info.ModTime().UTC()}, filePath)
+	})
+	if err != nil {
+		logError(err)
+		return errorDto(errWalkingDirectoryTreeFailed, name)
+	}
+	return nil
+}
+
+// writeManifest streams the manifest of the directory with specified name, entry by entry,
+// so that even manifests of large trees are not held in memory. When requested, file checksums
+// are calculated. Errors encountered after the response status was sent can only be logged.
+func writeManifest(cfg *Configuration, w http.ResponseWriter, name string, digest bool, filter *archiveFilter) *ErrorDto {
+	fullName := prepareAbsolutePath(cfg, name)
+	if fileInfo, err := os.Stat(fullName); err != nil || !fileInfo.IsDir() {
+		return errorDto(errReadingDirectoryContentFailed, name)
+	}
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(200)
+	separator := ""
+	if _, err := io.WriteString(w, "{\"data\":["); err != nil {
+		return nil
+	}
+	errorDto := walkManifest(cfg, name, filter, func(entry *ManifestEntry, filePath string) error {
+		if digest {
+			if entry.Digest = localDigest(cfg, filePath); entry.Digest == "" {
+				return fmt.Errorf("calculating checksum of %s failed", filePath)
+			}
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, separator); err != nil {
+			return err
+		}
+		separator = ",\n"
+		_, err = w.Write(data)
+		return err
+	})
+	if errorDto != nil {
+		// the manifest is left incomplete, so the client does not mistake it for a valid one
+		return nil
+	}
+	if _, err := io.WriteString(w, "]}"); err != nil {
+		logError(err)
+	}
+	return nil
+}
+
+// directoryDiff compares the manifest sent by the client with the content of the directory
+// with specified name. In sync mode, files present only on one side are transferred to the other,
+// and changed files are transferred from the side with the newer modification time.
+// In push mode the server becomes a mirror of the client, in pull mode the client becomes
+// a mirror of the server, and files missing in the mirrored side are deleted.
+// Files with equal size are compared using checksums, when the client sends them,
+// otherwise files with equal size and modification time (in seconds) are considered equal.
+func directoryDiff(cfg *Configuration, req *http.Request, name string, mode string, filter *archiveFilter) (*ManifestDiff, *ErrorDto) {
+	if mode != diffModeSync && mode != diffModePush && mode != diffModePull {
+		return nil, errorDto(errInvalidParameterValue, "mode ("+mode+")")
+	}
+	var manifest ManifestDto
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxManifestRequestSize)).Decode(&manifest); err != nil {
+		return nil, bodyError(err, errInvalidRequestBody, err.Error())
+	}
+	client := make(map[string]*ManifestEntry, len(manifest.Data))
+	for _, entry := range manifest.Data {
+		if entry == nil {
+			continue
+		}
+		relativeName := path.Clean(strings.TrimPrefix(entry.Path, "/"))
+		if relativeName == "." || relativeName == ".." || strings.HasPrefix(relativeName, "../") {
+			return nil, errorDto(errInvalidRequestBody, "path ("+entry.Path+")")
+		}
+		if filter.matches(relativeName) && !matchesAnyParent(filter.exclude, relativeName) {
+			entry.Path = relativeName
+			client[relativeName] = entry
+		}
+	}
+	diff := &ManifestDiff{Upload: make([]string, 0), Download: make([]string, 0), Delete: make([]string, 0)}
+	if errorDto := walkManifest(cfg, name, filter, func(server *ManifestEntry, filePath string) error {
+		entry, ok := client[server.Path]
+		if !ok {
+			if mode == diffModePush {
+				diff.Delete = append(diff.Delete, server.Path)
+			} else {
+				diff.Download = append(diff.Download, server.Path)
+			}
+			return nil
+		}
+		delete(client, server.Path)
+		if filesEqual(cfg, entry, server, filePath) {
+			return nil
+		}
+		if mode == diffModePush || (mode == diffModeSync && entry.Mtime.After(server.Mtime)) {
+			diff.Upload = append(diff.Upload, server.Path)
+		} else {
+			diff.Download = append(diff.Download, server.Path)
+		}
+		return nil
+	}); errorDto != nil {
+		return nil, errorDto
+	}
+	for relativeName := range client {
+		if mode == diffModePull {
+			diff.Delete = append(diff.Delete, relativeName)
+		} else {
+			diff.Upload = append(diff.Upload, relativeName)
+		}
+	}
+	sort.Strings(diff.Upload)
+	sort.Strings(diff.Delete)
+	return diff, nil
+}
+
+// filesEqual compares the file described by the client with the file on the server.
+func filesEqual(cfg *Configuration, client *ManifestEntry, server *ManifestEntry, filePath string) bool {
+	if client.Size != server.Size {
+		return false
+	}
+	if client.Digest != "" {
+		return strings.EqualFold(client.Digest, localDigest(cfg, filePath))
+	}
+	return client.Mtime.Unix() == server.Mtime.Unix()
+}
+
+// matchesAnyParent returns true when any parent directory of the file matches any of the patterns.
+func matchesAnyParent(patterns []string, relativeName string) bool {
+	for directory := path.Dir(relativeName); directory != "."; directory = path.Dir(directory) {
+		if matchesAny(patterns, directory) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8" // Content type of Prometheus text format.
+
+// latencyBuckets are upper bounds (in seconds) of request duration histogram buckets,
+// long buckets are included for transfers of large files.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// requestKey identifies requests counted together.
+type requestKey struct {
+	route  string
+	status string
+}
+
+// requestMetric counts requests and their durations.
+type requestMetric struct {
+	count   int64
+	sum     float64 // Total duration in seconds.
+	buckets []int64 // Number of requests not longer than corresponding latency bucket.
+}
+
+// routeBytes counts bytes transferred by requests sent to single route.
+type routeBytes struct {
+	received int64
+	sent     int64
+}
+
+// metrics collects statistics of completed requests, reported in Prometheus text format.
+type metrics struct {
+	mutex    sync.Mutex
+	requests map[requestKey]*requestMetric
+	bytes    map[string]*routeBytes // Transferred bytes by route.
+	errors   map[string]int64       // Number of error responses by error code.
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[requestKey]*requestMetric), bytes: make(map[string]*routeBytes), errors: make(map[string]int64)}
+}
+
+// observe adds the completed request to metrics.
+func (m *metrics) observe(current *transfer) {
+	if m == nil {
+		return
+	}
+	status := int(current.status.Load())
+	if status == 0 {
+		// nothing was written, the server responds with status 200
+		status = http.StatusOK
+	}
+	duration := time.Since(current.started).Seconds()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := requestKey{route: current.route, status: strconv.Itoa(status)}
+	request, ok := m.requests[key]
+	if !ok {
+		request = &requestMetric{buckets: make([]int64, len(latencyBuckets))}
+		m.requests[key] = request
+	}
+	request.count++
+	request.sum += duration
+	for i, bound := range latencyBuckets {
+		if duration <= bound {
+			request.buckets[i]++
+		}
+	}
+	transferred, ok := m.bytes[current.route]
+	if !ok {
+		transferred = &routeBytes{}
+		m.bytes[current.route] = transferred
+	}
+	transferred.received += current.received.Load()
+	transferred.sent += current.sent.Load()
+	if current.errorCode != "" {
+		m.errors[current.errorCode]++
+	}
+}
+
+// metricsWriter writes metric families in Prometheus text format.
+type metricsWriter struct {
+	buffer bytes.Buffer
+}
+
+// family writes the help and type of metric family.
+func (w *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(&w.buffer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes single sample, labels are given as name and value pairs.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buffer.WriteString(name)
+	if len(labels) > 0 {
+		w.buffer.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.buffer.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buffer, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.buffer.WriteByte('}')
+	}
+	w.buffer.WriteByte(' ')
+	w.buffer.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buffer.WriteByte('\n')
+}
+
+// escapeLabel escapes backslashes, quotes and new lines in label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeMetrics writes all metrics in Prometheus text format.
+func writeMetrics(cfg *Configuration, w http.ResponseWriter) {
+	out := &metricsWriter{}
+	cfg.metrics.write(out)
+	out.family("tarolas_transfers_in_flight", "gauge", "Number of requests being processed.")
+	out.sample("tarolas_transfers_in_flight", float64(len(cfg.transfers.list())))
+	if cfg.checksums != nil {
+		out.family("tarolas_checksum_cache_hits_total", "counter", "Number of checksums read from cache.")
+		out.sample("tarolas_checksum_cache_hits_total", float64(cfg.checksums.hits.Load()))
+		out.family("tarolas_checksum_cache_misses_total", "counter", "Number of checksums calculated from file content.")
+		out.sample("tarolas_checksum_cache_misses_total", float64(cfg.checksums.misses.Load()))
+	}
+	if stats, err := fileSystemStats(cfg.RootDirectory); err == nil {
+		out.family("tarolas_storage_size_bytes", "gauge", "Total size of the file system holding the root directory.")
+		out.sample("tarolas_storage_size_bytes", float64(stats.Total))
+		out.family("tarolas_storage_free_bytes", "gauge", "Free space of the file system holding the root directory.")
+		out.sample("tarolas_storage_free_bytes", float64(stats.Free))
+		out.family("tarolas_storage_available_bytes", "gauge", "Free space available to unprivileged users.")
+		out.sample("tarolas_storage_available_bytes", float64(stats.Available))
+	}
+	if states := rateLimitState(cfg); len(states) > 0 {
+		out.family("tarolas_rate_limit_clients", "gauge", "Number of clients tracked by rate limit.")
+		for _, state := range states {
+			out.sample("tarolas_rate_limit_clients", float64(state.Clients), "route", state.Route)
+		}
+		out.family("tarolas_rate_limit_exhausted_clients", "gauge", "Number of clients currently rejected by rate limit.")
+		for _, state := range states {
+			out.sample("tarolas_rate_limit_exhausted_clients", float64(state.Exhausted), "route", state.Route)
+		}
+		out.family("tarolas_rate_limit_rejected_total", "counter", "Number of requests rejected by rate limit.")
+		for _, state := range states {
+			out.sample("tarolas_rate_limit_rejected_total", float64(state.Rejected), "route", state.Route)
+		}
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(out.buffer.Bytes()); err != nil {
+		logError(err)
+	}
+}
+
+// write writes request, transfer and error metrics, sorted by labels.
+func (m *metrics) write(out *metricsWriter) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].route < keys[b].route || keys[a].route == keys[b].route && keys[a].status < keys[b].status
+	})
+	out.family("tarolas_requests_total", "counter", "Number of processed requests.")
+	for _, key := range keys {
+		out.sample("tarolas_requests_total", float64(m.requests[key].count), "route", key.route, "status", key.status)
+	}
+	out.family("tarolas_request_duration_seconds", "histogram", "Duration of processed requests.")
+	for _, key := range keys {
+		request := m.requests[key]
+		for i, bound := range latencyBuckets {
+			out.sample("tarolas_request_duration_seconds_bucket", float64(request.buckets[i]),
+				"route", key.route, "status", key.status, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		out.sample("tarolas_request_duration_seconds_bucket", float64(request.count), "route", key.route, "status", key.status, "le", "+Inf")
+		out.sample("tarolas_request_duration_seconds_sum", request.sum, "route", key.route, "status", key.status)
+		out.sample("tarolas_request_duration_seconds_count", float64(request.count), "route", key.route, "status", key.status)
+	}
+	routes := make([]string, 0, len(m.bytes))
+	for route := range m.bytes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	out.family("tarolas_received_bytes_total", "counter", "Number of bytes read from request bodies.")
+	for _, route := range routes {
+		out.sample("tarolas_received_bytes_total", float64(m.bytes[route].received), "route", route)
+	}
+	out.family("tarolas_sent_bytes_total", "counter", "Number of bytes written to response bodies.")
+	for _, route := range routes {
+		out.sample("tarolas_sent_bytes_total", float64(m.bytes[route].sent), "route", route)
+	}
+	codes := make([]string, 0, len(m.errors))
+	for code := range m.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	out.family("tarolas_errors_total", "counter", "Number of error responses by error code.")
+	for _, code := range codes {
+		out.sample("tarolas_errors_total", float64(m.errors[code]), "code", code)
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	environmentPrefix = "TAROLAS_" // Prefix of environment variables overriding configuration options.
+	redactedValue     = "********" // Value shown instead of secrets.
+)
+
+// plainScalar is the unquoted scalar read from YAML, its type is resolved
+// according to the configuration field it is assigned to.
+type plainScalar string
+
+// ParseConfiguration parses configuration in JSON, YAML or TOML format, the format is given
+// by the extension of configuration file name, JSON is used for unknown extensions.
+// Options are named the same way in all formats, unknown YAML and TOML options are rejected.
+func ParseConfiguration(data []byte, fileName string) (*Configuration, error) {
+	var cfg Configuration
+	var tree interface{}
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		tree, err = parseYaml(string(data))
+	case ".toml":
+		tree, err = parseToml(string(data))
+	default:
+		return &cfg, json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeNode(reflect.ValueOf(&cfg).Elem(), tree, ""); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// jsonName returns the name of the option stored in the structure field, empty for fields not stored.
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// join returns the path of the option nested in the option with specified path.
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// decodeNode assigns the value parsed from configuration file, environment variable or command line
+// to the configuration field. Values of structures, lists and maps given as strings are parsed as JSON.
+func decodeNode(target reflect.Value, node interface{}, path string) error {
+	if node == nil {
+		return nil
+	}
+	text, isText := node.(string)
+	if plain, ok := node.(plainScalar); ok {
+		text, isText = string(plain), true
+	}
+	switch target.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		if isText {
+			if err := json.Unmarshal([]byte(text), target.Addr().Interface()); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			return nil
+		}
+	}
+	switch target.Kind() {
+	case reflect.Struct:
+		values, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be a table of options", path)
+		}
+		for key, value := range values {
+			field, ok := structField(target, key)
+			if !ok {
+				return fmt.Errorf("%s: unknown option", join(path, key))
+			}
+			if err := decodeNode(field, value, join(path, key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		values, ok := node.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be a list", path)
+		}
+		slice := reflect.MakeSlice(target.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := decodeNode(slice.Index(i), value, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Map:
+		values, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be a table", path)
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMap(target.Type()))
+		}
+		for key, value := range values {
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeNode(element, value, join(path, key)); err != nil {
+				return err
+			}
+			target.SetMapIndex(reflect.ValueOf(key), element)
+		}
+	case reflect.String:
+		if !isText {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		target.SetString(text)
+	case reflect.Bool:
+		switch value := node.(type) {
+		case bool:
+			target.SetBool(value)
+		default:
+			parsed, err := strconv.ParseBool(text)
+			if !isText || err != nil {
+				return fmt.Errorf("%s: must be true or false", path)
+			}
+			target.SetBool(parsed)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var number int64
+		switch value := node.(type) {
+		case int64:
+			number = value
+		case float64:
+			if value != math.Trunc(value) {
+				return fmt.Errorf("%s: must be an integer", path)
+			}
+			number = int64(value)
+		default:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+			if !isText || err != nil {
+				return fmt.Errorf("%s: must be an integer", path)
+			}
+			number = parsed
+		}
+		if target.OverflowInt(number) {
+			return fmt.Errorf("%s: integer out of range", path)
+		}
+		target.SetInt(number)
+	case reflect.Float32, reflect.Float64:
+		switch value := node.(type) {
+		case int64:
+			target.SetFloat(float64(value))
+		case float64:
+			target.SetFloat(value)
+		default:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if !isText || err != nil {
+				return fmt.Errorf("%s: must be a number", path)
+			}
+			target.SetFloat(parsed)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported option type", path)
+	}
+	return nil
+}
+
+// structField returns the field of the structure storing the option with specified name.
+func structField(target reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < target.NumField(); i++ {
+		if fieldName := jsonName(target.Type().Field(i)); fieldName != "" && strings.EqualFold(fieldName, name) {
+			return target.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// environmentName converts the option name to the part of environment variable name,
+// e.g. 'maxUploadSize' is converted to 'MAX_UPLOAD_SIZE'.
+func environmentName(name string) string {
+	var builder strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			builder.WriteByte('_')
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+	}
+	return builder.String()
+}
+
+// environmentField returns the field of the option named by the environment variable name
+// without prefix, e.g. 'TRASH_EXPIRE_DAYS' names the 'expireDays' option of 'trash' options.
+func environmentField(target reflect.Value, name string, path string) (reflect.Value, string, bool) {
+	if name == "" {
+		return target, path, true
+	}
+	if target.Kind() != reflect.Struct {
+		return reflect.Value{}, "", false
+	}
+	for i := 0; i < target.NumField(); i++ {
+		fieldName := jsonName(target.Type().Field(i))
+		if fieldName == "" {
+			continue
+		}
+		prefix := environmentName(fieldName)
+		if name == prefix || strings.HasPrefix(name, prefix+"_") {
+			rest := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "_")
+			if field, fieldPath, ok := environmentField(target.Field(i), rest, join(path, fieldName)); ok {
+				return field, fieldPath, true
+			}
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+// ApplyEnvironment overrides configuration options with values of 'TAROLAS_*' environment variables
+// given in 'NAME=value' form. Variable names are upper case option paths with words separated
+// by underscores, e.g. 'TAROLAS_SERVER_PORT' or 'TAROLAS_TRASH_EXPIRE_DAYS'. Lists and tables
+// of options are given in JSON, e.g. 'TAROLAS_AUTH_KEYS=[{"principal":"backup","key":"..."}]'.
+// Variables not naming any option are logged and skipped, as they may be set for other purposes.
+func (c *Configuration) ApplyEnvironment(environment []string) error {
+	problems := make([]error, 0)
+	for _, variable := range environment {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, environmentPrefix) {
+			continue
+		}
+		field, path, ok := environmentField(reflect.ValueOf(c).Elem(), strings.TrimPrefix(name, environmentPrefix), "")
+		if !ok {
+			slog.Warn("environment variable does not name any configuration option, skipped", slog.String("variable", name))
+		} else if err := decodeNode(field, value, path); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// Set overrides the configuration option given by path with the value. Path contains option names
+// separated by dots, list items are selected by index and map entries by key, e.g. 'trash.expireDays',
+// 'auth.keys.0.key' or 'transfer.maxUploadSizes./file/write'. Lists and tables are given in JSON.
+func (c *Configuration) Set(path string, value string) error {
+	target := reflect.ValueOf(c).Elem()
+	names := strings.Split(path, ".")
+	for i := 0; i < len(names); i++ {
+		name := names[i]
+		switch target.Kind() {
+		case reflect.Struct:
+			field, ok := structField(target, name)
+			if !ok {
+				return fmt.Errorf("%s: unknown option", path)
+			}
+			target = field
+		case reflect.Slice:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= target.Len() {
+				return fmt.Errorf("%s: invalid list index %q", path, name)
+			}
+			target = target.Index(index)
+		case reflect.Map:
+			// the rest of the path is the key, keys may contain dots
+			key := strings.Join(names[i:], ".")
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeNode(element, value, path); err != nil {
+				return err
+			}
+			if target.IsNil() {
+				target.Set(reflect.MakeMap(target.Type()))
+			}
+			target.SetMapIndex(reflect.ValueOf(key), element)
+			return nil
+		default:
+			return fmt.Errorf("%s: unknown option", path)
+		}
+	}
+	return decodeNode(target, value, path)
+}
+
+// Redacted returns the copy of the configuration with secrets (API keys, webhook secrets) replaced.
+func (c *Configuration) Redacted() *Configuration {
+	var redacted Configuration
+	data, _ := json.Marshal(c)
+	_ = json.Unmarshal(data, &redacted)
+	redact(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+// redact replaces values of all fields tagged as secret.
+func redact(target reflect.Value) {
+	switch target.Kind() {
+	case reflect.Struct:
+		for i := 0; i < target.NumField(); i++ {
+			field := target.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				if target.Field(i).String() != "" {
+					target.Field(i).SetString(redactedValue)
+				}
+				continue
+			}
+			redact(target.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < target.Len(); i++ {
+			redact(target.Index(i))
+		}
+	}
+}
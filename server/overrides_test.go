@@ -0,0 +1,194 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigurationFormats(t *testing.T) {
+	expected := &Configuration{ServerPort: 8080, RootDirectory: "/data/o'brien"}
+	expected.Trash.Enabled = true
+	expected.Trash.ExpireDays = 7
+	expected.Auth.Keys = []ApiKeyConfiguration{{Principal: "backup", Key: "a # b"}}
+	expected.Transfer.MaxUploadSizes = map[string]int64{"/file/write": 1024}
+	tests := []struct {
+		fileName string
+		document string
+	}{
+		{"tarolas.json", `{"serverPort": 8080, "rootDirectory": "/data/o'brien", "trash": {"enabled": true, "expireDays": 7},
+			"auth": {"keys": [{"principal": "backup", "key": "a # b"}]}, "transfer": {"maxUploadSizes": {"/file/write": 1024}}}`},
+		{"tarolas.yaml", `
+serverPort: 8080
+rootDirectory: /data/o'brien  # comment
+trash:
+  enabled: true
+  expireDays: 7
+auth:
+  keys:
+    - principal: backup
+      key: 'a # b'
+transfer:
+  maxUploadSizes: {/file/write: 1024}
+`},
+		{"tarolas.toml", `
+serverPort = 8080
+rootDirectory = "/data/o'brien" # comment
+[trash]
+enabled = true
+expireDays = 7
+[[auth.keys]]
+principal = "backup"
+key = 'a # b'
+[transfer.maxUploadSizes]
+"/file/write" = 1024
+`},
+	}
+	for _, test := range tests {
+		actual, err := ParseConfiguration([]byte(test.document), test.fileName)
+		if err != nil {
+			t.Errorf("%s: %v", test.fileName, err)
+		} else if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %+v, actual %+v", test.fileName, expected, actual)
+		}
+	}
+}
+
+func TestParseConfigurationFailures(t *testing.T) {
+	tests := []struct {
+		fileName string
+		document string
+		expected string
+	}{
+		{"tarolas.yaml", "unknown: 1\n", "unknown: unknown option"},
+		{"tarolas.yaml", "trash:\n  expireDays: seven\n", "trash.expireDays: must be an integer"},
+		{"tarolas.toml", "serverPort = 'x'\n", "serverPort: must be an integer"},
+		{"tarolas.toml", "[trash]\nenabled = 1\n", "trash.enabled: must be true or false"},
+		{"tarolas.yml", "rootDirectory: [a]\n", "rootDirectory: must be a string"},
+	}
+	for _, test := range tests {
+		if _, err := ParseConfiguration([]byte(test.document), test.fileName); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error containing %q, actual %v", test.document, test.expected, err)
+		}
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	tests := []struct {
+		variable string
+		check    func(cfg *Configuration) bool
+	}{
+		{"TAROLAS_SERVER_PORT=9090", func(cfg *Configuration) bool { return cfg.ServerPort == 9090 }},
+		{"TAROLAS_ROOT_DIRECTORY=/srv/data", func(cfg *Configuration) bool { return cfg.RootDirectory == "/srv/data" }},
+		{"TAROLAS_TRASH_EXPIRE_DAYS=3", func(cfg *Configuration) bool { return cfg.Trash.ExpireDays == 3 }},
+		{"TAROLAS_TRASH_ENABLED=true", func(cfg *Configuration) bool { return cfg.Trash.Enabled }},
+		{`TAROLAS_AUTH_KEYS=[{"principal":"backup","key":"k=v"}]`, func(cfg *Configuration) bool {
+			return len(cfg.Auth.Keys) == 1 && cfg.Auth.Keys[0].Key == "k=v"
+		}},
+		{"TAROLAS_REPLICATION_API_KEY=secret", func(cfg *Configuration) bool { return cfg.Replication.ApiKey == "secret" }},
+		{"OTHER_SERVER_PORT=1", func(cfg *Configuration) bool { return cfg.ServerPort == 8080 }},
+		{"TAROLAS_UNKNOWN=1", func(cfg *Configuration) bool { return cfg.ServerPort == 8080 }},
+		{"TAROLAS_SERVER_PORT_NUMBER=1", func(cfg *Configuration) bool { return cfg.ServerPort == 8080 }},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{ServerPort: 8080}
+		if err := cfg.ApplyEnvironment([]string{test.variable}); err != nil {
+			t.Errorf("%s: %v", test.variable, err)
+		} else if !test.check(cfg) {
+			t.Errorf("%s: option not applied", test.variable)
+		}
+	}
+}
+
+func TestApplyEnvironmentFailures(t *testing.T) {
+	tests := []struct {
+		variable string
+		expected string
+	}{
+		{"TAROLAS_SERVER_PORT=port", "TAROLAS_SERVER_PORT: serverPort: must be an integer"},
+		{"TAROLAS_AUTH_KEYS=[", "TAROLAS_AUTH_KEYS: auth.keys:"},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{}
+		if err := cfg.ApplyEnvironment([]string{test.variable}); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected error containing %q, actual %v", test.variable, test.expected, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path  string
+		value string
+		check func(cfg *Configuration) bool
+	}{
+		{"serverPort", "9090", func(cfg *Configuration) bool { return cfg.ServerPort == 9090 }},
+		{"trash.expireDays", "3", func(cfg *Configuration) bool { return cfg.Trash.ExpireDays == 3 }},
+		{"Trash.Enabled", "true", func(cfg *Configuration) bool { return cfg.Trash.Enabled }},
+		{"auth.keys.0.key", "changed", func(cfg *Configuration) bool { return cfg.Auth.Keys[0].Key == "changed" }},
+		{"auth.keys", `[{"principal":"a","key":"b"},{"principal":"c","key":"d"}]`, func(cfg *Configuration) bool { return len(cfg.Auth.Keys) == 2 }},
+		{"transfer.maxUploadSizes./file/write", "1024", func(cfg *Configuration) bool {
+			return cfg.Transfer.MaxUploadSizes["/file/write"] == 1024
+		}},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{}
+		cfg.Auth.Keys = []ApiKeyConfiguration{{Principal: "backup", Key: "key"}}
+		if err := cfg.Set(test.path, test.value); err != nil {
+			t.Errorf("%s: %v", test.path, err)
+		} else if !test.check(cfg) {
+			t.Errorf("%s: option not set", test.path)
+		}
+	}
+}
+
+func TestSetFailures(t *testing.T) {
+	tests := []struct {
+		path     string
+		value    string
+		expected string
+	}{
+		{"unknown", "1", "unknown: unknown option"},
+		{"trash.unknown", "1", "trash.unknown: unknown option"},
+		{"serverPort.value", "1", "serverPort.value: unknown option"},
+		{"auth.keys.1.key", "x", "invalid list index"},
+		{"auth.keys.x", "x", "invalid list index"},
+		{"serverPort", "99999999999999999999", "must be an integer"},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{}
+		cfg.Auth.Keys = []ApiKeyConfiguration{{Principal: "backup", Key: "key"}}
+		if err := cfg.Set(test.path, test.value); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected error containing %q, actual %v", test.path, test.expected, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := &Configuration{ServerPort: 8080, RootDirectory: "/data"}
+	cfg.Auth.Keys = []ApiKeyConfiguration{{Principal: "backup", Key: "key"}, {Principal: "empty"}}
+	cfg.Webhooks.Subscriptions = []WebhookSubscription{{Url: "http://localhost", Secret: "secret"}}
+	cfg.Replication.ApiKey = "replica"
+	redacted := cfg.Redacted()
+	tests := []struct {
+		option   string
+		actual   string
+		expected string
+	}{
+		{"auth.keys[0].key", redacted.Auth.Keys[0].Key, redactedValue},
+		{"auth.keys[0].principal", redacted.Auth.Keys[0].Principal, "backup"},
+		{"auth.keys[1].key", redacted.Auth.Keys[1].Key, ""},
+		{"webhooks.subscriptions[0].secret", redacted.Webhooks.Subscriptions[0].Secret, redactedValue},
+		{"webhooks.subscriptions[0].url", redacted.Webhooks.Subscriptions[0].Url, "http://localhost"},
+		{"replication.apiKey", redacted.Replication.ApiKey, redactedValue},
+		{"rootDirectory", redacted.RootDirectory, "/data"},
+	}
+	for _, test := range tests {
+		if test.actual != test.expected {
+			t.Errorf("%s: expected %q, actual %q", test.option, test.expected, test.actual)
+		}
+	}
+	if cfg.Auth.Keys[0].Key != "key" || cfg.Webhooks.Subscriptions[0].Secret != "secret" || cfg.Replication.ApiKey != "replica" {
+		t.Error("secrets of the original configuration were changed")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// principalKey is the key of the authenticated principal stored in request context.
+type principalKey struct{}
+
+// authenticate resolves the principal from credentials sent with the request. API keys are accepted
+// in 'Authorization: Bearer <key>' or 'X-Api-Key' header, when no key is sent, the principal
+// is mapped from verified client certificate. Returns empty string when no valid credentials were sent.
+func authenticate(cfg *Configuration, req *http.Request) string {
+	key := req.Header.Get("X-Api-Key")
+	if authorization := req.Header.Get("Authorization"); key == "" && len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		key = strings.TrimSpace(authorization[7:])
+	}
+	if key == "" {
+		return certificatePrincipal(cfg, req)
+	}
+	for _, apiKey := range cfg.Auth.Keys {
+		if apiKey.Key != "" && subtle.ConstantTimeCompare([]byte(apiKey.Key), []byte(key)) == 1 {
+			return apiKey.Principal
+		}
+	}
+	return ""
+}
+
+// withPrincipal returns the request carrying the authenticated principal.
+func withPrincipal(req *http.Request, principal string) *http.Request {
+	if principal == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+}
+
+// requestPrincipal returns the authenticated principal of the request, or empty string.
+func requestPrincipal(req *http.Request) string {
+	principal, _ := req.Context().Value(principalKey{}).(string)
+	return principal
+}
+
+// requestActor returns the identification of the client that sent the request,
+// recorded with changes made while processing the request. Authenticated principal
+// is preferred over the client address.
+func requestActor(req *http.Request) string {
+	if principal := requestPrincipal(req); principal != "" {
+		return principal
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// commit is the revision the server was built from, set when building:
+// go build -ldflags "-X github.com/wisbery/tarolas/server.commit=$(git rev-parse --short HEAD)"
+var commit string
+
+// defaultExemptRoutes lists routes processed without authentication, when not configured.
+var defaultExemptRoutes = []string{routeHealth, routeReady, routeVersion}
+
+// Health describes the state of the server process.
+type Health struct {
+	Status string `json:"status"  api:"Status of the server, 'ok' when the server process is alive."`
+}
+
+// HealthDto is the implementation of DTO for health and readiness of the server.
+type HealthDto struct {
+	Data *Health `json:"data"  api:"Health of the server."`
+}
+
+// Version describes the build of the server.
+type Version struct {
+	Version   string `json:"version"           api:"Version of the file server."`
+	Commit    string `json:"commit,omitempty"  api:"Revision the server was built from, when known."`
+	GoVersion string `json:"goVersion"         api:"Version of Go the server was built with."`
+}
+
+// VersionDto is the implementation of DTO for server version.
+type VersionDto struct {
+	Data *Version `json:"data"  api:"Version of the server."`
+}
+
+// exempt returns true when requests sent to the route do not require authentication.
+func (c *AuthConfiguration) exempt(route string) bool {
+	routes := c.Exempt
+	if routes == nil {
+		routes = defaultExemptRoutes
+	}
+	for _, exempt := range routes {
+		if exempt == route {
+			return true
+		}
+	}
+	return false
+}
+
+// readiness checks if the server can process requests: the root directory is reachable and
+// readable, files can be written, and the server is not shutting down. Returns the list of problems.
+// Nothing is written into the served content, writing is checked in the state directory, which
+// is on the same file system as the root directory, and is not watched for changes.
+func readiness(cfg *Configuration) []string {
+	problems := make([]string, 0)
+	if cfg.transfers.draining.Load() {
+		problems = append(problems, "server is shutting down")
+	}
+	if root, err := os.Open(cfg.RootDirectory); err != nil {
+		problems = append(problems, "root directory not reachable: "+err.Error())
+	} else {
+		if _, err = root.Readdirnames(1); err != nil && err != io.EOF {
+			problems = append(problems, "root directory not readable: "+err.Error())
+		}
+		_ = root.Close()
+	}
+	if err := probeWriting(cfg); err != nil {
+		problems = append(problems, "files can not be written: "+err.Error())
+	}
+	return problems
+}
+
+// probeWriting writes, reads and removes uniquely named temporary file in the state directory.
+// The state directory is not created, so the missing root directory is not created again.
+func probeWriting(cfg *Configuration) error {
+	if _, err := os.Stat(cfg.stateDirectory()); err != nil {
+		return err
+	}
+	probe, err := createTemporaryFile(cfg, "ready-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(probe.Name())
+	}()
+	if _, err = probe.WriteString(probeFileValue); err == nil {
+		err = probe.Close()
+	} else {
+		_ = probe.Close()
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(probe.Name())
+	if err == nil && string(data) != probeFileValue {
+		err = errors.New("probe file content differs")
+	}
+	return err
+}
+
+// buildVersion returns the version of the server, the commit is read from build information
+// when it was not set when building.
+func buildVersion() *Version {
+	result := &Version{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok && result.Commit == "" {
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				result.Commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && result.Commit != "" {
+			result.Commit += "-dirty"
+		}
+	}
+	return result
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errQuotaWriteExceeded = errors.New("quota exceeded")
+
+const (
+	quotasDirectory   = "quotas"      // Name of the directory for quota data, created in state directory.
+	quotaOwnersFile   = "owners.json" // Name of the file with owners of files.
+	quotaSaveInterval = time.Second   // Interval between saves of changed owners.
+)
+
+// QuotaUsage describes single quota and its current usage.
+type QuotaUsage struct {
+	Principal string `json:"principal,omitempty"  api:"Principal whose files are limited."`
+	Directory string `json:"directory,omitempty"  api:"Directory whose content is limited."`
+	MaxBytes  int64  `json:"maxBytes,omitempty"   api:"Maximum total size of files in bytes."`
+	MaxFiles  int64  `json:"maxFiles,omitempty"   api:"Maximum number of files."`
+	Bytes     int64  `json:"bytes"                api:"Current total size of files in bytes."`
+	Files     int64  `json:"files"                api:"Current number of files."`
+}
+
+// QuotaUsageDto is the implementation of DTO for quota usage.
+type QuotaUsageDto struct {
+	Data []*QuotaUsage `json:"data"  api:"Configured quotas with current usage."`
+}
+
+// quotaFile stores the size and the owner of single file.
+type quotaFile struct {
+	size  int64
+	owner string
+}
+
+// quotaChange describes the final size of the file about to be written.
+type quotaChange struct {
+	name string
+	size int64
+}
+
+// quotaReservation reserves the space for files being written, until the written files
+// are added to the tracked usage, so concurrent writes can not exceed any quota together.
+type quotaReservation struct {
+	tracker   *quotaTracker
+	owner     string        // Owner of created files.
+	changes   []quotaChange // Final sizes of written files.
+	base      int64         // Size of the streamed file before writing.
+	written   int64         // Number of bytes of the streamed file written so far.
+	appending bool          // Flag indicating if the streamed content is appended.
+}
+
+// quotaTracker tracks the usage of all configured quotas. Sizes of all files are read
+// from disk when the server starts, and then updated from change events. The owner
+// of a file is the principal who created it, owners are kept in state directory.
+type quotaTracker struct {
+	cfg          *Configuration
+	ownersPath   string
+	mutex        sync.Mutex
+	files        map[string]quotaFile           // Sizes and owners of all files by name.
+	quotas       []QuotaConfiguration           // Configured quotas, replaced when the configuration is reloaded.
+	usage        []QuotaUsage                   // Usage of quotas, in the order of configuration.
+	reservations map[*quotaReservation]struct{} // Space reserved for files being written.
+	dirty        bool                           // Flag indicating if owners changed since the last save.
+}
+
+// startQuotas reads sizes of all files, registers the tracker as event listener and saves changed
+// owners in background, until the returned stop function is called.
+func startQuotas(cfg *Configuration) (*quotaTracker, func(), error) {
+	directory := filepath.Join(cfg.stateDirectory(), quotasDirectory)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, nil, err
+	}
+	t := &quotaTracker{
+		cfg:          cfg,
+		ownersPath:   filepath.Join(directory, quotaOwnersFile),
+		files:        make(map[string]quotaFile),
+		reservations: make(map[*quotaReservation]struct{}),
+	}
+	owners := make(map[string]string)
+	if data, err := os.ReadFile(t.ownersPath); err == nil {
+		if err = json.Unmarshal(data, &owners); err != nil {
+			return nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	t.mutex.Lock()
+	t.configure(cfg.Quotas)
+	t.scan(cfg.RootDirectory, owners)
+	t.mutex.Unlock()
+	cfg.events.listen(t.update)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(quotaSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.save()
+			case <-done:
+				t.save()
+				return
+			}
+		}
+	}()
+	return t, func() { close(done) }, nil
+}
+
+// configure replaces configured quotas and computes their usage from tracked files.
+// Must be called with the mutex held.
+func (t *quotaTracker) configure(quotas []QuotaConfiguration) {
+	t.quotas = quotas
+	t.usage = make([]QuotaUsage, len(quotas))
+	for i, quota := range quotas {
+		t.usage[i] = QuotaUsage{Principal: quota.Principal, Directory: quota.Directory, MaxBytes: quota.MaxBytes, MaxFiles: quota.MaxFiles}
+		for name, file := range t.files {
+			if quota.applies(name, file.owner) {
+				t.usage[i].Bytes += file.size
+				t.usage[i].Files++
+			}
+		}
+	}
+}
+
+// reconfigure replaces configured quotas, when the configuration is reloaded.
+func (t *quotaTracker) reconfigure(quotas []QuotaConfiguration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.configure(quotas)
+}
+
+// applies returns true when the quota limits the file with specified name and owner.
+func (q *QuotaConfiguration) applies(name string, owner string) bool {
+	return (q.Directory == "" || inDirectory(name, q.Directory)) && (q.Principal == "" || q.Principal == owner)
+}
+
+// add adds the file to the tracker and to the usage of all quotas limiting it.
+// Must be called with the mutex held.
+func (t *quotaTracker) add(name string, file quotaFile) {
+	if previous, ok := t.files[name]; ok {
+		t.remove(name, previous)
+	}
+	t.files[name] = file
+	t.dirty = t.dirty || file.owner != ""
+	for i := range t.quotas {
+		if t.quotas[i].applies(name, file.owner) {
+			t.usage[i].Bytes += file.size
+			t.usage[i].Files++
+		}
+	}
+}
+
+// remove removes the file from the tracker and from the usage of all quotas limiting it.
+// Must be called with the mutex held.
+func (t *quotaTracker) remove(name string, file quotaFile) {
+	delete(t.files, name)
+	t.dirty = t.dirty || file.owner != ""
+	for i := range t.quotas {
+		if t.quotas[i].applies(name, file.owner) {
+			t.usage[i].Bytes -= file.size
+			t.usage[i].Files--
+		}
+	}
+}
+
+// scan adds all files in the directory tree, owners of files not known yet
+// are taken from the map. Must be called with the mutex held.
+func (t *quotaTracker) scan(fullName string, owners map[string]string) {
+	_ = filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if t.cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			name := relativeName(t.cfg, filePath)
+			owner := owners[name]
+			if previous, ok := t.files[name]; ok {
+				owner = previous.owner
+			}
+			t.add(name, quotaFile{size: info.Size(), owner: owner})
+		}
+		return nil
+	})
+}
+
+// update applies the change event to the tracked usage. The actor of the event creating
+// a file becomes its owner.
+func (t *quotaTracker) update(event *Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch event.Type {
+	case EventCreate, EventWrite, EventAppend:
+		owner := ""
+		if event.Source == eventSourceApi {
+			owner = event.Actor
+		}
+		t.refresh(event.Name, owner)
+	case EventDelete:
+		for name, file := range t.matching(event.Name) {
+			t.remove(name, file)
+		}
+	case EventMove:
+		for name, file := range t.matching(event.Name) {
+			t.remove(name, file)
+			t.add(event.Target+strings.TrimPrefix(name, path.Clean(event.Name)), file)
+		}
+	}
+}
+
+// refresh reads the size of the file, or sizes of all files in the directory, with specified name.
+// The owner is assigned to files which have no owner yet. Must be called with the mutex held.
+func (t *quotaTracker) refresh(name string, owner string) {
+	fullName := prepareAbsolutePath(t.cfg, name)
+	if info, err := os.Stat(fullName); err == nil && info.Mode().IsRegular() {
+		if previous, ok := t.files[name]; ok {
+			owner = previous.owner
+		}
+		t.add(name, quotaFile{size: info.Size(), owner: owner})
+	} else if err == nil && info.IsDir() {
+		t.scan(fullName, map[string]string{})
+		t.assignOwner(name, owner)
+	}
+}
+
+// assignOwner assigns the owner to all files in the directory, which have no owner yet.
+// Must be called with the mutex held.
+func (t *quotaTracker) assignOwner(directory string, owner string) {
+	if owner == "" {
+		return
+	}
+	for name, file := range t.matching(directory) {
+		if file.owner == "" {
+			t.add(name, quotaFile{size: file.size, owner: owner})
+		}
+	}
+}
+
+// matching returns the file with specified name, or all files in the directory with specified name.
+// Must be called with the mutex held.
+func (t *quotaTracker) matching(name string) map[string]quotaFile {
+	name = path.Clean(name)
+	result := make(map[string]quotaFile)
+	if file, ok := t.files[name]; ok {
+		result[name] = file
+		return result
+	}
+	for fileName, file := range t.files {
+		if inDirectory(fileName, name) {
+			result[fileName] = file
+		}
+	}
+	return result
+}
+
+// save stores owners of files, when changed since the last save.
+func (t *quotaTracker) save() {
+	t.mutex.Lock()
+	if !t.dirty {
+		t.mutex.Unlock()
+		return
+	}
+	owners := make(map[string]string)
+	for name, file := range t.files {
+		if file.owner != "" {
+			owners[name] = file.owner
+		}
+	}
+	t.dirty = false
+	t.mutex.Unlock()
+	data, err := json.Marshal(owners)
+	if err == nil {
+		temporary := t.ownersPath + ".tmp"
+		if err = os.WriteFile(temporary, data, 0644); err == nil {
+			err = os.Rename(temporary, t.ownersPath)
+		}
+	}
+	if err != nil {
+		logError(err)
+	}
+}
+
+// reserve reserves the space for writing files with specified final sizes, created files
+// are owned by specified owner. Returns error when writing the files, together with all files
+// being written concurrently, would exceed any quota. The reservation must be released
+// when the files are written, returned reservation is nil when no quotas are tracked.
+func (t *quotaTracker) reserve(changes []quotaChange, owner string) (*quotaReservation, *ErrorDto) {
+	if t == nil {
+		return nil, nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r := &quotaReservation{tracker: t, owner: owner, changes: changes}
+	if errorDto := t.exceeded(r); errorDto != nil {
+		return nil, errorDto
+	}
+	t.reservations[r] = struct{}{}
+	return r, nil
+}
+
+// reserveStream reserves the space for the file with specified name, whose content is streamed.
+// The reservation grows while the content is written. When not appending, the file is overwritten
+// from its beginning, so its final size is never less than its current size.
+func (t *quotaTracker) reserveStream(name string, owner string, appending bool) (*quotaReservation, *ErrorDto) {
+	if t == nil {
+		return nil, nil
+	}
+	var base int64
+	if info, err := os.Stat(prepareAbsolutePath(t.cfg, name)); err == nil {
+		base = info.Size()
+	}
+	r, errorDto := t.reserve([]quotaChange{{name: name, size: base}}, owner)
+	if r != nil {
+		r.base = base
+		r.appending = appending
+	}
+	return r, errorDto
+}
+
+// exceeded returns error when the files of the reservation would exceed any quota,
+// together with files of all other reservations. Must be called with the mutex held.
+func (t *quotaTracker) exceeded(r *quotaReservation) *ErrorDto {
+	for i := range t.quotas {
+		quota := &t.quotas[i]
+		bytes, files := t.delta(quota, r)
+		if bytes <= 0 && files <= 0 {
+			continue
+		}
+		usage := t.usage[i]
+		for other := range t.reservations {
+			if other != r {
+				otherBytes, otherFiles := t.delta(quota, other)
+				usage.Bytes += otherBytes
+				usage.Files += otherFiles
+			}
+		}
+		if quota.MaxFiles > 0 && files > 0 && usage.Files+files > quota.MaxFiles {
+			return quotaExceeded(quota, "files")
+		}
+		if quota.MaxBytes > 0 && bytes > 0 && usage.Bytes+bytes > quota.MaxBytes {
+			return quotaExceeded(quota, "bytes")
+		}
+	}
+	return nil
+}
+
+// delta returns the change of the usage of the quota after files of the reservation are written.
+// Must be called with the mutex held.
+func (t *quotaTracker) delta(quota *QuotaConfiguration, r *quotaReservation) (int64, int64) {
+	var bytes, files int64
+	for _, change := range r.changes {
+		name := path.Clean(change.name)
+		file, exists := t.files[name]
+		if !exists {
+			file.owner = r.owner
+		}
+		if !quota.applies(name, file.owner) {
+			continue
+		}
+		if exists {
+			bytes += change.size - file.size
+		} else {
+			bytes += change.size
+			files++
+		}
+	}
+	return bytes, files
+}
+
+// grow extends the reservation of the streamed file by specified number of bytes.
+// Returns false when the grown file would exceed any quota, the reservation is then kept unchanged.
+func (r *quotaReservation) grow(size int64) bool {
+	if r == nil {
+		return true
+	}
+	r.tracker.mutex.Lock()
+	defer r.tracker.mutex.Unlock()
+	previous := r.changes[0].size
+	if r.appending {
+		r.changes[0].size = r.base + r.written + size
+	} else {
+		r.changes[0].size = max(r.base, r.written+size)
+	}
+	if r.tracker.exceeded(r) != nil {
+		r.changes[0].size = previous
+		return false
+	}
+	r.written += size
+	return true
+}
+
+// commit adds the written files to the tracked usage and releases the reservation,
+// so the space stays accounted for before the change events are processed.
+func (r *quotaReservation) commit() {
+	if r == nil {
+		return
+	}
+	r.tracker.mutex.Lock()
+	defer r.tracker.mutex.Unlock()
+	if _, ok := r.tracker.reservations[r]; !ok {
+		return
+	}
+	delete(r.tracker.reservations, r)
+	for _, change := range r.changes {
+		r.tracker.refresh(path.Clean(change.name), r.owner)
+	}
+}
+
+// release releases the reservation of files which were not written.
+// Released or committed reservation is not changed.
+func (r *quotaReservation) release() {
+	if r == nil {
+		return
+	}
+	r.tracker.mutex.Lock()
+	defer r.tracker.mutex.Unlock()
+	delete(r.tracker.reservations, r)
+}
+
+// treeChanges returns final sizes of all files in the file or directory with specified full name,
+// when copied or moved to specified name.
+func treeChanges(fullName string, name string) ([]quotaChange, error) {
+	changes := make([]quotaChange, 0)
+	err := filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			relative, err := filepath.Rel(fullName, filePath)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, quotaChange{name: path.Join(name, filepath.ToSlash(relative)), size: info.Size()})
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// quotaExceeded creates error reporting which quota and which limit was exceeded.
+func quotaExceeded(quota *QuotaConfiguration, limit string) *ErrorDto {
+	detail := make([]string, 0, 3)
+	if quota.Principal != "" {
+		detail = append(detail, "principal "+quota.Principal)
+	}
+	if quota.Directory != "" {
+		detail = append(detail, "directory "+quota.Directory)
+	}
+	if limit == "files" {
+		detail = append(detail, "max files "+strconv.FormatInt(quota.MaxFiles, 10))
+	} else {
+		detail = append(detail, "max bytes "+strconv.FormatInt(quota.MaxBytes, 10))
+	}
+	return errorDto(errQuotaExceeded, strings.Join(detail, ", "))
+}
+
+// quotaUsage returns configured quotas with current usage, optionally only quotas of specified principal.
+func quotaUsage(cfg *Configuration, principal string) []*QuotaUsage {
+	result := make([]*QuotaUsage, 0)
+	if cfg.quotas == nil {
+		return result
+	}
+	cfg.quotas.mutex.Lock()
+	defer cfg.quotas.mutex.Unlock()
+	for i := range cfg.quotas.usage {
+		usage := cfg.quotas.usage[i]
+		if principal == "" || usage.Principal == principal {
+			result = append(result, &usage)
+		}
+	}
+	return result
+}
+
+// quotaWriter writes the content while the reservation of the streamed file can grow.
+type quotaWriter struct {
+	writer      io.Writer
+	reservation *quotaReservation
+	exceeded    bool
+}
+
+func (w *quotaWriter) Write(data []byte) (int, error) {
+	if !w.reservation.grow(int64(len(data))) {
+		w.exceeded = true
+		return 0, errQuotaWriteExceeded
+	}
+	return w.writer.Write(data)
+}
+
+// copyWithinQuota copies content into the file, growing the reservation before every written part.
+// Returns true when the content would exceed any quota, the content written so far stays in the file.
+func copyWithinQuota(file io.Writer, content io.Reader, reservation *quotaReservation) (bool, error) {
+	if reservation == nil {
+		_, err := io.Copy(file, content)
+		return false, err
+	}
+	writer := &quotaWriter{writer: file, reservation: reservation}
+	_, err := io.Copy(writer, content)
+	if writer.exceeded {
+		return true, nil
+	}
+	return false, err
+}
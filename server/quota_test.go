@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startTestQuotas starts tracking the quota of 10 bytes in '/limited' directory.
+func startTestQuotas(t *testing.T, cfg *Configuration) {
+	t.Helper()
+	cfg.Quotas = []QuotaConfiguration{{Directory: "/limited", MaxBytes: 10}}
+	if err := os.MkdirAll(filepath.Join(cfg.RootDirectory, "limited"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.events = newEventHub(0, 0)
+	tracker, stop, err := startQuotas(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.quotas = tracker
+	t.Cleanup(func() {
+		cfg.events.close()
+		stop()
+	})
+}
+
+// quotaBytes returns the number of bytes counted by the quota, without waiting for change events.
+func quotaBytes(cfg *Configuration) int64 {
+	return quotaUsage(cfg, "")[0].Bytes
+}
+
+// batchWrite writes the file as batch operation, so the usage is tracked without change events.
+func batchWrite(t *testing.T, cfg *Configuration, name string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(prepareAbsolutePath(cfg, name)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	transaction := batchTransaction{cfg: cfg}
+	if _, errorDto := transaction.write(name, base64.StdEncoding.EncodeToString([]byte(content))); errorDto != nil {
+		t.Fatalf("%s: %s %s", name, errorDto.Title, errorDto.Detail)
+	}
+}
+
+// expectQuotaExceeded checks that the operation was rejected because of the quota.
+func expectQuotaExceeded(t *testing.T, operation string, errorDto *ErrorDto) {
+	t.Helper()
+	if errorDto == nil || errorDto.Code != errQuotaExceeded.Code {
+		t.Errorf("%s: expected quota exceeded, actual %v", operation, errorDto)
+	}
+}
+
+func TestQuotaOfCopiedFiles(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	startTestQuotas(t, cfg)
+	batchWrite(t, cfg, "/source/a.txt", "abcdef")
+	batchWrite(t, cfg, "/source/b.txt", "ghijkl")
+	transaction := batchTransaction{cfg: cfg}
+	_, errorDto := transaction.moveOrCopy("/source", "/limited/source", false)
+	expectQuotaExceeded(t, "copy directory", errorDto)
+	if _, err := os.Stat(filepath.Join(cfg.RootDirectory, "limited", "source")); !os.IsNotExist(err) {
+		t.Error("directory copied in spite of exceeded quota")
+	}
+	if _, errorDto = transaction.moveOrCopy("/source/a.txt", "/limited/a.txt", false); errorDto != nil {
+		t.Fatal(errorDto)
+	}
+	if bytes := quotaBytes(cfg); bytes != 6 {
+		t.Errorf("expected usage 6 bytes, actual %d", bytes)
+	}
+	_, errorDto = transaction.moveOrCopy("/source/b.txt", "/limited/b.txt", false)
+	expectQuotaExceeded(t, "copy file", errorDto)
+}
+
+func TestQuotaOfRestoredVersion(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Versioning.Directories = []string{"/limited"}
+	startTestQuotas(t, cfg)
+	batchWrite(t, cfg, "/limited/v.txt", "abcdefgh")
+	// deleted file is preserved as a version
+	if _, errorDto := fileDelete(cfg, "/limited/v.txt"); errorDto != nil {
+		t.Fatal(errorDto)
+	}
+	cfg.quotas.update(&Event{Type: EventDelete, Name: "/limited/v.txt"})
+	batchWrite(t, cfg, "/limited/w.txt", "abcdefgh")
+	versions, err := readVersions(cfg, "/limited/v.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected single version, actual %v (%v)", versions, err)
+	}
+	_, errorDto := fileRestore(cfg, "/limited/v.txt", versions[0].Version, "")
+	expectQuotaExceeded(t, "restore version", errorDto)
+	if _, err = os.Stat(filepath.Join(cfg.RootDirectory, "limited", "v.txt")); !os.IsNotExist(err) {
+		t.Error("version restored in spite of exceeded quota")
+	}
+}
+
+func TestQuotaOfRestoredTrashItem(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Trash.Enabled = true
+	startTestQuotas(t, cfg)
+	batchWrite(t, cfg, "/limited/dir/a.txt", "abcdefgh")
+	if _, errorDto := deleteDirectoryToTrash(cfg, "/limited/dir"); errorDto != nil {
+		t.Fatal(errorDto)
+	}
+	cfg.quotas.update(&Event{Type: EventDelete, Name: "/limited/dir", Directory: true})
+	batchWrite(t, cfg, "/limited/b.txt", "abcdefgh")
+	items, errorDto := trashList(cfg)
+	if errorDto != nil || len(items) != 1 {
+		t.Fatalf("expected single trash item, actual %v (%v)", items, errorDto)
+	}
+	_, errorDto = trashRestore(cfg, items[0].Id, "", "")
+	expectQuotaExceeded(t, "restore from trash", errorDto)
+	if _, err := os.Stat(filepath.Join(cfg.RootDirectory, "limited", "dir")); !os.IsNotExist(err) {
+		t.Error("directory restored in spite of exceeded quota")
+	}
+}
+
+func TestQuotaOfConcurrentWrites(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	startTestQuotas(t, cfg)
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	written := 0
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			name := "/limited/" + string(rune('a'+i)) + ".txt"
+			req := httptest.NewRequest(HttpPOST, routeFileWrite, strings.NewReader(base64.StdEncoding.EncodeToString([]byte("abcdef"))))
+			req.RemoteAddr = "" // files without owner
+			if _, errorDto := fileWrite(cfg, req, name); errorDto == nil {
+				mutex.Lock()
+				written++
+				mutex.Unlock()
+			} else {
+				expectQuotaExceeded(t, name, errorDto)
+			}
+		}(i)
+	}
+	wait.Wait()
+	if written != 1 || quotaBytes(cfg) != 6 {
+		t.Errorf("expected single written file, actual %d files with %d bytes", written, quotaBytes(cfg))
+	}
+}
+
+func TestQuotaReservation(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	startTestQuotas(t, cfg)
+	first, errorDto := cfg.quotas.reserveStream("/limited/a.txt", "", false)
+	if errorDto != nil {
+		t.Fatal(errorDto)
+	}
+	second, errorDto := cfg.quotas.reserveStream("/limited/b.txt", "", true)
+	if errorDto != nil {
+		t.Fatal(errorDto)
+	}
+	if !first.grow(6) {
+		t.Fatal("first reservation not grown")
+	}
+	if second.grow(6) {
+		t.Error("second reservation grown over reserved space")
+	}
+	if !second.grow(4) {
+		t.Error("second reservation not grown within free space")
+	}
+	// nothing was written, the reserved space is released
+	first.release()
+	if !second.grow(6) || second.grow(1) {
+		t.Error("released space not available")
+	}
+	_, errorDto = cfg.quotas.reserve([]quotaChange{{name: "/limited/c.txt", size: 1}}, "")
+	expectQuotaExceeded(t, "reserve", errorDto)
+	second.release()
+	if quotaBytes(cfg) != 0 {
+		t.Errorf("expected no usage, actual %d bytes", quotaBytes(cfg))
+	}
+}
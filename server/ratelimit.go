@@ -0,0 +1,156 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxRateLimitClients = 10000 // Number of clients tracked by single rule above which the idle ones are removed.
+
+// RateLimitState describes single rate limit rule and its current state.
+type RateLimitState struct {
+	Route     string  `json:"route,omitempty"  api:"Limited route, all routes without own rule when empty."`
+	Rate      float64 `json:"rate"             api:"Number of requests per second allowed for single client."`
+	Burst     int     `json:"burst"            api:"Number of requests single client may send at once."`
+	Clients   int     `json:"clients"          api:"Number of currently tracked clients."`
+	Exhausted int     `json:"exhausted"        api:"Number of clients currently being rejected."`
+	Allowed   int64   `json:"allowed"          api:"Number of allowed requests since the server started."`
+	Rejected  int64   `json:"rejected"         api:"Number of rejected requests since the server started."`
+}
+
+// RateLimitStateDto is the implementation of DTO for rate limit state.
+type RateLimitStateDto struct {
+	Data []*RateLimitState `json:"data"  api:"Configured rate limits with current state."`
+}
+
+// tokenBucket holds requests available to single client, one token is taken by each request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitRule holds buckets of all clients limited by single configured rule.
+type rateLimitRule struct {
+	route    string
+	rate     float64
+	burst    float64
+	buckets  map[string]*tokenBucket // Buckets by principal or client address.
+	allowed  int64
+	rejected int64
+}
+
+// refill adds tokens accumulated since the last request of the client.
+func (r *rateLimitRule) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens = min(r.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate)
+	bucket.last = now
+}
+
+// rateLimiter limits the number of requests per second sent by single client (principal
+// or client address, when not authenticated) to single route.
+type rateLimiter struct {
+	mutex sync.Mutex
+	rules map[string]*rateLimitRule // Rules by route, the rule for all other routes has empty route.
+	order []*rateLimitRule          // Rules in the order of configuration.
+}
+
+// newRateLimiter creates the rate limiter, returns nil when no limits are configured.
+func newRateLimiter(cfg *Configuration) *rateLimiter {
+	if len(cfg.RateLimits) == 0 {
+		return nil
+	}
+	limiter := &rateLimiter{rules: make(map[string]*rateLimitRule)}
+	for _, limit := range cfg.RateLimits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		burst := limit.Burst
+		if burst <= 0 {
+			burst = max(1, int(math.Ceil(limit.Rate)))
+		}
+		rule := &rateLimitRule{route: limit.Route, rate: limit.Rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+		limiter.rules[limit.Route] = rule
+		limiter.order = append(limiter.order, rule)
+	}
+	return limiter
+}
+
+// allow takes a token for the request of the client to the route. Returns false
+// together with the time after which the request may be retried, when rejected.
+func (l *rateLimiter) allow(route string, client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	rule, ok := l.rules[route]
+	if !ok {
+		if rule, ok = l.rules[""]; !ok {
+			return true, 0
+		}
+	}
+	now := time.Now()
+	bucket, ok := rule.buckets[client]
+	if !ok {
+		if len(rule.buckets) >= maxRateLimitClients {
+			// refilled buckets are idle and can be created again when needed
+			for name, idle := range rule.buckets {
+				if rule.refill(idle, now); idle.tokens >= rule.burst {
+					delete(rule.buckets, name)
+				}
+			}
+		}
+		bucket = &tokenBucket{tokens: rule.burst, last: now}
+		rule.buckets[client] = bucket
+	}
+	rule.refill(bucket, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		rule.allowed++
+		return true, 0
+	}
+	rule.rejected++
+	return false, time.Duration((1 - bucket.tokens) / rule.rate * float64(time.Second))
+}
+
+// rateLimitState returns configured rate limits with their current state.
+func rateLimitState(cfg *Configuration) []*RateLimitState {
+	result := make([]*RateLimitState, 0)
+	if cfg.rateLimits == nil {
+		return result
+	}
+	cfg.rateLimits.mutex.Lock()
+	defer cfg.rateLimits.mutex.Unlock()
+	now := time.Now()
+	for _, rule := range cfg.rateLimits.order {
+		state := &RateLimitState{
+			Route:    rule.route,
+			Rate:     rule.rate,
+			Burst:    int(rule.burst),
+			Clients:  len(rule.buckets),
+			Allowed:  rule.allowed,
+			Rejected: rule.rejected,
+		}
+		for _, bucket := range rule.buckets {
+			if rule.refill(bucket, now); bucket.tokens < 1 {
+				state.Exhausted++
+			}
+		}
+		result = append(result, state)
+	}
+	return result
+}
+
+// limitRate rejects the request with status 429, when the client exceeded the rate limit
+// of the route. Returns false when the request was rejected.
+func limitRate(cfg *Configuration, w http.ResponseWriter, req *http.Request) bool {
+	if ok, retry := cfg.rateLimits.allow(routeOf(cfg, req), requestActor(req)); !ok {
+		seconds := int(math.Ceil(retry.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, seconds)))
+		writeResultError(w, errorDto(errTooManyRequests, "retry after "+strconv.Itoa(max(1, seconds))+" seconds"))
+		return false
+	}
+	return true
+}
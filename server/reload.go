@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// reloadableOptions lists top level options applied when the configuration is reloaded,
+// changes of other options require restart of the server.
+var reloadableOptions = map[string]bool{"auth": true, "quotas": true, "rateLimits": true, "transfer": true, "cors": true}
+
+// ReloadResult describes options changed by configuration reload.
+type ReloadResult struct {
+	Applied         []string `json:"applied"          api:"Changed options applied without restart."`
+	RestartRequired []string `json:"restartRequired"  api:"Changed options applied only after restart of the server."`
+}
+
+// ReloadResultDto is the implementation of DTO for configuration reload result.
+type ReloadResultDto struct {
+	Data *ReloadResult `json:"data"  api:"Configuration reload result."`
+}
+
+// liveConfiguration holds the configuration currently used for processing requests,
+// shared by all copies of the configuration. Each request uses the configuration
+// current when it was received, so the configuration may be replaced at any time.
+type liveConfiguration struct {
+	mutex   sync.Mutex // Serializes reloads.
+	current atomic.Pointer[Configuration]
+	loader  func() (*Configuration, error)
+}
+
+// SetLoader sets the function reading the configuration again, when the configuration is reloaded.
+func (c *Configuration) SetLoader(loader func() (*Configuration, error)) {
+	if c.live == nil {
+		c.live = &liveConfiguration{}
+	}
+	c.live.loader = loader
+}
+
+// current returns the configuration currently used for processing requests.
+func (c *Configuration) current() *Configuration {
+	if c.live != nil {
+		if current := c.live.current.Load(); current != nil {
+			return current
+		}
+	}
+	return c
+}
+
+// ReloadConfiguration reads the configuration again, and when it is valid, applies changed options
+// that do not require restart of the server. Requests being processed are not affected.
+// When the configuration is not valid, it is rejected and the current configuration is kept.
+func ReloadConfiguration(cfg *Configuration) error {
+	if _, errorDto := reloadConfiguration(cfg); errorDto != nil {
+		return errors.New(errorDto.Title + ": " + errorDto.Detail)
+	}
+	return nil
+}
+
+// reloadConfiguration reads, validates and applies the configuration.
+func reloadConfiguration(cfg *Configuration) (*ReloadResult, *ErrorDto) {
+	live := cfg.live
+	if live == nil || live.loader == nil {
+		return nil, errorDto(errReloadingConfigurationFailed, "configuration can not be read again")
+	}
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	loaded, err := live.loader()
+	if err == nil {
+		err = loaded.Validate()
+	}
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReloadingConfigurationFailed, strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	current := cfg.current()
+	next := *current
+	result := &ReloadResult{Applied: make([]string, 0), RestartRequired: make([]string, 0)}
+	currentValue, loadedValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(loaded).Elem(), reflect.ValueOf(&next).Elem()
+	for i := 0; i < currentValue.NumField(); i++ {
+		name := jsonName(currentValue.Type().Field(i))
+		if name == "" || reflect.DeepEqual(currentValue.Field(i).Interface(), loadedValue.Field(i).Interface()) {
+			continue
+		}
+		switch {
+		case name == "quotas" && current.quotas == nil:
+			// quotas are tracked only when configured at start
+			result.RestartRequired = append(result.RestartRequired, name)
+		case reloadableOptions[name]:
+			nextValue.Field(i).Set(loadedValue.Field(i))
+			result.Applied = append(result.Applied, name)
+		case name == "logging":
+			// level and access logging are applied, the log file is opened only at start
+			next.Logging.Level, next.Logging.Access = loaded.Logging.Level, loaded.Logging.Access
+			if level, err := parseLogLevel(next.Logging.Level); err == nil {
+				logLevel.Set(level)
+			}
+			if !reflect.DeepEqual(next.Logging, loaded.Logging) {
+				result.RestartRequired = append(result.RestartRequired, name)
+			} else {
+				result.Applied = append(result.Applied, name)
+			}
+		case name == "tls":
+			// principals of client certificates are applied, certificates are reloaded automatically
+			next.Tls.Subjects, next.Tls.CommonNamePrincipal = loaded.Tls.Subjects, loaded.Tls.CommonNamePrincipal
+			if !reflect.DeepEqual(next.Tls, loaded.Tls) {
+				result.RestartRequired = append(result.RestartRequired, name)
+			} else {
+				result.Applied = append(result.Applied, name)
+			}
+		default:
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+	if !reflect.DeepEqual(current.RateLimits, next.RateLimits) {
+		next.rateLimits = newRateLimiter(&next)
+	}
+	if !reflect.DeepEqual(current.Transfer, next.Transfer) {
+		next.bandwidths = newPrincipalBandwidths()
+	}
+	if current.quotas != nil && !reflect.DeepEqual(current.Quotas, next.Quotas) {
+		current.quotas.reconfigure(next.Quotas)
+	}
+	live.current.Store(&next)
+	slog.Info("configuration reloaded", slog.Any("applied", result.Applied), slog.Any("restartRequired", result.RestartRequired))
+	return result, nil
+}
+
+// admin wraps the handler of administrative request, so it is processed only
+// for principals configured as administrators.
+func admin(handler RouteHandler) RouteHandler {
+	return func(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+		principal := requestPrincipal(req)
+		for _, administrator := range cfg.Auth.Admins {
+			if principal != "" && principal == administrator {
+				handler(cfg, w, req)
+				return
+			}
+		}
+		writeResultError(w, errorDto(errAdministratorRequired, req.URL.Path))
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// requestStatus sends the request with specified API key and returns the status of the response.
+func requestStatus(t *testing.T, method string, url string, apiKey string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdministrativeRoutes(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Trash.Enabled = true
+	cfg.Journal.Enabled = true
+	cfg.Auth.Required = true
+	cfg.Auth.Keys = []ApiKeyConfiguration{{Principal: "user", Key: "user-key"}, {Principal: "admin", Key: "admin-key"}}
+	cfg.Auth.Admins = []string{"admin"}
+	url := startTestServer(t, cfg)
+	tests := []struct {
+		method string
+		route  string
+		status int // Status of the response sent to administrator.
+	}{
+		{HttpDELETE, routeTrashPurge, http.StatusOK},
+		{HttpGET, routeJournalRead, http.StatusOK},
+		{HttpPOST, routeReplicationPromote, http.StatusBadRequest},
+		{HttpPOST, routeConfigReload, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := requestStatus(t, test.method, url+test.route, "user-key"); status != http.StatusForbidden {
+			t.Errorf("%s: expected status %d for non-administrator, actual %d", test.route, http.StatusForbidden, status)
+		}
+		if status := requestStatus(t, test.method, url+test.route, "admin-key"); status != test.status {
+			t.Errorf("%s: expected status %d for administrator, actual %d", test.route, test.status, status)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	replicationDirectory       = "replication" // Name of the directory for replication state, created in state directory.
+	replicationStateFile       = "state.json"  // Name of the file with replication state.
+	replicationActor           = "replication" // Actor recorded with changes applied by the replica.
+	roleReplica                = "replica"     // Role of the server following the primary.
+	rolePrimary                = "primary"     // Role of the server accepting changes.
+	defaultReplicationInterval = 1             // Default interval in seconds between polls of the primary journal.
+	defaultReplicationBatch    = 1000          // Default number of journal entries read in single request.
+	defaultReplicationTimeout  = 60            // Default timeout in seconds of single request to the primary.
+)
+
+// ReplicationStatusDto is the implementation of DTO for replication status.
+type ReplicationStatusDto struct {
+	Role        string     `json:"role"                   api:"Role of the server: primary or replica."`
+	Primary     string     `json:"primary,omitempty"      api:"URL of the followed primary server."`
+	Promoted    bool       `json:"promoted,omitempty"     api:"Flag indicating if the replica was promoted to primary."`
+	Applied     uint64     `json:"applied"                api:"Sequence number of the last applied entry of the primary journal."`
+	PrimaryLast uint64     `json:"primaryLast"            api:"Sequence number of the last entry in the primary journal, as last seen."`
+	Lag         uint64     `json:"lag"                    api:"Number of primary journal entries not applied yet."`
+	LastSync    *time.Time `json:"lastSync,omitempty"     api:"The time of the last successful poll of the primary journal."`
+	LastError   string     `json:"lastError,omitempty"    api:"The last error encountered while following the primary."`
+	Journal     uint64     `json:"journal"                api:"Sequence number of the last entry in the journal of this server."`
+}
+
+// replicaState is the persistent part of the replication state, stored in state directory.
+type replicaState struct {
+	Applied  uint64 `json:"applied"`  // Sequence number of the last applied entry of the primary journal.
+	Promoted bool   `json:"promoted"` // Flag indicating if the replica was promoted to primary.
+	Synced   bool   `json:"synced"`   // Flag indicating if the initial synchronization of the whole content was completed.
+}
+
+// replica follows the journal of the primary server and applies recorded changes locally.
+// Changed files are pulled from the primary as shared files. Before the first entry is applied,
+// and whenever entries are missing in the primary journal, the whole content is synchronized
+// with the primary, so files changed before the journal was started are replicated too.
+// While following, the replica rejects all requests changing its content, until it is promoted.
+type replica struct {
+	cfg         *Configuration
+	client      *http.Client
+	statePath   string
+	mutex       sync.Mutex
+	state       replicaState
+	primaryLast uint64
+	lastSync    time.Time
+	lastError   string
+	done        chan struct{} // Closed when the replica stops following.
+	stopped     chan struct{} // Closed when the following loop has finished.
+	stopOnce    sync.Once
+}
+
+// startReplication restores the replication state and starts following the primary in background,
+// until the returned stop function is called. Promoted replica does not follow the primary anymore.
+func startReplication(cfg *Configuration) (*replica, error) {
+	directory := filepath.Join(cfg.stateDirectory(), replicationDirectory)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	r := &replica{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: time.Duration(valueOrDefault(cfg.Replication.Timeout, defaultReplicationTimeout)) * time.Second},
+		statePath: filepath.Join(directory, replicationStateFile),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if data, err := os.ReadFile(r.statePath); err == nil {
+		if err = json.Unmarshal(data, &r.state); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if r.state.Promoted {
+		close(r.stopped)
+		return r, nil
+	}
+	go r.run()
+	return r, nil
+}
+
+// stop stops following the primary and waits until the currently applied change is completed.
+func (r *replica) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+	<-r.stopped
+}
+
+// readOnly returns true while the replica follows the primary.
+func (r *replica) readOnly() bool {
+	if r == nil {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return !r.state.Promoted
+}
+
+// stopping returns true when the replica should stop following the primary.
+func (r *replica) stopping() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run polls the primary journal until the replica is stopped. When more entries
+// are waiting on the primary, the next poll follows immediately.
+func (r *replica) run() {
+	defer close(r.stopped)
+	interval := time.Duration(valueOrDefault(r.cfg.Replication.PollInterval, defaultReplicationInterval)) * time.Second
+	for {
+		behind, err := r.sync()
+		r.mutex.Lock()
+		if err != nil {
+			r.lastError = err.Error()
+		} else {
+			r.lastError = ""
+		}
+		r.mutex.Unlock()
+		if err != nil {
+			logError(fmt.Errorf("replication from %s failed: %v", r.cfg.Replication.Primary, err))
+		}
+		if behind && err == nil {
+			if r.stopping() {
+				return
+			}
+			continue
+		}
+		select {
+		case <-time.After(interval):
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// sync reads the next batch of entries from the primary journal and applies them.
+// Returns true when the primary journal contains more entries to apply.
+func (r *replica) sync() (bool, error) {
+	r.mutex.Lock()
+	from := r.state.Applied + 1
+	r.mutex.Unlock()
+	var journal JournalDto
+	query := url.Values{"from": {strconv.FormatUint(from, 10)}, "limit": {strconv.Itoa(valueOrDefault(r.cfg.Replication.BatchSize, defaultReplicationBatch))}}
+	if err := r.get(routeJournalRead+"?"+query.Encode(), func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&journal)
+	}); err != nil {
+		return false, err
+	}
+	r.mutex.Lock()
+	r.primaryLast = journal.Last
+	r.lastSync = time.Now().UTC()
+	r.mutex.Unlock()
+	if journal.Last < from-1 {
+		return false, fmt.Errorf("primary journal (last entry %d) is behind the replica (applied entry %d)", journal.Last, from-1)
+	}
+	r.mutex.Lock()
+	synced := r.state.Synced
+	r.mutex.Unlock()
+	if synced && len(journal.Data) > 0 && journal.Data[0].Sequence > from {
+		// entries were removed from the primary journal, changes made meanwhile are synchronized at once
+		slog.Warn("entries not available in primary journal, synchronizing whole content",
+			slog.Uint64("from", from), slog.Uint64("to", journal.Data[0].Sequence-1))
+		synced = false
+	}
+	if !synced {
+		// entries read before the synchronization are applied after it, so the content converges
+		if err := r.syncDirectory("/"); err != nil {
+			return false, fmt.Errorf("synchronizing content failed: %v", err)
+		}
+		r.mutex.Lock()
+		r.state.Synced = true
+		err := r.saveState()
+		r.mutex.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, entry := range journal.Data {
+		if r.stopping() {
+			return false, nil
+		}
+		if err := r.apply(entry); err != nil {
+			return false, fmt.Errorf("applying entry %d (%s %s) failed: %v", entry.Sequence, entry.Op, entry.Name, err)
+		}
+		r.mutex.Lock()
+		r.state.Applied = entry.Sequence
+		err := r.saveState()
+		r.mutex.Unlock()
+		if err != nil {
+			return false, err
+		}
+	}
+	return journal.Next <= journal.Last, nil
+}
+
+// apply applies single journal entry to the local content.
+func (r *replica) apply(entry *JournalEntry) error {
+	if errorDto := checkName(r.cfg, entry.Name); errorDto != nil {
+		return fmt.Errorf("%s: %s", errorDto.Title, errorDto.Detail)
+	}
+	fullName := prepareAbsolutePath(r.cfg, entry.Name)
+	switch entry.Op {
+	case EventCreate, EventWrite, EventAppend:
+		if entry.Directory {
+			// copied or restored directory is created with its content, which is pulled at once
+			if err := r.createDirectory(entry.Name); err != nil {
+				return err
+			}
+			return r.syncDirectory(entry.Name)
+		} else if pulled, err := r.pull(entry.Name, entry.Digest); err != nil {
+			return err
+		} else if !pulled {
+			return nil
+		}
+	case EventDelete:
+		if err := os.RemoveAll(fullName); err != nil {
+			return err
+		}
+	case EventMove:
+		if errorDto := checkName(r.cfg, entry.Target); errorDto != nil {
+			return fmt.Errorf("%s: %s", errorDto.Title, errorDto.Detail)
+		}
+		targetName := prepareAbsolutePath(r.cfg, entry.Target)
+		if err := os.MkdirAll(filepath.Dir(targetName), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(fullName, targetName); os.IsNotExist(err) && !entry.Directory {
+			// the source was never replicated, pull the moved file instead
+			if _, err = r.pull(entry.Target, ""); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	r.publish(&Event{
+		Type:      entry.Op,
+		Name:      entry.Name,
+		Target:    entry.Target,
+		Directory: entry.Directory,
+		Size:      entry.Size,
+		Digest:    entry.Digest,
+	})
+	return nil
+}
+
+// pull downloads the current content of the file from the primary, unless the local
+// file already has the expected checksum. Returns false when the file was not changed,
+// or when it does not exist on the primary anymore, the following journal entries
+// will remove or move it.
+func (r *replica) pull(name string, digest string) (bool, error) {
+	fullName := prepareAbsolutePath(r.cfg, name)
+	if digest != "" && localDigest(r.cfg, fullName) == digest {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+		return false, err
+	}
+	err := r.get(routeFileShared+strings.TrimPrefix((&url.URL{Path: name}).EscapedPath(), "/"), func(body io.Reader) error {
+		// the content is received into temporary file in state directory, so it is not visible to clients
+		temporary, err := createTemporaryFile(r.cfg, "replica-*")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = temporary.Close()
+			_ = os.Remove(temporary.Name())
+		}()
+		if _, err = io.Copy(temporary, body); err != nil {
+			return err
+		}
+		r.cfg.events.expect(name)
+		return installFile(temporary, fullName)
+	})
+	if missingOnPrimary(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// syncDirectory makes the content of the directory with specified name equal to the content
+// of the same directory on the primary. Missing directories are created, changed files are pulled,
+// and files and directories not existing on the primary are removed. Events are published
+// for every pulled and removed file.
+func (r *replica) syncDirectory(name string) error {
+	query := url.Values{"name": {name}}
+	var directories DirectoryListDto
+	err := r.get(routeDirectoryList+"?"+query.Encode(), func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&directories)
+	})
+	if missingOnPrimary(err) {
+		// the directory was removed on the primary, the following journal entries remove it too
+		return nil
+	} else if err != nil {
+		return err
+	}
+	query.Set("digest", "true")
+	var manifest ManifestDto
+	if err = r.get(routeDirectoryManifest+"?"+query.Encode(), func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&manifest)
+	}); missingOnPrimary(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fullName := prepareAbsolutePath(r.cfg, name)
+	remote := make(map[string]bool)
+	for _, directory := range directories.Data {
+		if relativeName := strings.Trim(filepath.ToSlash(directory), "/"); relativeName != "" {
+			remote[relativeName] = true
+			if err = r.createDirectory(path.Join(name, relativeName)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range manifest.Data {
+		remote[entry.Path] = true
+		fileName := path.Join(name, entry.Path)
+		eventType := writeEventType(r.cfg, fileName)
+		if pulled, err := r.pull(fileName, entry.Digest); err != nil {
+			return err
+		} else if pulled {
+			r.publish(&Event{Type: eventType, Name: fileName, Size: entry.Size, Digest: entry.Digest})
+		}
+	}
+	// remove local files and directories not existing on the primary, removed directories are skipped
+	return filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || filePath == fullName {
+			return err
+		}
+		if r.cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		relativeName := filepath.ToSlash(strings.TrimPrefix(filePath, fullName+string(filepath.Separator)))
+		if remote[relativeName] {
+			return nil
+		}
+		if err = os.RemoveAll(filePath); err != nil {
+			return err
+		}
+		r.publish(&Event{Type: EventDelete, Name: path.Join(name, relativeName), Directory: info.IsDir()})
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// createDirectory creates the directory with specified name and all its parents,
+// the event is published only when the directory did not exist.
+func (r *replica) createDirectory(name string) error {
+	fullName := prepareAbsolutePath(r.cfg, name)
+	if info, err := os.Stat(fullName); err == nil && info.IsDir() {
+		return nil
+	}
+	if err := os.MkdirAll(fullName, 0755); err != nil {
+		return err
+	}
+	r.publish(&Event{Type: EventCreate, Name: name, Directory: true})
+	return nil
+}
+
+// publish publishes the event about the change applied by the replica.
+func (r *replica) publish(event *Event) {
+	event.Actor = replicationActor
+	event.Source = eventSourceReplica
+	r.cfg.events.publish(event)
+}
+
+// missingOnPrimary returns true when the error reports that the requested file
+// or directory does not exist on the primary anymore.
+func missingOnPrimary(err error) bool {
+	var statusErr *replicationStatusError
+	return errors.As(err, &statusErr) && statusErr.status < 500
+}
+
+// localDigest returns the SHA256 checksum of the local file, or empty string when not available.
+func localDigest(cfg *Configuration, fullName string) string {
+	file, err := os.Open(fullName)
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	digest, _ := cfg.checksums.digest(fullName, file)
+	return digest
+}
+
+// replicationStatusError reports unexpected response status received from the primary.
+type replicationStatusError struct {
+	status int
+	text   string
+}
+
+func (e *replicationStatusError) Error() string {
+	return fmt.Sprintf("unexpected response status %d from primary: %s", e.status, e.text)
+}
+
+// get sends GET request to the primary and passes the body of the successful response to the reader.
+// The configured API key is sent as bearer token.
+func (r *replica) get(route string, read func(io.Reader) error) error {
+	req, err := http.NewRequest(HttpGET, strings.TrimSuffix(r.cfg.Replication.Primary, "/")+route, nil)
+	if err != nil {
+		return err
+	}
+	if r.cfg.Replication.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Replication.ApiKey)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != 200 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &replicationStatusError{status: resp.StatusCode, text: strings.TrimSpace(string(text))}
+	}
+	return read(resp.Body)
+}
+
+// saveState stores the replication state, must be called with the mutex held.
+func (r *replica) saveState() error {
+	data, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	temporary := r.statePath + ".tmp"
+	if err = os.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, r.statePath)
+}
+
+// promote stops following the primary and starts accepting changes.
+// The promotion is persistent, restarted server does not follow the primary anymore.
+func (r *replica) promote() error {
+	r.stop()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.Promoted = true
+	return r.saveState()
+}
+
+// replicationStatus returns the role of the server and the progress of the replication.
+func replicationStatus(cfg *Configuration) *ReplicationStatusDto {
+	status := &ReplicationStatusDto{Role: rolePrimary}
+	if cfg.journal != nil {
+		status.Journal = cfg.journal.lastSequence()
+	}
+	if r := cfg.replication; r != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		status.Primary = cfg.Replication.Primary
+		status.Promoted = r.state.Promoted
+		if !r.state.Promoted {
+			status.Role = roleReplica
+		}
+		status.Applied = r.state.Applied
+		status.PrimaryLast = r.primaryLast
+		if r.primaryLast > r.state.Applied {
+			status.Lag = r.primaryLast - r.state.Applied
+		}
+		if !r.lastSync.IsZero() {
+			lastSync := r.lastSync
+			status.LastSync = &lastSync
+		}
+		status.LastError = r.lastError
+	}
+	return status
+}
+
+// replicationPromote promotes the replica to primary.
+func replicationPromote(cfg *Configuration) (*ReplicationStatusDto, *ErrorDto) {
+	if !cfg.replication.readOnly() {
+		return nil, errorDto(errNotAReplica, errMsgCheckServerLogForDetails)
+	}
+	if err := cfg.replication.promote(); err != nil {
+		logError(err)
+		return nil, errorDto(errPromotingReplicaFailed, errMsgCheckServerLogForDetails)
+	}
+	return replicationStatus(cfg), nil
+}
+
+// writable wraps the handler of request changing the content, so it is rejected
+// while the server is a replica following the primary.
+func writable(handler RouteHandler) RouteHandler {
+	return func(cfg *Configuration, w http.ResponseWriter, req *http.Request) {
+		if cfg.replication.readOnly() {
+			writeResultError(w, errorDto(errReadOnlyReplica, cfg.Replication.Primary))
+			return
+		}
+		handler(cfg, w, req)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freePort returns the number of currently unused local TCP port.
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startTestServer validates the configuration, starts the server and stops it when the test finishes.
+// Returns the URL of the started server.
+func startTestServer(t *testing.T, cfg *Configuration) string {
+	t.Helper()
+	cfg.ServerPort = freePort(t)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := StartServer(cfg)
+	t.Cleanup(func() { StopServer(cfg, httpServer) })
+	return "http://localhost:" + strconv.Itoa(cfg.ServerPort)
+}
+
+// post sends POST request with specified body and API key, and checks the status of the response.
+func post(t *testing.T, url string, apiKey string, body string, expectedStatus int) {
+	t.Helper()
+	req, err := http.NewRequest(HttpPOST, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		t.Fatalf("POST %s: expected status %d, actual %d", url, expectedStatus, resp.StatusCode)
+	}
+}
+
+func TestReplicaAppliesChangesOfPrimary(t *testing.T) {
+	primaryReceiver, primaryNotifications := startWebhookReceiver(t, func() int { return http.StatusOK })
+	replicaReceiver, replicaNotifications := startWebhookReceiver(t, func() int { return http.StatusOK })
+
+	primary := &Configuration{RootDirectory: t.TempDir()}
+	primary.Journal.Enabled = true
+	primary.Auth.Required = true
+	primary.Auth.Keys = []ApiKeyConfiguration{{Principal: "writer", Key: "writer-key"}, {Principal: "replica", Key: "replica-key"}}
+	primary.Auth.Admins = []string{"replica"}
+	primary.Webhooks.Subscriptions = []WebhookSubscription{{Url: primaryReceiver.URL}}
+	primaryUrl := startTestServer(t, primary)
+
+	replica := &Configuration{RootDirectory: t.TempDir()}
+	replica.Replication.Primary = primaryUrl
+	replica.Replication.ApiKey = "replica-key"
+	replica.Webhooks.Subscriptions = []WebhookSubscription{{Url: replicaReceiver.URL}}
+	replicaUrl := startTestServer(t, replica)
+
+	content := "replicated content"
+	post(t, primaryUrl+routeDirectoryCreate+"?name=/dir", "writer-key", "", http.StatusOK)
+	post(t, primaryUrl+routeFileWrite+"?name=/dir/a.txt", "writer-key", base64.StdEncoding.EncodeToString([]byte(content)), http.StatusOK)
+
+	replicated := filepath.Join(replica.RootDirectory, "dir", "a.txt")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if data, err := os.ReadFile(replicated); err == nil && string(data) == content {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file not replicated")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the replica rejects changes while following the primary
+	post(t, replicaUrl+routeFileWrite+"?name=/b.txt", "", "eHl6", http.StatusForbidden)
+
+	// replicated changes are published with replication source, after the file was written
+	var events []*Event
+	for deadline = time.Now().Add(time.Second); len(events) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		replica.events.mutex.Lock()
+		events = append([]*Event(nil), replica.events.history...)
+		replica.events.mutex.Unlock()
+	}
+	names := make([]string, 0)
+	for _, event := range events {
+		names = append(names, event.Name)
+		if event.Source != eventSourceReplica {
+			t.Errorf("unexpected source of replica event %+v", event)
+		}
+	}
+	if strings.Join(names, ",") != "/dir,/dir/a.txt" {
+		t.Errorf("unexpected replica events %v", names)
+	}
+
+	// webhooks are notified only by the primary
+	receiveWebhook(t, primaryNotifications)
+	select {
+	case request := <-replicaNotifications:
+		t.Errorf("replica notified webhook about replicated change %s", request.body)
+	case <-time.After(2 * webhookQueueScan):
+	}
+}
+
+// waitForFile waits until the file has expected content, or until it is removed when the content is nil.
+func waitForFile(t *testing.T, fullName string, content *string) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		data, err := os.ReadFile(fullName)
+		if (content == nil && os.IsNotExist(err)) || (content != nil && err == nil && string(data) == *content) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: expected content %v, actual %q (%v)", fullName, content, data, err)
+		}
+	}
+}
+
+func TestReplicaSynchronizesDirectories(t *testing.T) {
+	content := "content written before the journal"
+	primary := &Configuration{RootDirectory: t.TempDir()}
+	primary.Journal.Enabled = true
+	primary.Auth.Keys = []ApiKeyConfiguration{{Principal: "replica", Key: "replica-key"}}
+	primary.Auth.Admins = []string{"replica"}
+	// files existing before the journal was started are not recorded in the journal
+	if err := os.MkdirAll(filepath.Join(primary.RootDirectory, "old", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(primary.RootDirectory, "old", "sub", "a.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	primaryUrl := startTestServer(t, primary)
+
+	replica := &Configuration{RootDirectory: t.TempDir()}
+	replica.Replication.Primary = primaryUrl
+	replica.Replication.ApiKey = "replica-key"
+	// files not existing on the primary are removed by the initial synchronization
+	stale := filepath.Join(replica.RootDirectory, "stale.txt")
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	startTestServer(t, replica)
+	waitForFile(t, filepath.Join(replica.RootDirectory, "old", "sub", "a.txt"), &content)
+	waitForFile(t, stale, nil)
+
+	// copied directory is reported by single event, its whole content is pulled
+	post(t, primaryUrl+routeBatch, "", `[{"op":"copy","name":"/old","target":"/new"}]`, http.StatusOK)
+	copied := filepath.Join(replica.RootDirectory, "new", "sub", "a.txt")
+	waitForFile(t, copied, &content)
+	info, err := os.Stat(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("unexpected permissions of replicated file %v", info.Mode().Perm())
+	}
+	// temporary files are not visible in replicated directories
+	entries, err := os.ReadDir(filepath.Join(replica.RootDirectory, "new", "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unexpected content of replicated directory %v", entries)
+	}
+}
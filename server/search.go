@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchLimit    = 100      // Default maximum number of search results.
+	maxSearchLimit        = 10000    // Maximum number of search results.
+	maxSearchContext      = 10       // Maximum number of context lines around content matches.
+	maxMatchesPerFile     = 100      // Maximum number of content matches reported for single file.
+	maxSearchLineLength   = 1 << 20  // Maximum length of a line in searched text files.
+	defaultMaxContentSize = 16 << 20 // Default maximum size of files searched for content.
+	defaultIndexInterval  = 300      // Default interval in seconds between rebuilds of the name index.
+	binaryDetectionSize   = 8000     // Number of leading bytes checked for null bytes to detect binary files.
+)
+
+// SearchMatch describes single line matching the searched content.
+type SearchMatch struct {
+	Line   int      `json:"line"              api:"Line number, starting with 1."`
+	Text   string   `json:"text"              api:"Matching line."`
+	Before []string `json:"before,omitempty"  api:"Context lines preceding the matching line."`
+	After  []string `json:"after,omitempty"   api:"Context lines following the matching line."`
+}
+
+// SearchResult describes single file found by search.
+type SearchResult struct {
+	Name     string         `json:"name"               api:"Full name of the file."`
+	Size     int64          `json:"size"               api:"File size in bytes."`
+	Modified time.Time      `json:"modified"           api:"The time of the last modification of the file."`
+	Matches  []*SearchMatch `json:"matches,omitempty"  api:"Lines matching the searched content."`
+}
+
+// SearchDto is the implementation of DTO for search results.
+type SearchDto struct {
+	Data      []*SearchResult `json:"data"       api:"Found files ordered by name."`
+	Truncated bool            `json:"truncated"  api:"Flag indicating if more files match than the limit allows."`
+	Indexed   bool            `json:"indexed"    api:"Flag indicating if the name index was used."`
+}
+
+// searchQuery holds the search criteria.
+type searchQuery struct {
+	directory      string         // Searched directory, including subdirectories.
+	glob           string         // Pattern matched against the file name or the path relative to searched directory.
+	regex          *regexp.Regexp // Regular expression matched against the full name.
+	minSize        int64          // Minimal file size, negative when not limited.
+	maxSize        int64          // Maximal file size, negative when not limited.
+	modifiedAfter  time.Time      // Files modified at this time or later.
+	modifiedBefore time.Time      // Files modified before this time.
+	content        *regexp.Regexp // Regular expression searched in the content of text files.
+	context        int            // Number of context lines around content matches.
+	limit          int            // Maximum number of results.
+}
+
+// matches returns true when the file satisfies all criteria other than content.
+func (q *searchQuery) matches(name string, size int64, modified time.Time) bool {
+	if !inDirectory(name, q.directory) {
+		return false
+	}
+	if q.glob != "" {
+		relativeName := strings.TrimPrefix(strings.TrimPrefix(name, q.directory), "/")
+		if !matchesAny([]string{q.glob}, relativeName) {
+			return false
+		}
+	}
+	if q.regex != nil && !q.regex.MatchString(name) {
+		return false
+	}
+	if (q.minSize >= 0 && size < q.minSize) || (q.maxSize >= 0 && size > q.maxSize) {
+		return false
+	}
+	if (!q.modifiedAfter.IsZero() && modified.Before(q.modifiedAfter)) || (!q.modifiedBefore.IsZero() && !modified.Before(q.modifiedBefore)) {
+		return false
+	}
+	return true
+}
+
+// searchParams reads search criteria from request parameters.
+func searchParams(cfg *Configuration, w http.ResponseWriter, req *http.Request) (*searchQuery, bool) {
+	query := &searchQuery{}
+	var ok bool
+	if query.directory, ok = optionalNameParam(cfg, w, req, "name"); !ok {
+		return nil, false
+	}
+	if query.directory == "" {
+		query.directory = "/"
+	}
+	query.directory = path.Clean(query.directory)
+	if query.glob, ok = optionalSingleParam(w, req, "glob", ""); !ok {
+		return nil, false
+	}
+	if query.glob != "" {
+		if _, err := path.Match(query.glob, ""); err != nil {
+			writeResultError(w, errorDto(errInvalidParameterValue, "glob ("+query.glob+")"))
+			return nil, false
+		}
+	}
+	if query.regex, ok = regexpParam(w, req, "regex"); !ok {
+		return nil, false
+	}
+	if query.content, ok = regexpParam(w, req, "content"); !ok {
+		return nil, false
+	}
+	if query.minSize, ok = optionalIntParam(w, req, "minSize", -1); !ok {
+		return nil, false
+	}
+	if query.maxSize, ok = optionalIntParam(w, req, "maxSize", -1); !ok {
+		return nil, false
+	}
+	if query.modifiedAfter, ok = timeParam(w, req, "modifiedAfter"); !ok {
+		return nil, false
+	}
+	if query.modifiedBefore, ok = timeParam(w, req, "modifiedBefore"); !ok {
+		return nil, false
+	}
+	context, ok := optionalIntParam(w, req, "context", 0)
+	if !ok {
+		return nil, false
+	}
+	query.context = int(max(0, min(context, maxSearchContext)))
+	limit, ok := optionalIntParam(w, req, "limit", defaultSearchLimit)
+	if !ok {
+		return nil, false
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	query.limit = int(min(limit, maxSearchLimit))
+	return query, true
+}
+
+// regexpParam reads optional parameter containing regular expression.
+func regexpParam(w http.ResponseWriter, req *http.Request, name string) (*regexp.Regexp, bool) {
+	value, ok := optionalSingleParam(w, req, name, "")
+	if !ok || value == "" {
+		return nil, ok
+	}
+	expression, err := regexp.Compile(value)
+	if err != nil {
+		writeResultError(w, errorDto(errInvalidParameterValue, name+" ("+value+")"))
+		return nil, false
+	}
+	return expression, true
+}
+
+// timeParam reads optional parameter containing time in RFC 3339 format.
+func timeParam(w http.ResponseWriter, req *http.Request, name string) (time.Time, bool) {
+	value, ok := optionalSingleParam(w, req, name, "")
+	if !ok || value == "" {
+		return time.Time{}, ok
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		writeResultError(w, errorDto(errInvalidParameterValue, name+" ("+value+")"))
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+// search finds files matching the query. Candidates are taken from the name index when it is ready,
+// otherwise the directory tree is walked. Content of candidates is searched by a bounded number
+// of workers, candidates are dispatched in the order of names, so the results are the first
+// matching files by name, even when searching stops early after reaching the limit.
+func search(cfg *Configuration, req *http.Request, query *searchQuery) (*SearchDto, *ErrorDto) {
+	fullName := prepareAbsolutePath(cfg, query.directory)
+	if fileInfo, err := os.Stat(fullName); err != nil || !fileInfo.IsDir() {
+		return nil, errorDto(errReadingDirectoryContentFailed, query.directory)
+	}
+	result := &SearchDto{Data: make([]*SearchResult, 0)}
+	var candidates []*SearchResult
+	if cfg.search != nil {
+		candidates, result.Indexed = cfg.search.find(query)
+	}
+	if !result.Indexed {
+		var errorDto *ErrorDto
+		if candidates, errorDto = walkCandidates(cfg, fullName, query); errorDto != nil {
+			return nil, errorDto
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].Name < candidates[b].Name })
+	if query.content == nil {
+		if len(candidates) > query.limit {
+			candidates, result.Truncated = candidates[:query.limit], true
+		}
+		result.Data = candidates
+		return result, nil
+	}
+	found := searchContents(cfg, req.Context(), query, candidates)
+	for _, candidate := range found {
+		if candidate != nil && len(candidate.Matches) > 0 {
+			if len(result.Data) == query.limit {
+				result.Truncated = true
+				break
+			}
+			result.Data = append(result.Data, candidate)
+		}
+	}
+	return result, nil
+}
+
+// walkCandidates walks the directory tree and collects files satisfying all criteria other than content.
+func walkCandidates(cfg *Configuration, fullName string, query *searchQuery) ([]*SearchResult, *ErrorDto) {
+	candidates := make([]*SearchResult, 0)
+	err := filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name := relativeName(cfg, filePath)
+		if query.matches(name, info.Size(), info.ModTime()) {
+			candidates = append(candidates, &SearchResult{Name: name, Size: info.Size(), Modified: info.ModTime().UTC()})
+		}
+		return nil
+	})
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errWalkingDirectoryTreeFailed, query.directory)
+	}
+	return candidates, nil
+}
+
+// searchContents searches the content of candidates using bounded number of workers. Returned slice
+// has the same order as candidates, entries of candidates that were not searched are nil.
+// Dispatching stops when the limit of matching files is reached or the request is cancelled.
+func searchContents(cfg *Configuration, ctx context.Context, query *searchQuery, candidates []*SearchResult) []*SearchResult {
+	found := make([]*SearchResult, len(candidates))
+	maxContentSize := cfg.Search.MaxContentSize
+	if maxContentSize <= 0 {
+		maxContentSize = defaultMaxContentSize
+	}
+	indexes := make(chan int)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	matching := 0
+	for i := 0; i < valueOrDefault(cfg.Search.Workers, runtime.NumCPU()); i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range indexes {
+				candidate := candidates[index]
+				if candidate.Size > maxContentSize {
+					continue
+				}
+				candidate.Matches = grepFile(prepareAbsolutePath(cfg, candidate.Name), query.content, query.context)
+				mutex.Lock()
+				found[index] = candidate
+				if len(candidate.Matches) > 0 {
+					matching++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for index := range candidates {
+		mutex.Lock()
+		done := matching >= query.limit+1
+		mutex.Unlock()
+		if done {
+			break
+		}
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wait.Wait()
+	return found
+}
+
+// grepFile returns lines of the text file matching the regular expression, with requested number
+// of context lines. Binary files (containing null bytes) are skipped.
+func grepFile(fullName string, expression *regexp.Regexp, context int) []*SearchMatch {
+	file, err := os.Open(fullName)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	reader := bufio.NewReaderSize(file, 64*1024)
+	if head, _ := reader.Peek(binaryDetectionSize); bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchLineLength)
+	matches := make([]*SearchMatch, 0)
+	before := make([]string, 0, context)
+	var pending []*SearchMatch // matches still collecting following context lines
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		for _, match := range pending {
+			match.After = append(match.After, line)
+		}
+		for len(pending) > 0 && len(pending[0].After) >= context {
+			pending = pending[1:]
+		}
+		if expression.MatchString(line) {
+			if len(matches) == maxMatchesPerFile {
+				break
+			}
+			match := &SearchMatch{Line: lineNumber, Text: line}
+			if context > 0 {
+				match.Before = append([]string{}, before...)
+				pending = append(pending, match)
+			}
+			matches = append(matches, match)
+		}
+		if context > 0 {
+			if len(before) == context {
+				before = before[1:]
+			}
+			before = append(before, line)
+		}
+	}
+	return matches
+}
@@ -4,8 +4,8 @@ package server
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,23 +13,47 @@ import (
 )
 
 const (
-	routeDirectoryRead   = "/directory/read"   // Reads directory content.
-	routeDirectoryTree   = "/directory/tree"   // Reads directory tree.
-	routeDirectoryList   = "/directory/list"   // Lists all directories in tree with full relative paths.
-	routeDirectoryCreate = "/directory/create" // Creates new directory.
-	routeDirectoryDelete = "/directory/delete" // Deletes existing directory.
-	routeFileRead        = "/file/read"        // Reads file's content.
-	routeFileWrite       = "/file/write"       // Writes to existing file or creates a new one and writes to it.
-	routeFileAppend      = "/file/append"      // Appends an existing file or creates a new one and appends it.
-	routeFileDelete      = "/file/delete"      // Deletes existing file.
-	routeFileExists      = "/file/exists"      // Checks if file exists.
-	routeFileChecksum    = "/file/checksum"    // Calculates file checksum.
-	routeFileShared      = "/shared/"          // Shares the file content (accessible as link to file).
-	HttpGET              = "GET"               // HTTP get method.
-	HttpPOST             = "POST"              // HTTP post method.
-	HttpPUT              = "PUT"               // HTTP put method.
-	HttpDELETE           = "DELETE"            // HTTP delete method.
-	HttpOPTIONS          = "OPTIONS"           // HTTP options method.
+	routeDirectoryRead      = "/directory/read"      // Reads directory content.
+	routeDirectoryTree      = "/directory/tree"      // Reads directory tree.
+	routeDirectoryList      = "/directory/list"      // Lists all directories in tree with full relative paths.
+	routeDirectoryCreate    = "/directory/create"    // Creates new directory.
+	routeDirectoryDelete    = "/directory/delete"    // Deletes existing directory.
+	routeDirectoryArchive   = "/directory/archive"   // Streams directory content as an archive.
+	routeDirectoryExtract   = "/directory/extract"   // Extracts uploaded archive into directory.
+	routeDirectoryManifest  = "/directory/manifest"  // Streams the manifest of all files in directory.
+	routeDirectoryDiff      = "/directory/diff"      // Compares client manifest with directory content.
+	routeFileRead           = "/file/read"           // Reads file's content.
+	routeFileWrite          = "/file/write"          // Writes to existing file or creates a new one and writes to it.
+	routeFileAppend         = "/file/append"         // Appends an existing file or creates a new one and appends it.
+	routeFileDelete         = "/file/delete"         // Deletes existing file.
+	routeFileExists         = "/file/exists"         // Checks if file exists.
+	routeFileChecksum       = "/file/checksum"       // Calculates file checksum.
+	routeFileVersions       = "/file/versions"       // Lists preserved versions of a file.
+	routeFileRestore        = "/file/restore"        // Restores preserved version of a file.
+	routeFileShared         = "/shared/"             // Shares the file content (accessible as link to file).
+	routeTrashList          = "/trash/list"          // Lists deleted files and directories.
+	routeTrashRestore       = "/trash/restore"       // Restores deleted file or directory.
+	routeTrashPurge         = "/trash/purge"         // Permanently deletes trash content.
+	routeBatch              = "/batch"               // Executes multiple operations in single request.
+	routeWatch              = "/watch"               // Streams change notifications.
+	routeJournalRead        = "/journal/read"        // Reads journal of mutating operations.
+	routeSearch             = "/search"              // Searches files by name, attributes and content.
+	routeDirectoryUsage     = "/directory/usage"     // Reports disk usage of directory.
+	routeStats              = "/stats"               // Reports storage statistics.
+	routeQuotaUsage         = "/quota/usage"         // Reports usage of storage quotas.
+	routeReplicationStatus  = "/replication/status"  // Reports replication role and lag.
+	routeReplicationPromote = "/replication/promote" // Promotes replica to primary.
+	routeRateLimitStatus    = "/ratelimit/status"    // Reports state of rate limits.
+	routeConfigReload       = "/config/reload"       // Reloads configuration.
+	routeMetrics            = "/metrics"             // Reports metrics in Prometheus format.
+	routeHealth             = "/health"              // Checks if the server process is alive.
+	routeReady              = "/ready"               // Checks if the server is ready to process requests.
+	routeVersion            = "/version"             // Reports server version.
+	HttpGET                 = "GET"                  // HTTP get method.
+	HttpPOST                = "POST"                 // HTTP post method.
+	HttpPUT                 = "PUT"                  // HTTP put method.
+	HttpDELETE              = "DELETE"               // HTTP delete method.
+	HttpOPTIONS             = "OPTIONS"              // HTTP options method.
 )
 
 // Handler defines custom type for declaring request handlers.
@@ -39,18 +63,48 @@ type Handler func(w http.ResponseWriter, req *http.Request)
 type RouteHandler func(cfg *Configuration, w http.ResponseWriter, req *http.Request)
 
 // requiredNameParam searches for required parameter named 'name' and validates
-// the value against file and directory naming rules.
-func requiredNameParam(w http.ResponseWriter, req *http.Request) (string, bool) {
+// the value against file and directory naming rules. Names pointing to the
+// state directory of the server are rejected.
+func requiredNameParam(cfg *Configuration, w http.ResponseWriter, req *http.Request) (string, bool) {
 	if name, ok := requiredSingleParam(w, req, "name"); ok {
-		if !strings.HasPrefix(name, "/") {
-			writeResultError(w, errorDto(errNoSlashInFileOrDirectoryName, name))
-			return "", false
+		return validName(cfg, w, name)
+	}
+	return "", false
+}
+
+// optionalNameParam searches for optional parameter with specified name, and when present,
+// validates the value against file and directory naming rules.
+func optionalNameParam(cfg *Configuration, w http.ResponseWriter, req *http.Request, paramName string) (string, bool) {
+	if name, ok := optionalSingleParam(w, req, paramName, ""); ok {
+		if name == "" {
+			return "", true
 		}
-		return name, true
+		return validName(cfg, w, name)
 	}
 	return "", false
 }
 
+// validName validates file or directory name, see checkName for details.
+func validName(cfg *Configuration, w http.ResponseWriter, name string) (string, bool) {
+	if errorDto := checkName(cfg, name); errorDto != nil {
+		writeResultError(w, errorDto)
+		return "", false
+	}
+	return name, true
+}
+
+// checkName checks file or directory name. Name must begin with slash
+// and may not point to the state directory of the server.
+func checkName(cfg *Configuration, name string) *ErrorDto {
+	if !strings.HasPrefix(name, "/") {
+		return errorDto(errNoSlashInFileOrDirectoryName, name)
+	}
+	if cfg.isStatePath(prepareAbsolutePath(cfg, name)) {
+		return errorDto(errReservedName, name)
+	}
+	return nil
+}
+
 func requiredIntParam(w http.ResponseWriter, req *http.Request, name string) (int64, bool) {
 	if strValue, ok := requiredSingleParam(w, req, name); !ok {
 		return 0, false
@@ -64,6 +118,20 @@ func requiredIntParam(w http.ResponseWriter, req *http.Request, name string) (in
 	}
 }
 
+// optionalIntParam searches for optional integer parameter with specified name.
+func optionalIntParam(w http.ResponseWriter, req *http.Request, name string, defaultValue int64) (int64, bool) {
+	if strValue, ok := optionalSingleParam(w, req, name, ""); !ok {
+		return 0, false
+	} else if strValue == "" {
+		return defaultValue, true
+	} else if value, err := strconv.ParseInt(strValue, 10, 64); err != nil {
+		writeResultError(w, errorDto(errRequiredParameterIsNotAnInteger, name))
+		return 0, false
+	} else {
+		return value, true
+	}
+}
+
 // requiredSingleParam searches for a parameter with specified name.
 // Parameter with this name should be present and may not be given more than once.
 func requiredSingleParam(w http.ResponseWriter, req *http.Request, name string) (string, bool) {
@@ -102,6 +170,21 @@ func optionalSingleParam(w http.ResponseWriter, req *http.Request, name string,
 	return value, true
 }
 
+// optionalListParam collects all values of the parameter with specified name.
+// Parameter may be given more than once and each value may contain
+// a comma separated list of items. Empty items are omitted.
+func optionalListParam(req *http.Request, name string) []string {
+	values := make([]string, 0)
+	for _, value := range req.URL.Query()[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
 // writeResultFile is a helper method for returning file DTO to caller with status 200.
 func writeResultFile(w http.ResponseWriter, file *File) {
 	writeResultData(w, FileDto{Data: file})
@@ -117,14 +200,14 @@ func writeResultDirectory(w http.ResponseWriter, directory *Directory) {
 func writeResultData(w http.ResponseWriter, data interface{}) {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		logFatal(err)
 	}
 	dataLength := len(jsonData)
 	w.WriteHeader(200)
 	w.Header().Set("Content-Type", "application/vnd.api+json")
 	count, err := w.Write(jsonData)
 	if err != nil || count != dataLength {
-		log.Fatal(err)
+		logFatal(err)
 	}
 }
 
@@ -133,21 +216,24 @@ func writeResultData(w http.ResponseWriter, data interface{}) {
 //  converted to JSON body and returned to caller. HTTP status is set according
 // status code defined in error.
 func writeResultError(w http.ResponseWriter, errorDto *ErrorDto) {
+	if current := trackedTransfer(w); current != nil {
+		current.errorCode = errorDto.Code
+	}
 	errorsDto := errorsDto(errorDto)
 	jsonData, err := json.MarshalIndent(errorsDto, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		logFatal(err)
 	}
 	statusCode, err := strconv.Atoi(errorDto.Status)
 	if err != nil {
-		log.Fatal(err)
+		logFatal(err)
 	}
 	dataLength := len(jsonData)
 	w.WriteHeader(statusCode)
 	w.Header().Set("Content-Type", "application/vnd.api+json")
 	count, err := w.Write(jsonData)
 	if err != nil || count != dataLength {
-		log.Fatal(err)
+		logFatal(err)
 	}
 }
 
@@ -155,17 +241,36 @@ func writeResultError(w http.ResponseWriter, errorDto *ErrorDto) {
 // HTTP method as defined in parameter. If current request method differs
 // from the one passed as an argument, then error is returned to caller.
 // Only requests with configured HTTP method are further processed.
-// Aditionally CORS is enabled and OPTIONS preflight is supported.
+// Aditionally CORS headers are set as configured and OPTIONS preflight is supported.
 func httpHandler(cfg *Configuration, method string, handler RouteHandler) Handler {
 	return func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if req.Method == HttpOPTIONS {
+		cfg := cfg.current()
+		if !applyCors(cfg, method, w, req) {
+			return
+		}
+		// the server process is alive while shutting down, so that it is not killed before requests complete
+		if routeOf(cfg, req) != routeHealth && !refuseDraining(cfg, w) {
+			return
+		}
+		current, w, req := cfg.transfers.begin(cfg, w, req)
+		defer cfg.transfers.end(cfg, current)
+		principal := authenticate(cfg, req)
+		req = withPrincipal(req, principal)
+		current.principal = principal
+		// requests with invalid or missing credentials are limited by client address,
+		// so that keys can not be guessed faster than the rate limit allows
+		if !limitRate(cfg, w, req) {
+			return
+		}
+		if principal == "" && cfg.Auth.Required && !cfg.Auth.exempt(current.route) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeResultError(w, errorDto(errUnauthorized, req.URL.Path))
 			return
 		}
 		if req.Method == method {
-			handler(cfg, w, req)
+			if w, req, ok := limitTransfer(cfg, w, req); ok {
+				handler(cfg, w, req)
+			}
 		} else {
 			writeResultError(w, errorDto(errRequestMethodNotSupported, req.Method))
 		}
@@ -174,41 +279,184 @@ func httpHandler(cfg *Configuration, method string, handler RouteHandler) Handle
 
 // StartServer starts the file server.
 func StartServer(cfg *Configuration) *http.Server {
+	if err := configureLogging(cfg); err != nil {
+		logFatal(err)
+	}
+	// create event hub and caches before any request can be handled
+	cfg.checksums = newChecksumCache(cfg)
+	cfg.metrics = newMetrics()
+	cfg.events = newEventHub(cfg.Watch.History, cfg.Watch.pollInterval()+time.Second)
+	cfg.events.listen(cfg.checksums.update)
+	if cfg.Journal.Enabled {
+		var err error
+		if cfg.journal, err = openJournal(cfg); err != nil {
+			logFatal(err)
+		}
+		cfg.events.record(cfg.journal.record)
+	}
+	// replica rejects changes from the very first request
+	if cfg.Replication.Primary != "" {
+		var err error
+		if cfg.replication, err = startReplication(cfg); err != nil {
+			logFatal(err)
+		}
+	}
+	cfg.usage = newUsageCache(cfg)
+	cfg.rateLimits = newRateLimiter(cfg)
+	cfg.transfers = newTransferTracker()
+	cfg.bandwidths = newPrincipalBandwidths()
+	var stopQuotas func()
+	if len(cfg.Quotas) > 0 {
+		var err error
+		if cfg.quotas, stopQuotas, err = startQuotas(cfg); err != nil {
+			logFatal(err)
+		}
+	}
+	var stopIndex func()
+	if cfg.Search.Index {
+		cfg.search, stopIndex = startSearchIndex(cfg)
+	}
+	var certificates *certificateStore
+	if cfg.Tls.CertFile != "" {
+		var err error
+		if certificates, err = newCertificateStore(cfg); err != nil {
+			logFatal(err)
+		}
+	}
+	// requests use the configuration current when received, replaced when reloaded
+	if cfg.live == nil {
+		cfg.live = &liveConfiguration{}
+	}
+	cfg.live.current.Store(cfg)
 	// configure all routes (with prefixes)
 	prefix := cfg.UrlPrefix
 	mux := http.NewServeMux()
 	mux.HandleFunc(prefix+routeDirectoryRead, httpHandler(cfg, HttpGET, handlerDirectoryRead))
 	mux.HandleFunc(prefix+routeDirectoryTree, httpHandler(cfg, HttpGET, handlerDirectoryTree))
 	mux.HandleFunc(prefix+routeDirectoryList, httpHandler(cfg, HttpGET, handlerDirectoryList))
-	mux.HandleFunc(prefix+routeDirectoryCreate, httpHandler(cfg, HttpPOST, handlerDirectoryCreate))
-	mux.HandleFunc(prefix+routeDirectoryDelete, httpHandler(cfg, HttpDELETE, handlerDirectoryDelete))
+	mux.HandleFunc(prefix+routeDirectoryCreate, httpHandler(cfg, HttpPOST, writable(handlerDirectoryCreate)))
+	mux.HandleFunc(prefix+routeDirectoryDelete, httpHandler(cfg, HttpDELETE, writable(handlerDirectoryDelete)))
+	mux.HandleFunc(prefix+routeDirectoryArchive, httpHandler(cfg, HttpGET, handlerDirectoryArchive))
+	mux.HandleFunc(prefix+routeDirectoryExtract, httpHandler(cfg, HttpPOST, writable(handlerDirectoryExtract)))
+	mux.HandleFunc(prefix+routeDirectoryManifest, httpHandler(cfg, HttpGET, handlerDirectoryManifest))
+	mux.HandleFunc(prefix+routeDirectoryDiff, httpHandler(cfg, HttpPOST, handlerDirectoryDiff))
 	mux.HandleFunc(prefix+routeFileRead, httpHandler(cfg, HttpGET, handlerFileRead))
-	mux.HandleFunc(prefix+routeFileWrite, httpHandler(cfg, HttpPOST, handlerFileWrite))
-	mux.HandleFunc(prefix+routeFileAppend, httpHandler(cfg, HttpPUT, handlerFileAppend))
-	mux.HandleFunc(prefix+routeFileDelete, httpHandler(cfg, HttpDELETE, handlerFileDelete))
+	mux.HandleFunc(prefix+routeFileWrite, httpHandler(cfg, HttpPOST, writable(handlerFileWrite)))
+	mux.HandleFunc(prefix+routeFileAppend, httpHandler(cfg, HttpPUT, writable(handlerFileAppend)))
+	mux.HandleFunc(prefix+routeFileDelete, httpHandler(cfg, HttpDELETE, writable(handlerFileDelete)))
 	mux.HandleFunc(prefix+routeFileExists, httpHandler(cfg, HttpGET, handlerFileExists))
 	mux.HandleFunc(prefix+routeFileChecksum, httpHandler(cfg, HttpGET, handlerFileChecksum))
+	mux.HandleFunc(prefix+routeFileVersions, httpHandler(cfg, HttpGET, handlerFileVersions))
+	mux.HandleFunc(prefix+routeFileRestore, httpHandler(cfg, HttpPOST, writable(handlerFileRestore)))
 	mux.HandleFunc(prefix+routeFileShared, httpHandler(cfg, HttpGET, handlerFileShared))
+	mux.HandleFunc(prefix+routeTrashList, httpHandler(cfg, HttpGET, handlerTrashList))
+	mux.HandleFunc(prefix+routeTrashRestore, httpHandler(cfg, HttpPOST, writable(handlerTrashRestore)))
+	mux.HandleFunc(prefix+routeTrashPurge, httpHandler(cfg, HttpDELETE, admin(writable(handlerTrashPurge))))
+	mux.HandleFunc(prefix+routeBatch, httpHandler(cfg, HttpPOST, writable(handlerBatch)))
+	mux.HandleFunc(prefix+routeWatch, httpHandler(cfg, HttpGET, handlerWatch))
+	mux.HandleFunc(prefix+routeJournalRead, httpHandler(cfg, HttpGET, admin(handlerJournalRead)))
+	mux.HandleFunc(prefix+routeDirectoryUsage, httpHandler(cfg, HttpGET, handlerDirectoryUsage))
+	mux.HandleFunc(prefix+routeStats, httpHandler(cfg, HttpGET, handlerStats))
+	mux.HandleFunc(prefix+routeQuotaUsage, httpHandler(cfg, HttpGET, handlerQuotaUsage))
+	mux.HandleFunc(prefix+routeSearch, httpHandler(cfg, HttpGET, handlerSearch))
+	mux.HandleFunc(prefix+routeReplicationStatus, httpHandler(cfg, HttpGET, handlerReplicationStatus))
+	mux.HandleFunc(prefix+routeReplicationPromote, httpHandler(cfg, HttpPOST, admin(handlerReplicationPromote)))
+	mux.HandleFunc(prefix+routeRateLimitStatus, httpHandler(cfg, HttpGET, handlerRateLimitStatus))
+	mux.HandleFunc(prefix+routeConfigReload, httpHandler(cfg, HttpPOST, admin(handlerConfigReload)))
+	mux.HandleFunc(prefix+routeMetrics, httpHandler(cfg, HttpGET, handlerMetrics))
+	mux.HandleFunc(prefix+routeHealth, httpHandler(cfg, HttpGET, handlerHealth))
+	mux.HandleFunc(prefix+routeReady, httpHandler(cfg, HttpGET, handlerReady))
+	mux.HandleFunc(prefix+routeVersion, httpHandler(cfg, HttpGET, handlerVersion))
 	// display configuration summary
 	cfg.DisplaySummary()
 	// start the server
-	httpServer := &http.Server{Addr: fmt.Sprintf(":%d", cfg.ServerPort), Handler: mux}
-	go func() {
-		if err := httpServer.ListenAndServe(); err != nil {
-			errMsg := strings.ToLower(err.Error())
-			if !strings.Contains(errMsg, "server") && !strings.Contains(errMsg, "closed") {
-				log.Fatal(err)
+	listeners, err := openListeners(cfg)
+	if err != nil {
+		logFatal(err)
+	}
+	httpServer := &http.Server{
+		Handler:           mux,
+		ConnContext:       connectionContext(cfg),
+		ReadHeaderTimeout: time.Duration(cfg.Timeouts.ReadHeader) * time.Second,
+		ReadTimeout:       time.Duration(cfg.Timeouts.Read) * time.Second,
+		WriteTimeout:      time.Duration(cfg.Timeouts.Write) * time.Second,
+		IdleTimeout:       time.Duration(cfg.Timeouts.Idle) * time.Second,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if certificates != nil {
+		httpServer.TLSConfig = certificates.tlsConfig()
+		httpServer.RegisterOnShutdown(certificates.watch())
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			var err error
+			if certificates != nil {
+				err = httpServer.ServeTLS(listener, "", "")
+			} else {
+				err = httpServer.Serve(listener)
+			}
+			if err != nil {
+				errMsg := strings.ToLower(err.Error())
+				if !strings.Contains(errMsg, "server") && !strings.Contains(errMsg, "closed") {
+					logFatal(err)
+				}
 			}
+		}(listener)
+	}
+	// start background workers, they are stopped when the server shuts down
+	if len(cfg.Versioning.Directories) > 0 {
+		httpServer.RegisterOnShutdown(startVersionsPruner(cfg))
+	}
+	if cfg.Trash.Enabled {
+		httpServer.RegisterOnShutdown(startTrashExpiry(cfg))
+	}
+	if cfg.Watch.FileSystem {
+		httpServer.RegisterOnShutdown(startWatching(cfg))
+	}
+	if stopIndex != nil {
+		httpServer.RegisterOnShutdown(stopIndex)
+	}
+	if stopQuotas != nil {
+		httpServer.RegisterOnShutdown(stopQuotas)
+	}
+	if cfg.replication != nil {
+		httpServer.RegisterOnShutdown(cfg.replication.stop)
+	}
+	// changes made by requests completed during shutdown are still published, journaled
+	// and queued for webhooks, so these are stopped only when the server has shut down
+	cfg.closers = append(cfg.closers, cfg.events.close)
+	if len(cfg.Webhooks.Subscriptions) > 0 {
+		if stop, err := startWebhooks(cfg); err == nil {
+			cfg.closers = append(cfg.closers, stop)
+		} else {
+			logFatal(err)
 		}
-	}()
+	}
+	if cfg.journal != nil {
+		cfg.closers = append(cfg.closers, cfg.journal.close)
+	}
 	return httpServer
 }
 
-// StopServer gracefully stops the server.
-func StopServer(httpServer *http.Server) {
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+// StopServer gracefully stops the server. New requests are refused, and requests being
+// processed are given configured time to complete. Requests still being processed
+// when the time is over are logged and their connections are closed.
+func StopServer(cfg *Configuration, httpServer *http.Server) {
+	cfg = cfg.current()
+	cfg.transfers.draining.Store(true)
+	if count := len(cfg.transfers.list()); count > 0 {
+		slog.Info("waiting for transfers to complete", slog.Int("count", count))
+	}
+	timeout := time.Duration(valueOrDefault(cfg.Timeouts.Shutdown, defaultShutdownTimeout)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	if err := httpServer.Shutdown(ctx); err != nil {
-		log.Fatal(err)
+		logAbortedTransfers(cfg)
+		if err = httpServer.Close(); err != nil {
+			logError(err)
+		}
+	}
+	for _, closer := range cfg.closers {
+		closer()
 	}
 }
@@ -0,0 +1,42 @@
+package server
+
+import "time"
+
+// StorageStats describes the file system holding the root directory and the stored content.
+type StorageStats struct {
+	Total       uint64    `json:"total"        api:"Total size of the file system in bytes."`
+	Used        uint64    `json:"used"         api:"Used space in bytes."`
+	Free        uint64    `json:"free"         api:"Free space in bytes."`
+	Available   uint64    `json:"available"    api:"Free space available to unprivileged users in bytes."`
+	Inodes      uint64    `json:"inodes"       api:"Total number of inodes."`
+	InodesUsed  uint64    `json:"inodesUsed"   api:"Number of used inodes."`
+	InodesFree  uint64    `json:"inodesFree"   api:"Number of free inodes."`
+	ContentSize int64     `json:"contentSize"  api:"Total size of files stored in root directory in bytes."`
+	Files       int64     `json:"files"        api:"Number of files stored in root directory."`
+	Directories int64     `json:"directories"  api:"Number of directories stored in root directory."`
+	Updated     time.Time `json:"updated"      api:"The time when the stored content was read from disk."`
+}
+
+// StorageStatsDto is the implementation of DTO for storage statistics.
+type StorageStatsDto struct {
+	Data *StorageStats `json:"data"  api:"Storage statistics."`
+}
+
+// storageStats reports the space and inode usage of the file system holding the root directory,
+// together with the usage of the root directory.
+func storageStats(cfg *Configuration) (*StorageStats, *ErrorDto) {
+	stats, err := fileSystemStats(cfg.RootDirectory)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReadingStatsFailed, errMsgCheckServerLogForDetails)
+	}
+	usage, errorDto := directoryUsage(cfg, "/", 0)
+	if errorDto != nil {
+		return nil, errorDto
+	}
+	stats.ContentSize = usage.Size
+	stats.Files = usage.Files
+	stats.Directories = usage.Directories
+	stats.Updated = usage.Updated
+	return stats, nil
+}
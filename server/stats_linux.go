@@ -0,0 +1,35 @@
+//go:build linux
+
+package server
+
+import "syscall"
+
+// fileSystemStats reads space and inode usage of the file system holding specified directory.
+func fileSystemStats(fullName string) (*StorageStats, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(fullName, &statfs); err != nil {
+		return nil, err
+	}
+	blockSize := uint64(statfs.Bsize)
+	return &StorageStats{
+		Total:      statfs.Blocks * blockSize,
+		Used:       (statfs.Blocks - statfs.Bfree) * blockSize,
+		Free:       statfs.Bfree * blockSize,
+		Available:  statfs.Bavail * blockSize,
+		Inodes:     statfs.Files,
+		InodesUsed: statfs.Files - statfs.Ffree,
+		InodesFree: statfs.Ffree,
+	}, nil
+}
+
+// sameFileSystem returns true when both files are on the same device, so one can be renamed to the other.
+func sameFileSystem(first string, second string) (bool, error) {
+	var firstStat, secondStat syscall.Stat_t
+	if err := syscall.Stat(first, &firstStat); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(second, &secondStat); err != nil {
+		return false, err
+	}
+	return firstStat.Dev == secondStat.Dev, nil
+}
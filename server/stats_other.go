@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// fileSystemStats reports that file system statistics are not supported on this platform.
+func fileSystemStats(_ string) (*StorageStats, error) {
+	return nil, errors.New("file system statistics not supported on this platform")
+}
+
+// sameFileSystem reports that devices of files are not compared on this platform.
+func sameFileSystem(_ string, _ string) (bool, error) {
+	return true, nil
+}
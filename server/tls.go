@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultTlsReloadInterval = 10 // Default interval in seconds between checks of rotated certificates.
+
+const (
+	clientAuthNone     = ""         // Client certificates are not requested.
+	clientAuthOptional = "optional" // Client certificates are verified when sent.
+	clientAuthRequired = "required" // Client certificates are required and verified.
+)
+
+// certificateStore holds the server certificate and the bundle of client certificate authorities,
+// both reloaded when the files change, so rotated certificates are used without restart.
+type certificateStore struct {
+	cfg         *Configuration
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCas   *x509.CertPool
+	modified    map[string]time.Time // Modification times of loaded files by name, used only by the reloading goroutine.
+}
+
+// newCertificateStore loads the certificate, the key and the client certificate authorities.
+func newCertificateStore(cfg *Configuration) (*certificateStore, error) {
+	switch cfg.Tls.ClientAuth {
+	case clientAuthNone:
+	case clientAuthOptional, clientAuthRequired:
+		if cfg.Tls.ClientCaFile == "" {
+			return nil, errors.New("client certificate verification requires client CA bundle")
+		}
+	default:
+		return nil, errors.New("invalid client authentication mode: " + cfg.Tls.ClientAuth)
+	}
+	store := &certificateStore{cfg: cfg}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// files returns names of all files loaded by the store.
+func (s *certificateStore) files() []string {
+	files := []string{s.cfg.Tls.CertFile, s.cfg.Tls.KeyFile}
+	if s.cfg.Tls.ClientCaFile != "" {
+		files = append(files, s.cfg.Tls.ClientCaFile)
+	}
+	return files
+}
+
+// changed returns true when any of the loaded files was modified since it was loaded.
+func (s *certificateStore) changed() bool {
+	for _, name := range s.files() {
+		if info, err := os.Stat(name); err == nil && !info.ModTime().Equal(s.modified[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads all files and replaces the certificate and client certificate authorities.
+// Nothing is replaced when any of the files is invalid, but modification times are
+// remembered, so invalid files are not loaded again until they change.
+func (s *certificateStore) load() error {
+	modified := make(map[string]time.Time)
+	for _, name := range s.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modified[name] = info.ModTime()
+	}
+	s.modified = modified
+	certificate, err := tls.LoadX509KeyPair(s.cfg.Tls.CertFile, s.cfg.Tls.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCas *x509.CertPool
+	if s.cfg.Tls.ClientCaFile != "" {
+		data, err := os.ReadFile(s.cfg.Tls.ClientCaFile)
+		if err != nil {
+			return err
+		}
+		clientCas = x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(data) {
+			return errors.New("no certificates found in client CA bundle " + s.cfg.Tls.ClientCaFile)
+		}
+	}
+	s.mutex.Lock()
+	s.certificate, s.clientCas = &certificate, clientCas
+	s.mutex.Unlock()
+	return nil
+}
+
+// tlsConfig creates TLS configuration using the current certificate and client certificate
+// authorities for every new connection.
+func (s *certificateStore) tlsConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	switch s.cfg.Tls.ClientAuth {
+	case clientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequired:
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ClientAuth: clientAuth}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		current := config.Clone()
+		current.GetConfigForClient = nil
+		current.Certificates = []tls.Certificate{*s.certificate}
+		current.ClientCAs = s.clientCas
+		return current, nil
+	}
+	return config
+}
+
+// watch reloads rotated certificates in background, until the returned stop function is called.
+// When the new files are invalid, the error is logged and the previous certificates are kept.
+func (s *certificateStore) watch() func() {
+	done := make(chan struct{})
+	go func() {
+		interval := time.Duration(valueOrDefault(s.cfg.Tls.ReloadInterval, defaultTlsReloadInterval)) * time.Second
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.changed() {
+					if err := s.load(); err != nil {
+						logError(err)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// certificatePrincipal returns the principal mapped to the subject of verified client certificate.
+// Subjects are matched by the full distinguished name or by the common name. When no mapping
+// matches and common names are accepted, the common name itself is the principal.
+func certificatePrincipal(cfg *Configuration, req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	subject := req.TLS.VerifiedChains[0][0].Subject
+	distinguishedName := subject.String()
+	for _, mapping := range cfg.Tls.Subjects {
+		if mapping.Subject != "" && (mapping.Subject == distinguishedName || mapping.Subject == subject.CommonName) {
+			return mapping.Principal
+		}
+	}
+	if cfg.Tls.CommonNamePrincipal {
+		return subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlParser parses TOML documents: tables, arrays of tables, dotted keys, strings, integers,
+// floats, booleans, arrays and inline tables. Dates and times are read as strings.
+type tomlParser struct {
+	text     string
+	position int
+	root     map[string]interface{}
+	current  map[string]interface{} // Table receiving key/value pairs.
+	defined  map[string]bool        // Tables defined by headers, headers may not be repeated.
+}
+
+// parseToml parses TOML document into tree of maps, lists and scalars.
+func parseToml(document string) (interface{}, error) {
+	root := make(map[string]interface{})
+	p := &tomlParser{text: strings.ReplaceAll(document, "\r\n", "\n"), root: root, current: root, defined: make(map[string]bool)}
+	for {
+		p.skip(true)
+		if p.position >= len(p.text) {
+			return root, nil
+		}
+		var err error
+		if p.text[p.position] == '[' {
+			err = p.header()
+		} else {
+			err = p.keyValue(p.current)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// failure creates error reporting the problem at the current position.
+func (p *tomlParser) failure(format string, args ...interface{}) error {
+	line := strings.Count(p.text[:min(p.position, len(p.text))], "\n") + 1
+	return fmt.Errorf("toml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip skips spaces and comments, and new lines when allowed.
+func (p *tomlParser) skip(newLines bool) {
+	for p.position < len(p.text) {
+		switch p.text[p.position] {
+		case ' ', '\t':
+			p.position++
+		case '\n':
+			if !newLines {
+				return
+			}
+			p.position++
+		case '#':
+			for p.position < len(p.text) && p.text[p.position] != '\n' {
+				p.position++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine checks that nothing but comment follows on the current line.
+func (p *tomlParser) endOfLine() error {
+	p.skip(false)
+	if p.position < len(p.text) && p.text[p.position] != '\n' {
+		return p.failure("unexpected '%c'", p.text[p.position])
+	}
+	return nil
+}
+
+// header parses table header '[a.b]' or array of tables header '[[a.b]]'.
+func (p *tomlParser) header() error {
+	array := strings.HasPrefix(p.text[p.position:], "[[")
+	if array {
+		p.position += 2
+	} else {
+		p.position++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	p.skip(false)
+	if !strings.HasPrefix(p.text[p.position:], closing) {
+		return p.failure("expected '%s'", closing)
+	}
+	p.position += len(closing)
+	parent, err := p.table(p.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if array {
+		tables, _ := parent[last].([]interface{})
+		if existing, ok := parent[last]; ok && tables == nil {
+			return p.failure("key '%s' is not an array of tables (%T)", last, existing)
+		}
+		p.current = make(map[string]interface{})
+		parent[last] = append(tables, p.current)
+		return nil
+	}
+	name := strings.Join(keys, ".")
+	if p.defined[name] {
+		return p.failure("table '%s' defined more than once", name)
+	}
+	p.defined[name] = true
+	p.current, err = p.table(parent, []string{last})
+	return err
+}
+
+// table returns the table with specified path, tables are created when missing,
+// for arrays of tables the last table is used.
+func (p *tomlParser) table(parent map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch value := parent[key].(type) {
+		case nil:
+			table := make(map[string]interface{})
+			parent[key] = table
+			parent = table
+		case map[string]interface{}:
+			parent = value
+		case []interface{}:
+			table, ok := value[len(value)-1].(map[string]interface{})
+			if !ok {
+				return nil, p.failure("key '%s' is not a table", key)
+			}
+			parent = table
+		default:
+			return nil, p.failure("key '%s' is not a table", key)
+		}
+	}
+	return parent, nil
+}
+
+// key parses bare, quoted or dotted key.
+func (p *tomlParser) key() ([]string, error) {
+	keys := make([]string, 0, 1)
+	for {
+		p.skip(false)
+		if p.position >= len(p.text) {
+			return nil, p.failure("expected key")
+		}
+		switch c := p.text[p.position]; {
+		case c == '"' || c == '\'':
+			key, err := p.string()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		default:
+			start := p.position
+			for p.position < len(p.text) && isBareKeyChar(p.text[p.position]) {
+				p.position++
+			}
+			if start == p.position {
+				return nil, p.failure("expected key")
+			}
+			keys = append(keys, p.text[start:p.position])
+		}
+		p.skip(false)
+		if p.position >= len(p.text) || p.text[p.position] != '.' {
+			return keys, nil
+		}
+		p.position++
+	}
+}
+
+// isBareKeyChar returns true for characters allowed in bare keys.
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keyValue parses 'key = value' pair and stores the value in the table.
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if p.position >= len(p.text) || p.text[p.position] != '=' {
+		return p.failure("expected '=' after key")
+	}
+	p.position++
+	p.skip(false)
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	if table, err = p.table(table, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := table[last]; exists {
+		return p.failure("key '%s' defined more than once", last)
+	}
+	table[last] = value
+	return nil
+}
+
+// value parses string, number, boolean, array or inline table.
+func (p *tomlParser) value() (interface{}, error) {
+	if p.position >= len(p.text) {
+		return nil, p.failure("expected value")
+	}
+	switch c := p.text[p.position]; c {
+	case '"', '\'':
+		return p.string()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+	start := p.position
+	for p.position < len(p.text) && strings.IndexByte(" \t\n#,]}", p.text[p.position]) < 0 {
+		p.position++
+	}
+	token := p.text[start:p.position]
+	switch token {
+	case "":
+		return nil, p.failure("expected value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	if value, err := strconv.ParseInt(token, 0, 64); err == nil {
+		if len(strings.TrimLeft(token, "+-")) > 1 && strings.TrimLeft(token, "+-")[0] == '0' && !strings.ContainsAny(token[1:2], "xob") {
+			return nil, p.failure("leading zeros are not allowed (%s)", token)
+		}
+		return value, nil
+	}
+	if !strings.HasPrefix(token, "0x") && strings.ContainsAny(token, ".eE") {
+		if value, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64); err == nil {
+			return value, nil
+		}
+	}
+	if strings.ContainsAny(token, "-:") && token[0] >= '0' && token[0] <= '9' {
+		// local or offset date and time, read including time following the space
+		if p.position+1 < len(p.text) && p.text[p.position] == ' ' && p.text[p.position+1] >= '0' && p.text[p.position+1] <= '9' {
+			p.position++
+			for p.position < len(p.text) && strings.IndexByte(" \t\n#,]}", p.text[p.position]) < 0 {
+				p.position++
+			}
+		}
+		return p.text[start:p.position], nil
+	}
+	return nil, p.failure("invalid value '%s'", token)
+}
+
+// string parses basic, literal and multi-line strings.
+func (p *tomlParser) string() (string, error) {
+	quote := p.text[p.position]
+	multiLine := strings.HasPrefix(p.text[p.position:], strings.Repeat(string(quote), 3))
+	delimiter := string(quote)
+	if multiLine {
+		delimiter = strings.Repeat(delimiter, 3)
+		p.position += 3
+		// the new line immediately following the opening delimiter is trimmed
+		if p.position < len(p.text) && p.text[p.position] == '\n' {
+			p.position++
+		}
+	} else {
+		p.position++
+	}
+	var builder strings.Builder
+	for {
+		if p.position >= len(p.text) || (!multiLine && p.text[p.position] == '\n') {
+			return "", p.failure("unterminated string")
+		}
+		if strings.HasPrefix(p.text[p.position:], delimiter) {
+			p.position += len(delimiter)
+			return builder.String(), nil
+		}
+		c := p.text[p.position]
+		if c != '\\' || quote == '\'' {
+			builder.WriteByte(c)
+			p.position++
+			continue
+		}
+		p.position++
+		if p.position >= len(p.text) {
+			return "", p.failure("unterminated string")
+		}
+		escape := p.text[p.position]
+		p.position++
+		switch escape {
+		case 'b':
+			builder.WriteByte('\b')
+		case 't':
+			builder.WriteByte('\t')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'r':
+			builder.WriteByte('\r')
+		case '"', '\\':
+			builder.WriteByte(escape)
+		case 'u', 'U':
+			size := 4
+			if escape == 'U' {
+				size = 8
+			}
+			if p.position+size > len(p.text) {
+				return "", p.failure("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.text[p.position:p.position+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", p.failure("invalid unicode escape")
+			}
+			builder.WriteRune(rune(code))
+			p.position += size
+		case ' ', '\t', '\n':
+			if !multiLine {
+				return "", p.failure("invalid escape sequence")
+			}
+			// line ending backslash trims all whitespace up to the next non-whitespace character
+			p.position--
+			for p.position < len(p.text) && strings.IndexByte(" \t\n", p.text[p.position]) >= 0 {
+				p.position++
+			}
+		default:
+			return "", p.failure("invalid escape sequence '\\%c'", escape)
+		}
+	}
+}
+
+// array parses array, values may be placed on more lines.
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.position++
+	result := make([]interface{}, 0)
+	for {
+		p.skip(true)
+		if p.position < len(p.text) && p.text[p.position] == ']' {
+			p.position++
+			return result, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+		p.skip(true)
+		if p.position < len(p.text) && p.text[p.position] == ',' {
+			p.position++
+		} else if p.position >= len(p.text) || p.text[p.position] != ']' {
+			return nil, p.failure("expected ',' or ']' in array")
+		}
+	}
+}
+
+// inlineTable parses inline table '{ a = 1, b = 2 }'.
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.position++
+	result := make(map[string]interface{})
+	p.skip(false)
+	if p.position < len(p.text) && p.text[p.position] == '}' {
+		p.position++
+		return result, nil
+	}
+	for {
+		if err := p.keyValue(result); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.position >= len(p.text) {
+			return nil, p.failure("unterminated inline table")
+		}
+		switch p.text[p.position] {
+		case ',':
+			p.position++
+		case '}':
+			p.position++
+			return result, nil
+		default:
+			return nil, p.failure("expected ',' or '}' in inline table")
+		}
+	}
+}
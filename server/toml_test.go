@@ -0,0 +1,51 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	tests := []struct {
+		document string
+		expected interface{}
+	}{
+		{"", map[string]interface{}{}},
+		{"a = 1\nb = 'text'  # comment\n", map[string]interface{}{"a": int64(1), "b": "text"}},
+		{"a = \"x # y\"\nb = true\nc = 1.5\n", map[string]interface{}{"a": "x # y", "b": true, "c": 1.5}},
+		{"a.b = 1\n[c]\nd = [1, 'x']\n", map[string]interface{}{"a": map[string]interface{}{"b": int64(1)}, "c": map[string]interface{}{"d": []interface{}{int64(1), "x"}}}},
+		{"[[a]]\nb = 1\n[[a]]\nb = 2\n", map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": int64(1)}, map[string]interface{}{"b": int64(2)}}}},
+		{"a = {b = 'c', d = 2}\n", map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": int64(2)}}},
+		{"a = 'x''y'\n", nil},
+		{"a = '/data/o\"brien'\n", map[string]interface{}{"a": "/data/o\"brien"}},
+	}
+	for _, test := range tests {
+		actual, err := parseToml(test.document)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected error, actual %#v", test.document, actual)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", test.document, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %#v, actual %#v", test.document, test.expected, actual)
+		}
+	}
+}
+
+func TestParseTomlFailures(t *testing.T) {
+	tests := []struct {
+		document string
+		expected string
+	}{
+		{"[a]\nb = 1\n[a]\nc = 2\n", "line 3"},
+		{"a = 1 b = 2\n", "unexpected 'b'"},
+		{"a = [1, 2\n", "line"},
+	}
+	for _, test := range tests {
+		if _, err := parseToml(test.document); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error containing %q, actual %v", test.document, test.expected, err)
+		}
+	}
+}
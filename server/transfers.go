@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 // Default number of seconds the server waits for in-flight transfers when shutting down.
+
+// transfer describes single request being processed.
+type transfer struct {
+	id        uint64
+	method    string
+	route     string
+	name      string // Value of 'name' parameter, when given.
+	actor     string
+	principal string // Authenticated principal, set before the request is handled.
+	requestId string
+	started   time.Time
+	received  atomic.Int64 // Number of bytes read from request body.
+	sent      atomic.Int64 // Number of bytes written to response body.
+	status    atomic.Int32 // Status of the response, 0 until the response is written.
+	errorCode string       // Code of the error returned to the client, when failed.
+}
+
+// transferTracker tracks requests being processed, so that the server may wait for them
+// when shutting down, and report those aborted when the shutdown deadline is reached.
+type transferTracker struct {
+	mutex    sync.Mutex
+	next     uint64
+	active   map[uint64]*transfer
+	draining atomic.Bool // Flag indicating if the server is shutting down and new requests are refused.
+}
+
+func newTransferTracker() *transferTracker {
+	return &transferTracker{active: make(map[uint64]*transfer)}
+}
+
+// begin starts tracking the request, returned response writer and request body count transferred bytes.
+func (t *transferTracker) begin(cfg *Configuration, w http.ResponseWriter, req *http.Request) (*transfer, http.ResponseWriter, *http.Request) {
+	current := &transfer{
+		method:  req.Method,
+		route:   routeOf(cfg, req),
+		name:    req.URL.Query().Get("name"),
+		actor:   requestActor(req),
+		started: time.Now(),
+	}
+	t.mutex.Lock()
+	t.next++
+	current.id = t.next
+	current.requestId = requestId(req, current.id)
+	t.active[current.id] = current
+	t.mutex.Unlock()
+	w.Header().Set(requestIdHeader, current.requestId)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingReader{ReadCloser: req.Body, transfer: current}
+	}
+	return current, &trackedWriter{ResponseWriter: w, transfer: current}, req
+}
+
+// end stops tracking the request and writes its access log record.
+func (t *transferTracker) end(cfg *Configuration, current *transfer) {
+	t.mutex.Lock()
+	delete(t.active, current.id)
+	t.mutex.Unlock()
+	cfg.metrics.observe(current)
+	logAccess(cfg, current)
+}
+
+// list returns requests being processed, the oldest first.
+func (t *transferTracker) list() []*transfer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	transfers := make([]*transfer, 0, len(t.active))
+	for _, current := range t.active {
+		transfers = append(transfers, current)
+	}
+	sort.Slice(transfers, func(a, b int) bool { return transfers[a].id < transfers[b].id })
+	return transfers
+}
+
+// countingReader counts bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	transfer *transfer
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	count, err := r.ReadCloser.Read(p)
+	r.transfer.received.Add(int64(count))
+	return count, err
+}
+
+// trackedWriter counts bytes written to the response body and remembers the response status.
+type trackedWriter struct {
+	http.ResponseWriter
+	transfer *transfer
+}
+
+func (w *trackedWriter) WriteHeader(status int) {
+	w.transfer.status.CompareAndSwap(0, int32(status))
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackedWriter) Write(p []byte) (int, error) {
+	w.transfer.status.CompareAndSwap(0, http.StatusOK)
+	count, err := w.ResponseWriter.Write(p)
+	w.transfer.sent.Add(int64(count))
+	return count, err
+}
+
+// trackedTransfer returns the request tracked by the response writer, or by the writer it wraps.
+func trackedTransfer(w http.ResponseWriter) *transfer {
+	for {
+		switch writer := w.(type) {
+		case *trackedWriter:
+			return writer.transfer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// Unwrap returns the original response writer, used by http.ResponseController.
+func (w *trackedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends buffered data to the client.
+func (w *trackedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, used by web sockets.
+func (w *trackedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// refuseDraining rejects the request with status 503, when the server is shutting down.
+// Returns false when the request was rejected.
+func refuseDraining(cfg *Configuration, w http.ResponseWriter) bool {
+	if cfg.transfers.draining.Load() {
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Retry-After", "1")
+		writeResultError(w, errorDto(errServerDraining, ""))
+		return false
+	}
+	return true
+}
+
+// logAbortedTransfers logs requests still being processed when the shutdown deadline was reached.
+func logAbortedTransfers(cfg *Configuration) {
+	for _, current := range cfg.transfers.list() {
+		slog.Warn("transfer aborted by shutdown",
+			slog.String("requestId", current.requestId),
+			slog.String("method", current.method),
+			slog.String("route", current.route),
+			slog.String("name", current.name),
+			slog.String("client", current.actor),
+			slog.Duration("duration", time.Since(current.started)),
+			slog.Int64("received", current.received.Load()),
+			slog.Int64("sent", current.sent.Load()))
+	}
+}
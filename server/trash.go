@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	trashDirectory         = "trash"                      // Name of the directory for deleted content, created in state directory.
+	trashInfoFileName      = "info.json"                  // Name of the file storing trash item attributes.
+	trashContentName       = "content"                    // Name of the deleted file or directory inside trash item.
+	trashIdLayout          = "20060102T150405.000000000Z" // Layout of trash item identifiers, sortable in chronological order.
+	defaultTrashExpireDays = 30                           // Default number of days the deleted content is kept in trash.
+)
+
+// TrashItem stores attributes of a file or directory moved to trash.
+type TrashItem struct {
+	Id        string    `json:"id"         api:"Trash item identifier."`
+	Name      string    `json:"name"       api:"Original name of deleted file or directory."`
+	Deleted   time.Time `json:"deleted"    api:"The time when the file or directory was deleted."`
+	Directory bool      `json:"directory"  api:"Flag indicating if deleted item is a directory."`
+	Size      int64     `json:"size"       api:"Size of deleted file in bytes, zero for directories."`
+}
+
+// TrashItemDto is the implementation of DTO for single trash item.
+type TrashItemDto struct {
+	Data *TrashItem `json:"data"  api:"Trash item details."`
+}
+
+// TrashItemsDto is the implementation of DTO for trash content.
+type TrashItemsDto struct {
+	Data []*TrashItem `json:"data"  api:"List of trash items, the most recently deleted first."`
+}
+
+// trashPath returns the absolute path of the trash item with specified identifier.
+func trashPath(cfg *Configuration, id string) string {
+	return filepath.Join(cfg.stateDirectory(), trashDirectory, filepath.Base(id))
+}
+
+// moveToTrash moves the file or directory with specified name to trash,
+// recording its original name and the time of deletion.
+func moveToTrash(cfg *Configuration, name string, fileInfo os.FileInfo) (*TrashItem, error) {
+	if err := os.MkdirAll(filepath.Join(cfg.stateDirectory(), trashDirectory), 0755); err != nil {
+		return nil, err
+	}
+	// create the trash item directory, identifiers created in the same instant are retried
+	var item *TrashItem
+	for item == nil {
+		deleted := time.Now().UTC()
+		id := deleted.Format(trashIdLayout)
+		if err := os.Mkdir(trashPath(cfg, id), 0755); err == nil {
+			item = &TrashItem{Id: id, Name: name, Deleted: deleted, Directory: fileInfo.IsDir()}
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+	if !item.Directory {
+		item.Size = fileInfo.Size()
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	itemPath := trashPath(cfg, item.Id)
+	if err = os.WriteFile(filepath.Join(itemPath, trashInfoFileName), data, 0644); err == nil {
+		if err = os.Rename(prepareAbsolutePath(cfg, name), filepath.Join(itemPath, trashContentName)); err == nil {
+			return item, nil
+		}
+	}
+	_ = os.RemoveAll(itemPath)
+	return nil, err
+}
+
+// readTrashItem reads the attributes of trash item with specified identifier.
+func readTrashItem(cfg *Configuration, id string) (*TrashItem, error) {
+	data, err := os.ReadFile(filepath.Join(trashPath(cfg, id), trashInfoFileName))
+	if err != nil {
+		return nil, err
+	}
+	var item TrashItem
+	if err = json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// readTrashItems returns all items in trash, the most recently deleted first.
+func readTrashItems(cfg *Configuration) ([]*TrashItem, error) {
+	items := make([]*TrashItem, 0)
+	entries, err := os.ReadDir(filepath.Join(cfg.stateDirectory(), trashDirectory))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return items, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		item, err := readTrashItem(cfg, entry.Name())
+		if err != nil {
+			logError(err)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Id > items[j].Id })
+	return items, nil
+}
+
+// trashList lists all files and directories in trash.
+func trashList(cfg *Configuration) ([]*TrashItem, *ErrorDto) {
+	items, err := readTrashItems(cfg)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReadingTrashFailed, errMsgCheckServerLogForDetails)
+	}
+	return items, nil
+}
+
+// trashRestore moves the trash item with specified identifier back to its original
+// location, or to the location given as 'name' when not empty. Existing files
+// or directories are never overwritten by restored content. Restored files count
+// against quotas as files created by specified owner.
+func trashRestore(cfg *Configuration, id string, name string, owner string) (*TrashItem, *ErrorDto) {
+	item, err := readTrashItem(cfg, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errorDto(errTrashItemNotFound, id)
+		}
+		logError(err)
+		return nil, errorDto(errReadingTrashFailed, id)
+	}
+	if name == "" {
+		name = item.Name
+	}
+	fullName := prepareAbsolutePath(cfg, name)
+	if _, err := os.Lstat(fullName); err == nil {
+		return nil, errorDto(errRestoreTargetExists, name)
+	}
+	if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+		logError(err)
+		return nil, errorDto(errCreatingDirectoriesFailed, name)
+	}
+	itemPath := trashPath(cfg, id)
+	changes, err := treeChanges(filepath.Join(itemPath, trashContentName), name)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errRestoringTrashItemFailed, id)
+	}
+	reservation, quotaError := cfg.quotas.reserve(changes, owner)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	defer reservation.release()
+	if err := os.Rename(filepath.Join(itemPath, trashContentName), fullName); err != nil {
+		logError(err)
+		return nil, errorDto(errRestoringTrashItemFailed, id)
+	}
+	reservation.commit()
+	if err := os.RemoveAll(itemPath); err != nil {
+		logError(err)
+	}
+	item.Name = name
+	return item, nil
+}
+
+// trashPurge permanently deletes the trash item with specified identifier.
+// When identifier is empty, the whole trash content is deleted.
+func trashPurge(cfg *Configuration, id string) ([]*TrashItem, *ErrorDto) {
+	var items []*TrashItem
+	if id == "" {
+		var err error
+		if items, err = readTrashItems(cfg); err != nil {
+			logError(err)
+			return nil, errorDto(errReadingTrashFailed, errMsgCheckServerLogForDetails)
+		}
+	} else {
+		item, err := readTrashItem(cfg, id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, errorDto(errTrashItemNotFound, id)
+			}
+			logError(err)
+			return nil, errorDto(errReadingTrashFailed, id)
+		}
+		items = []*TrashItem{item}
+	}
+	for _, item := range items {
+		if err := os.RemoveAll(trashPath(cfg, item.Id)); err != nil {
+			logError(err)
+			return nil, errorDto(errPurgingTrashFailed, item.Id)
+		}
+	}
+	return items, nil
+}
+
+// expireTrash permanently deletes trash items older than configured number of days.
+func expireTrash(cfg *Configuration) {
+	expireDays := cfg.Trash.ExpireDays
+	if expireDays == 0 {
+		expireDays = defaultTrashExpireDays
+	}
+	if expireDays < 0 {
+		return
+	}
+	items, err := readTrashItems(cfg)
+	if err != nil {
+		logError(err)
+		return
+	}
+	deadline := time.Now().UTC().AddDate(0, 0, -expireDays)
+	for _, item := range items {
+		if item.Deleted.Before(deadline) {
+			if err := os.RemoveAll(trashPath(cfg, item.Id)); err != nil {
+				logError(err)
+			}
+		}
+	}
+}
+
+// startTrashExpiry runs the trash expiry in background until
+// the returned stop function is called.
+func startTrashExpiry(cfg *Configuration) func() {
+	interval := cfg.Trash.ExpireInterval
+	if interval <= 0 {
+		interval = defaultPruneInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		expireTrash(cfg)
+		for {
+			select {
+			case <-ticker.C:
+				expireTrash(cfg)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
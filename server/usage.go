@@ -0,0 +1,284 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUsageTop      = 10   // Default number of the largest files reported.
+	maxUsageTop          = 1000 // Maximum number of the largest files reported.
+	defaultUsageCacheTtl = 300  // Default number of seconds after which the usage tree is rebuilt from disk.
+)
+
+// UsageFile describes single file reported as one of the largest.
+type UsageFile struct {
+	Name string `json:"name"  api:"Full name of the file."`
+	Size int64  `json:"size"  api:"File size in bytes."`
+}
+
+// UsageChild describes disk usage of single item directly in the directory.
+type UsageChild struct {
+	Name        string `json:"name"                   api:"Name of the file or directory without parent path."`
+	Directory   bool   `json:"directory,omitempty"    api:"Flag indicating if the item is a directory."`
+	Size        int64  `json:"size"                   api:"Total size of files in bytes."`
+	Files       int64  `json:"files"                  api:"Number of files."`
+	Directories int64  `json:"directories,omitempty"  api:"Number of subdirectories."`
+}
+
+// DirectoryUsage describes disk usage of the directory including all subdirectories.
+type DirectoryUsage struct {
+	Name        string        `json:"name"         api:"Name of the directory."`
+	Size        int64         `json:"size"         api:"Total size of files in bytes."`
+	Files       int64         `json:"files"        api:"Number of files."`
+	Directories int64         `json:"directories"  api:"Number of subdirectories."`
+	Largest     []*UsageFile  `json:"largest"      api:"The largest files, ordered by size descending."`
+	Children    []*UsageChild `json:"children"     api:"Usage of items directly in the directory, ordered by size descending."`
+	Updated     time.Time     `json:"updated"      api:"The time when the usage was read from disk, later changes made by tarolas are included."`
+}
+
+// DirectoryUsageDto is the implementation of DTO for directory usage.
+type DirectoryUsageDto struct {
+	Data *DirectoryUsage `json:"data"  api:"Directory usage."`
+}
+
+// usageNode holds sizes of files and subdirectories of single directory.
+type usageNode struct {
+	files       map[string]int64      // File sizes by name.
+	directories map[string]*usageNode // Subdirectories by name.
+}
+
+func newUsageNode() *usageNode {
+	return &usageNode{files: make(map[string]int64), directories: make(map[string]*usageNode)}
+}
+
+// usageCache keeps the tree of file sizes in memory, so that usage can be reported
+// without walking the disk. The tree is read from disk on the first request, updated
+// from change events, and read again when older than configured time, so that changes
+// not reported by events are picked up.
+type usageCache struct {
+	cfg     *Configuration
+	mutex   sync.Mutex
+	root    *usageNode
+	updated time.Time
+}
+
+// newUsageCache creates the usage cache and registers it as event listener.
+func newUsageCache(cfg *Configuration) *usageCache {
+	cache := &usageCache{cfg: cfg}
+	cfg.events.listen(cache.update)
+	return cache
+}
+
+// tree returns the root of the usage tree, reading it from disk when missing or expired.
+// Must be called with the mutex held. The mutex is released while reading from disk,
+// so that publishing events is not blocked, changes made meanwhile may be missed
+// until the tree is read again.
+func (c *usageCache) tree() *usageNode {
+	ttl := time.Duration(valueOrDefault(c.cfg.Usage.CacheTtl, defaultUsageCacheTtl)) * time.Second
+	if c.root == nil || time.Since(c.updated) > ttl {
+		c.mutex.Unlock()
+		updated := time.Now().UTC()
+		root := readUsageTree(c.cfg, c.cfg.RootDirectory)
+		c.mutex.Lock()
+		c.root, c.updated = root, updated
+	}
+	return c.root
+}
+
+// readUsageTree reads sizes of all files in the directory tree from disk.
+func readUsageTree(cfg *Configuration, fullName string) *usageNode {
+	nodes := map[string]*usageNode{fullName: newUsageNode()}
+	_ = filepath.Walk(fullName, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || filePath == fullName {
+			return nil
+		}
+		if cfg.isStatePath(filePath) {
+			return filepath.SkipDir
+		}
+		parent, ok := nodes[filepath.Dir(filePath)]
+		if !ok {
+			return nil
+		}
+		if info.IsDir() {
+			node := newUsageNode()
+			parent.directories[info.Name()] = node
+			nodes[filePath] = node
+		} else if info.Mode().IsRegular() {
+			parent.files[info.Name()] = info.Size()
+		}
+		return nil
+	})
+	return nodes[fullName]
+}
+
+// lookup returns the node of the directory with specified name, when create is true
+// missing directories are added. Must be called with the mutex held.
+func (n *usageNode) lookup(name string, create bool) *usageNode {
+	node := n
+	for _, part := range strings.Split(strings.Trim(path.Clean(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		child, ok := node.directories[part]
+		if !ok {
+			if !create {
+				return nil
+			}
+			child = newUsageNode()
+			node.directories[part] = child
+		}
+		node = child
+	}
+	return node
+}
+
+// update applies the change event to the usage tree, when the tree was already read.
+func (c *usageCache) update(event *Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.root == nil {
+		return
+	}
+	switch event.Type {
+	case EventCreate, EventWrite, EventAppend:
+		c.refresh(event.Name)
+	case EventDelete:
+		if path.Clean(event.Name) == "/" {
+			c.root = nil
+			return
+		}
+		c.detach(event.Name)
+	case EventMove:
+		base := path.Base(event.Target)
+		if size, node := c.detach(event.Name); node != nil {
+			c.root.lookup(path.Dir(event.Target), true).directories[base] = node
+		} else if size >= 0 {
+			c.root.lookup(path.Dir(event.Target), true).files[base] = size
+		} else {
+			c.refresh(event.Target)
+		}
+	}
+}
+
+// refresh reads the size of the file, or sizes of all files in the directory, with specified name.
+// Must be called with the mutex held.
+func (c *usageCache) refresh(name string) {
+	fullName := prepareAbsolutePath(c.cfg, name)
+	info, err := os.Stat(fullName)
+	if err != nil || c.cfg.isStatePath(fullName) || fullName == c.cfg.RootDirectory {
+		return
+	}
+	parent := c.root.lookup(path.Dir(path.Clean(name)), true)
+	if info.IsDir() {
+		parent.directories[info.Name()] = readUsageTree(c.cfg, fullName)
+	} else if info.Mode().IsRegular() {
+		parent.files[info.Name()] = info.Size()
+	}
+}
+
+// detach removes the file or directory with specified name from the tree. Returns the size
+// of removed file or the node of removed directory, size is negative when nothing was removed.
+// Must be called with the mutex held.
+func (c *usageCache) detach(name string) (int64, *usageNode) {
+	parent := c.root.lookup(path.Dir(path.Clean(name)), false)
+	if parent == nil {
+		return -1, nil
+	}
+	base := path.Base(name)
+	if size, ok := parent.files[base]; ok {
+		delete(parent.files, base)
+		return size, nil
+	}
+	if node, ok := parent.directories[base]; ok {
+		delete(parent.directories, base)
+		return 0, node
+	}
+	return -1, nil
+}
+
+// usageCollector accumulates usage of the directory subtree.
+type usageCollector struct {
+	size        int64
+	files       int64
+	directories int64
+	top         int
+	largest     []*UsageFile // The largest files, sorted by size descending.
+}
+
+// collect adds usage of all files and subdirectories of the node.
+func (u *usageCollector) collect(name string, node *usageNode) {
+	for fileName, size := range node.files {
+		u.size += size
+		u.files++
+		if u.top > 0 && (len(u.largest) < u.top || size > u.largest[len(u.largest)-1].Size) {
+			position := sort.Search(len(u.largest), func(i int) bool { return u.largest[i].Size < size })
+			u.largest = append(u.largest, nil)
+			copy(u.largest[position+1:], u.largest[position:])
+			u.largest[position] = &UsageFile{Name: path.Join(name, fileName), Size: size}
+			if len(u.largest) > u.top {
+				u.largest = u.largest[:u.top]
+			}
+		}
+	}
+	for directoryName, child := range node.directories {
+		u.directories++
+		u.collect(path.Join(name, directoryName), child)
+	}
+}
+
+// directoryUsage reports disk usage of the directory with specified name, including
+// the specified number of the largest files and usage of items directly in the directory.
+func directoryUsage(cfg *Configuration, name string, top int) (*DirectoryUsage, *ErrorDto) {
+	name = path.Clean(name)
+	if fileInfo, err := os.Stat(prepareAbsolutePath(cfg, name)); err != nil || !fileInfo.IsDir() {
+		return nil, errorDto(errReadingDirectoryContentFailed, name)
+	}
+	cfg.usage.mutex.Lock()
+	defer cfg.usage.mutex.Unlock()
+	node := cfg.usage.tree().lookup(name, false)
+	if node == nil {
+		// the directory was created outside tarolas after the tree was read
+		cfg.usage.refresh(name)
+		if node = cfg.usage.root.lookup(name, false); node == nil {
+			return nil, errorDto(errReadingDirectoryContentFailed, name)
+		}
+	}
+	collector := &usageCollector{top: top, largest: make([]*UsageFile, 0)}
+	collector.collect(name, node)
+	usage := &DirectoryUsage{
+		Name:        name,
+		Size:        collector.size,
+		Files:       collector.files,
+		Directories: collector.directories,
+		Largest:     collector.largest,
+		Children:    make([]*UsageChild, 0, len(node.files)+len(node.directories)),
+		Updated:     cfg.usage.updated,
+	}
+	for fileName, size := range node.files {
+		usage.Children = append(usage.Children, &UsageChild{Name: fileName, Size: size, Files: 1})
+	}
+	for directoryName, child := range node.directories {
+		childCollector := &usageCollector{}
+		childCollector.collect(directoryName, child)
+		usage.Children = append(usage.Children, &UsageChild{
+			Name:        directoryName,
+			Directory:   true,
+			Size:        childCollector.size,
+			Files:       childCollector.files,
+			Directories: childCollector.directories,
+		})
+	}
+	sort.Slice(usage.Children, func(a, b int) bool {
+		if usage.Children[a].Size != usage.Children[b].Size {
+			return usage.Children[a].Size > usage.Children[b].Size
+		}
+		return usage.Children[a].Name < usage.Children[b].Name
+	})
+	return usage, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -16,3 +17,11 @@ var (
 func prepareAbsolutePath(cfg *Configuration, name string) string {
 	return filepath.Join(cfg.RootDirectory, filepath.Clean(name))
 }
+
+// inDirectory returns true when the file or directory with specified name
+// is the given directory itself or is located anywhere in its subtree.
+func inDirectory(name string, directory string) bool {
+	cleanName := filepath.ToSlash(filepath.Clean(name))
+	directory = filepath.ToSlash(filepath.Clean(directory))
+	return directory == "/" || cleanName == directory || strings.HasPrefix(cleanName, directory+"/")
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	minFreeSpace   = 1 << 20           // Minimal free space in root directory file system in bytes.
+	probeFileName  = ".tarolas-probe-" // Prefix of the name of file created when checking directory permissions.
+	probeFileValue = "tarolas"         // Content of the probe file.
+)
+
+// knownRoutes lists all routes served by the server, used to check routes given in configuration.
+var knownRoutes = []string{
+	routeDirectoryRead, routeDirectoryTree, routeDirectoryList, routeDirectoryCreate, routeDirectoryDelete,
+	routeDirectoryArchive, routeDirectoryExtract, routeDirectoryManifest, routeDirectoryDiff, routeDirectoryUsage,
+	routeFileRead, routeFileWrite, routeFileAppend, routeFileDelete, routeFileExists, routeFileChecksum,
+	routeFileVersions, routeFileRestore, routeFileShared, routeTrashList, routeTrashRestore, routeTrashPurge,
+	routeBatch, routeWatch, routeJournalRead, routeSearch, routeStats, routeQuotaUsage, routeRateLimitStatus,
+	routeReplicationStatus, routeReplicationPromote, routeConfigReload, routeMetrics,
+	routeHealth, routeReady, routeVersion,
+}
+
+// validator collects problems found in configuration.
+type validator struct {
+	problems []error
+}
+
+// report adds the problem with the configuration field.
+func (v *validator) report(field string, format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+// nonNegative reports the problem when the value of numeric field is negative.
+func (v *validator) nonNegative(field string, value int64) {
+	if value < 0 {
+		v.report(field, "must not be negative (%d)", value)
+	}
+}
+
+// name reports the problem when the value does not follow file and directory naming rules.
+func (v *validator) name(field string, value string) {
+	if !strings.HasPrefix(value, "/") {
+		v.report(field, "must begin with slash (%q)", value)
+	}
+}
+
+// route reports the problem when the value is not a route served by the server.
+func (v *validator) route(field string, value string) {
+	for _, route := range knownRoutes {
+		if route == value {
+			return
+		}
+	}
+	v.report(field, "unknown route %q", value)
+}
+
+// Validate checks all configuration options, and the access to root and state directories.
+// All found problems are reported at once, nil is returned when the configuration is valid.
+// Root and state directory paths are cleaned, so they may be compared with joined paths.
+func (c *Configuration) Validate() error {
+	v := &validator{}
+	c.validateNetwork(v)
+	c.validateDirectories(v)
+	c.validateFeatures(v)
+	c.validateSecurity(v)
+	c.validateLimits(v)
+	return errors.Join(v.problems...)
+}
+
+// validateNetwork checks the port, listeners, URL prefix and timeouts.
+func (c *Configuration) validateNetwork(v *validator) {
+	if len(c.Listeners) == 0 && (c.ServerPort < 1 || c.ServerPort > 65535) {
+		v.report("serverPort", "must be between 1 and 65535 (%d)", c.ServerPort)
+	}
+	for i, listener := range c.Listeners {
+		field := "listeners[" + strconv.Itoa(i) + "]"
+		kinds := 0
+		for _, given := range []bool{listener.Address != "", listener.Socket != "", listener.Systemd} {
+			if given {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			v.report(field, "exactly one of address, socket and systemd must be given")
+		}
+		if listener.Address != "" {
+			if _, port, err := net.SplitHostPort(listener.Address); err != nil {
+				v.report(field+".address", "%v", err)
+			} else if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+				v.report(field+".address", "invalid port %q", port)
+			}
+		}
+		if listener.Mode != "" {
+			if _, err := strconv.ParseUint(listener.Mode, 8, 32); err != nil {
+				v.report(field+".mode", "must be octal number (%q)", listener.Mode)
+			}
+		}
+		if listener.Socket == "" && (listener.Mode != "" || listener.Owner != "" || listener.Group != "") {
+			v.report(field, "mode, owner and group apply only to Unix socket")
+		}
+	}
+	if c.UrlPrefix != "" {
+		if !strings.HasPrefix(c.UrlPrefix, "/") || strings.HasSuffix(c.UrlPrefix, "/") {
+			v.report("urlPrefix", "must begin with slash and must not end with slash (%q)", c.UrlPrefix)
+		}
+		if strings.ContainsAny(c.UrlPrefix, "?#% \t") || strings.Contains(c.UrlPrefix, "//") {
+			v.report("urlPrefix", "must be plain URL path (%q)", c.UrlPrefix)
+		}
+	}
+	v.nonNegative("timeouts.readHeader", int64(c.Timeouts.ReadHeader))
+	v.nonNegative("timeouts.read", int64(c.Timeouts.Read))
+	v.nonNegative("timeouts.write", int64(c.Timeouts.Write))
+	v.nonNegative("timeouts.idle", int64(c.Timeouts.Idle))
+	v.nonNegative("timeouts.shutdown", int64(c.Timeouts.Shutdown))
+}
+
+// validateDirectories checks root and state directories, their permissions and free space.
+func (c *Configuration) validateDirectories(v *validator) {
+	if c.RootDirectory == "" {
+		v.report("rootDirectory", "must be given")
+		return
+	}
+	c.RootDirectory = filepath.Clean(c.RootDirectory)
+	if c.StateDirectory != "" {
+		c.StateDirectory = filepath.Clean(c.StateDirectory)
+	}
+	if !filepath.IsAbs(c.RootDirectory) {
+		v.report("rootDirectory", "must be absolute path (%q)", c.RootDirectory)
+	}
+	info, err := os.Stat(c.RootDirectory)
+	if err != nil {
+		v.report("rootDirectory", "%v", err)
+		return
+	}
+	if !info.IsDir() {
+		v.report("rootDirectory", "not a directory (%q)", c.RootDirectory)
+		return
+	}
+	if err = probeDirectory(c.RootDirectory); err != nil {
+		v.report("rootDirectory", "no read/write/delete access: %v", err)
+	}
+	if stats, err := fileSystemStats(c.RootDirectory); err == nil && stats.Available < minFreeSpace {
+		v.report("rootDirectory", "less than %d bytes of free space available (%d)", minFreeSpace, stats.Available)
+	}
+	if c.StateDirectory != "" {
+		stateDirectory := c.stateDirectory()
+		if !filepath.IsAbs(c.StateDirectory) {
+			v.report("stateDirectory", "must be absolute path (%q)", c.StateDirectory)
+		} else if relative, err := filepath.Rel(stateDirectory, c.RootDirectory); err == nil && relative == "." {
+			v.report("stateDirectory", "must differ from root directory")
+		} else if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			// the root directory would be listed, archived and replicated as part of the state
+			v.report("stateDirectory", "must not contain root directory (%q)", stateDirectory)
+		}
+		// the state directory is created when missing, so its nearest existing parent must be writable
+		for {
+			if info, err := os.Stat(stateDirectory); err == nil {
+				if !info.IsDir() {
+					v.report("stateDirectory", "not a directory (%q)", stateDirectory)
+				} else if err = probeDirectory(stateDirectory); err != nil {
+					v.report("stateDirectory", "no read/write/delete access: %v", err)
+				} else if same, err := sameFileSystem(c.RootDirectory, stateDirectory); err == nil && !same {
+					// versions, trash and extracted files are renamed between the state and root directories
+					v.report("stateDirectory", "must be on the same file system as root directory (%q)", stateDirectory)
+				}
+				break
+			}
+			parent := filepath.Dir(stateDirectory)
+			if parent == stateDirectory {
+				break
+			}
+			stateDirectory = parent
+		}
+	}
+}
+
+// probeDirectory checks the access to the directory by creating, reading and deleting probe file.
+func probeDirectory(directory string) error {
+	probe := filepath.Join(directory, probeFileName+strconv.Itoa(os.Getpid()))
+	if err := os.WriteFile(probe, []byte(probeFileValue), 0600); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(probe)
+	if err == nil && string(data) != probeFileValue {
+		err = errors.New("probe file content differs")
+	}
+	if removeErr := os.Remove(probe); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// validateFeatures checks options of versioning, trash, watching, webhooks, journal,
+// replication, search and usage.
+func (c *Configuration) validateFeatures(v *validator) {
+	for i, directory := range c.Versioning.Directories {
+		v.name("versioning.directories["+strconv.Itoa(i)+"]", directory)
+	}
+	v.nonNegative("versioning.keepLast", int64(c.Versioning.KeepLast))
+	v.nonNegative("versioning.keepDays", int64(c.Versioning.KeepDays))
+	v.nonNegative("versioning.pruneInterval", int64(c.Versioning.PruneInterval))
+	v.nonNegative("trash.expireInterval", int64(c.Trash.ExpireInterval))
+	v.nonNegative("extract.maxEntries", int64(c.Extract.MaxEntries))
+	v.nonNegative("extract.maxTotalSize", c.Extract.MaxTotalSize)
+	v.nonNegative("watch.pollInterval", int64(c.Watch.PollInterval))
+	v.nonNegative("watch.history", int64(c.Watch.History))
+	subscriptionIds := make(map[string]bool)
+	for i, subscription := range c.Webhooks.Subscriptions {
+		field := "webhooks.subscriptions[" + strconv.Itoa(i) + "]"
+		if subscriptionIds[subscription.id()] {
+			v.report(field+".id", "duplicate subscription identifier %q", subscription.id())
+		}
+		subscriptionIds[subscription.id()] = true
+		if target, err := url.Parse(subscription.Url); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			v.report(field+".url", "must be absolute HTTP or HTTPS URL (%q)", subscription.Url)
+		}
+		if subscription.Directory != "" {
+			v.name(field+".directory", subscription.Directory)
+		}
+		for _, eventType := range subscription.Events {
+			switch eventType {
+			case EventCreate, EventWrite, EventAppend, EventDelete, EventMove:
+			default:
+				v.report(field+".events", "unknown event type %q", eventType)
+			}
+		}
+	}
+	v.nonNegative("webhooks.maxAttempts", int64(c.Webhooks.MaxAttempts))
+	v.nonNegative("webhooks.retryDelay", int64(c.Webhooks.RetryDelay))
+	v.nonNegative("webhooks.maxRetryDelay", int64(c.Webhooks.MaxRetryDelay))
+	v.nonNegative("webhooks.timeout", int64(c.Webhooks.Timeout))
+	v.nonNegative("journal.maxSegmentSize", c.Journal.MaxSegmentSize)
+	v.nonNegative("journal.maxSegments", int64(c.Journal.MaxSegments))
+	if c.Replication.Primary != "" {
+		if primary, err := url.Parse(c.Replication.Primary); err != nil || (primary.Scheme != "http" && primary.Scheme != "https") || primary.Host == "" {
+			v.report("replication.primary", "must be absolute HTTP or HTTPS URL (%q)", c.Replication.Primary)
+		}
+	}
+	v.nonNegative("replication.pollInterval", int64(c.Replication.PollInterval))
+	v.nonNegative("replication.batchSize", int64(c.Replication.BatchSize))
+	v.nonNegative("replication.timeout", int64(c.Replication.Timeout))
+	v.nonNegative("search.indexInterval", int64(c.Search.IndexInterval))
+	v.nonNegative("search.workers", int64(c.Search.Workers))
+	v.nonNegative("search.maxContentSize", c.Search.MaxContentSize)
+	v.nonNegative("usage.cacheTtl", int64(c.Usage.CacheTtl))
+}
+
+// validateSecurity checks API keys, TLS and client certificate options.
+func (c *Configuration) validateSecurity(v *validator) {
+	keys := make(map[string]bool)
+	for i, apiKey := range c.Auth.Keys {
+		field := "auth.keys[" + strconv.Itoa(i) + "]"
+		if apiKey.Principal == "" {
+			v.report(field+".principal", "must be given")
+		}
+		if apiKey.Key == "" {
+			v.report(field+".key", "must be given")
+		} else if keys[apiKey.Key] {
+			v.report(field+".key", "same key given for more principals")
+		}
+		keys[apiKey.Key] = true
+	}
+	if (c.Tls.CertFile == "") != (c.Tls.KeyFile == "") {
+		v.report("tls", "both certFile and keyFile must be given")
+	}
+	for _, file := range []struct{ field, name string }{
+		{"tls.certFile", c.Tls.CertFile},
+		{"tls.keyFile", c.Tls.KeyFile},
+		{"tls.clientCaFile", c.Tls.ClientCaFile},
+	} {
+		if file.name != "" {
+			if _, err := os.Stat(file.name); err != nil {
+				v.report(file.field, "%v", err)
+			}
+		}
+	}
+	switch c.Tls.ClientAuth {
+	case clientAuthNone:
+	case clientAuthOptional, clientAuthRequired:
+		if c.Tls.CertFile == "" {
+			v.report("tls.clientAuth", "client certificates require TLS to be enabled")
+		}
+		if c.Tls.ClientCaFile == "" {
+			v.report("tls.clientCaFile", "must be given when client certificates are verified")
+		}
+	default:
+		v.report("tls.clientAuth", "must be empty, %q or %q (%q)", clientAuthOptional, clientAuthRequired, c.Tls.ClientAuth)
+	}
+	for i, mapping := range c.Tls.Subjects {
+		field := "tls.subjects[" + strconv.Itoa(i) + "]"
+		if mapping.Subject == "" || mapping.Principal == "" {
+			v.report(field, "both subject and principal must be given")
+		}
+	}
+	v.nonNegative("tls.reloadInterval", int64(c.Tls.ReloadInterval))
+	for i, origin := range c.Cors.Origins {
+		field := "cors.origins[" + strconv.Itoa(i) + "]"
+		if origin == "*" {
+			if c.Cors.Credentials {
+				v.report(field, "any origin can not be allowed together with credentials")
+			}
+		} else if _, err := path.Match(origin, ""); err != nil || !strings.Contains(origin, "://") {
+			v.report(field, "must be origin like 'https://example.com' (%q)", origin)
+		}
+	}
+	for i, method := range c.Cors.Methods {
+		switch strings.ToUpper(method) {
+		case HttpGET, HttpPOST, HttpPUT, HttpDELETE:
+		default:
+			v.report("cors.methods["+strconv.Itoa(i)+"]", "unsupported method %q", method)
+		}
+	}
+	v.nonNegative("cors.maxAge", int64(c.Cors.MaxAge))
+	if _, err := parseLogLevel(c.Logging.Level); err != nil {
+		v.report("logging.level", "must be debug, info, warn or error (%q)", c.Logging.Level)
+	}
+	if c.Logging.Format != "" && c.Logging.Format != logFormatText && c.Logging.Format != logFormatJson {
+		v.report("logging.format", "must be %q or %q (%q)", logFormatText, logFormatJson, c.Logging.Format)
+	}
+	if c.Logging.File != "" {
+		if info, err := os.Stat(filepath.Dir(c.Logging.File)); err != nil {
+			v.report("logging.file", "%v", err)
+		} else if !info.IsDir() {
+			v.report("logging.file", "not a directory (%q)", filepath.Dir(c.Logging.File))
+		}
+	}
+	v.nonNegative("logging.maxSize", c.Logging.MaxSize)
+	v.nonNegative("logging.maxFiles", int64(c.Logging.MaxFiles))
+	for i, route := range c.Auth.Exempt {
+		v.route("auth.exempt["+strconv.Itoa(i)+"]", route)
+	}
+	certificates := c.Tls.ClientAuth != clientAuthNone && (len(c.Tls.Subjects) > 0 || c.Tls.CommonNamePrincipal)
+	if c.Auth.Required && len(c.Auth.Keys) == 0 && !certificates {
+		v.report("auth.required", "no API keys or client certificate principals are configured, all requests would be rejected")
+	}
+}
+
+// validateLimits checks quotas, transfer limits and rate limits.
+func (c *Configuration) validateLimits(v *validator) {
+	for i, quota := range c.Quotas {
+		field := "quotas[" + strconv.Itoa(i) + "]"
+		if quota.Directory != "" {
+			v.name(field+".directory", quota.Directory)
+		}
+		v.nonNegative(field+".maxBytes", quota.MaxBytes)
+		v.nonNegative(field+".maxFiles", quota.MaxFiles)
+		if quota.MaxBytes == 0 && quota.MaxFiles == 0 {
+			v.report(field, "at least one of maxBytes and maxFiles must be given")
+		}
+	}
+	v.nonNegative("transfer.maxUploadSize", c.Transfer.MaxUploadSize)
+	for route, size := range c.Transfer.MaxUploadSizes {
+		v.route("transfer.maxUploadSizes", route)
+		v.nonNegative("transfer.maxUploadSizes["+route+"]", size)
+	}
+	v.nonNegative("transfer.connectionUploadRate", c.Transfer.ConnectionUploadRate)
+	v.nonNegative("transfer.connectionDownloadRate", c.Transfer.ConnectionDownloadRate)
+	v.nonNegative("transfer.principalUploadRate", c.Transfer.PrincipalUploadRate)
+	v.nonNegative("transfer.principalDownloadRate", c.Transfer.PrincipalDownloadRate)
+	routes := make(map[string]bool)
+	for i, limit := range c.RateLimits {
+		field := "rateLimits[" + strconv.Itoa(i) + "]"
+		if limit.Route != "" {
+			v.route(field+".route", limit.Route)
+		}
+		if routes[limit.Route] {
+			v.report(field+".route", "more rules given for route %q", limit.Route)
+		}
+		routes[limit.Route] = true
+		if limit.Rate <= 0 {
+			v.report(field+".rate", "must be positive (%v)", limit.Rate)
+		}
+		v.nonNegative(field+".burst", int64(limit.Burst))
+	}
+}
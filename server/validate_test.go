@@ -0,0 +1,65 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCleansDirectories(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		rootDirectory  string
+		stateDirectory string
+	}{
+		{root + "/", ""},
+		{root + "/./", root + "/state/"},
+		{filepath.Join(root, "..") + "/" + filepath.Base(root), root + "//state/../state"},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{ServerPort: 8080, RootDirectory: test.rootDirectory, StateDirectory: test.stateDirectory}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%q: %v", test.rootDirectory, err)
+		}
+		if cfg.RootDirectory != root {
+			t.Errorf("%q: expected root directory %q, actual %q", test.rootDirectory, root, cfg.RootDirectory)
+		}
+		if test.stateDirectory != "" && cfg.StateDirectory != filepath.Join(root, "state") {
+			t.Errorf("%q: expected state directory %q, actual %q", test.stateDirectory, filepath.Join(root, "state"), cfg.StateDirectory)
+		}
+		if fullName := prepareAbsolutePath(cfg, "/"); fullName != cfg.RootDirectory {
+			t.Errorf("%q: root directory not recognized (%q)", test.rootDirectory, fullName)
+		}
+	}
+}
+
+func TestValidateStateDirectory(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "data", "root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stateDirectory string
+		expected       string // Expected problem, empty when the state directory is valid.
+	}{
+		{root, "must differ from root directory"},
+		{root + "/../root/", "must differ from root directory"},
+		{filepath.Join(parent, "data"), "must not contain root directory"},
+		{parent + "/data/./", "must not contain root directory"},
+		{filepath.Join(parent, "data", "root-state"), ""},
+		{filepath.Join(root, "state"), ""},
+		{filepath.Join(parent, "state"), ""},
+	}
+	for _, test := range tests {
+		cfg := &Configuration{ServerPort: 8080, RootDirectory: root, StateDirectory: test.stateDirectory}
+		err := cfg.Validate()
+		if test.expected == "" && err != nil {
+			t.Errorf("%q: unexpected error %v", test.stateDirectory, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), "stateDirectory: "+test.expected)) {
+			t.Errorf("%q: expected problem %q, actual %v", test.stateDirectory, test.expected, err)
+		}
+	}
+}
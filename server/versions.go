@@ -0,0 +1,249 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	versionsDirectory    = "versions"                   // Name of the directory for file versions, created in state directory.
+	versionsSuffix       = ".versions"                  // Suffix of the directory holding all versions of a single file.
+	versionIdLayout      = "20060102T150405.000000000Z" // Layout of version identifiers, sortable in chronological order.
+	defaultPruneInterval = 3600                         // Default interval in seconds between retention checks.
+	versionReasonWrite   = "write"                      // Version was created before overwriting a file.
+	versionReasonDelete  = "delete"                     // Version was created before deleting a file.
+	versionReasonRestore = "restore"                    // Version was created before restoring other version.
+	versionFileMode      = 0644                         // Access mode of files holding versions.
+)
+
+// FileVersion stores attributes of single preserved version of a file.
+type FileVersion struct {
+	Version string    `json:"version"  api:"Version identifier."`
+	Size    int64     `json:"size"     api:"Size of the preserved content in bytes."`
+	Created time.Time `json:"created"  api:"The time when the version was created."`
+	Reason  string    `json:"reason"   api:"Operation that caused preserving this version (write, delete or restore)."`
+}
+
+// FileVersionsDto is the implementation of DTO for file versions.
+type FileVersionsDto struct {
+	Data []*FileVersion `json:"data"  api:"List of file versions, the newest first."`
+}
+
+// isVersioned returns true when versioning is enabled for the file with specified name.
+func isVersioned(cfg *Configuration, name string) bool {
+	for _, directory := range cfg.Versioning.Directories {
+		if inDirectory(name, directory) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionsPath returns the absolute path of the directory where versions of the specified file are kept.
+func versionsPath(cfg *Configuration, name string) string {
+	return filepath.Join(cfg.stateDirectory(), versionsDirectory, filepath.Clean(name)+versionsSuffix)
+}
+
+// preserveVersion saves the current content of the specified file as a new version,
+// when versioning is enabled for this file. When 'move' flag is 'true' the file
+// is moved to versions area, otherwise its content is copied. Nonexisting files
+// are silently ignored, there is nothing to preserve.
+func preserveVersion(cfg *Configuration, name string, reason string, move bool) error {
+	if !isVersioned(cfg, name) {
+		return nil
+	}
+	fullName := prepareAbsolutePath(cfg, name)
+	fileInfo, err := os.Stat(fullName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fileInfo.IsDir() {
+		return nil
+	}
+	directory := versionsPath(cfg, name)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	versionName := filepath.Join(directory, time.Now().UTC().Format(versionIdLayout)+"-"+reason)
+	if move {
+		return os.Rename(fullName, versionName)
+	}
+	return copyFile(fullName, versionName)
+}
+
+// copyFile copies the content of the source file into destination file.
+// The destination file is created or truncated when already exists.
+func copyFile(source, destination string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := input.Close(); err != nil {
+			logError(err)
+		}
+	}()
+	output, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, versionFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(output, input); err != nil {
+		_ = output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+// readVersions returns all versions of the specified file, the newest first.
+func readVersions(cfg *Configuration, name string) ([]*FileVersion, error) {
+	versions := make([]*FileVersion, 0)
+	entries, err := os.ReadDir(versionsPath(cfg, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		timestamp, reason, _ := strings.Cut(entry.Name(), "-")
+		created, err := time.Parse(versionIdLayout, timestamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &FileVersion{Version: entry.Name(), Size: info.Size(), Created: created, Reason: reason})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// fileVersions lists all preserved versions of the file with specified name.
+func fileVersions(cfg *Configuration, name string) ([]*FileVersion, *ErrorDto) {
+	versions, err := readVersions(cfg, name)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReadingVersionsFailed, name)
+	}
+	return versions, nil
+}
+
+// fileRestore replaces the content of the file with specified name with the content
+// of the given version. Current content of the file is preserved as a new version.
+// When the file does not exist, it counts against quotas as created by specified owner.
+func fileRestore(cfg *Configuration, name string, version string, owner string) (*File, *ErrorDto) {
+	versions, err := readVersions(cfg, name)
+	if err != nil {
+		logError(err)
+		return nil, errorDto(errReadingVersionsFailed, name)
+	}
+	var found *FileVersion
+	for _, v := range versions {
+		if v.Version == version {
+			found = v
+			break
+		}
+	}
+	if found == nil {
+		return nil, errorDto(errVersionNotFound, version)
+	}
+	reservation, quotaError := cfg.quotas.reserve([]quotaChange{{name: name, size: found.Size}}, owner)
+	if quotaError != nil {
+		return nil, quotaError
+	}
+	defer reservation.release()
+	if err := preserveVersion(cfg, name, versionReasonRestore, false); err != nil {
+		logError(err)
+		return nil, errorDto(errPreservingVersionFailed, name)
+	}
+	fullName := prepareAbsolutePath(cfg, name)
+	if err := os.MkdirAll(filepath.Dir(fullName), 0755); err != nil {
+		logError(err)
+		return nil, errorDto(errCreatingDirectoriesFailed, name)
+	}
+	versionName := filepath.Join(versionsPath(cfg, name), found.Version)
+	if err := copyFile(versionName, fullName); err != nil {
+		logError(err)
+		return nil, errorDto(errRestoringVersionFailed, version)
+	}
+	reservation.commit()
+	size := found.Size
+	return &File{Name: &name, Size: &size}, nil
+}
+
+// pruneVersions removes versions that exceed configured retention limits.
+// Directories left empty after pruning are removed too.
+func pruneVersions(cfg *Configuration) {
+	keepLast := cfg.Versioning.KeepLast
+	keepDays := cfg.Versioning.KeepDays
+	if keepLast <= 0 && keepDays <= 0 {
+		return
+	}
+	deadline := time.Now().UTC().AddDate(0, 0, -keepDays)
+	root := filepath.Join(cfg.stateDirectory(), versionsDirectory)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || !strings.HasSuffix(path, versionsSuffix) {
+			return nil
+		}
+		name := "/" + filepath.ToSlash(strings.TrimSuffix(strings.TrimPrefix(path, root+string(filepath.Separator)), versionsSuffix))
+		versions, err := readVersions(cfg, name)
+		if err != nil {
+			return err
+		}
+		for i, v := range versions {
+			if (keepLast > 0 && i >= keepLast) || (keepDays > 0 && v.Created.Before(deadline)) {
+				if err := os.Remove(filepath.Join(path, v.Version)); err != nil {
+					logError(err)
+				}
+			}
+		}
+		// remove the directory only when empty, otherwise the call fails, and it is fine
+		_ = os.Remove(path)
+		return nil
+	})
+	if err != nil {
+		logError(err)
+	}
+}
+
+// startVersionsPruner runs the retention checks in background until
+// the returned stop function is called.
+func startVersionsPruner(cfg *Configuration) func() {
+	interval := cfg.Versioning.PruneInterval
+	if interval <= 0 {
+		interval = defaultPruneInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		pruneVersions(cfg)
+		for {
+			select {
+			case <-ticker.C:
+				pruneVersions(cfg)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
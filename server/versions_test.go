@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingReader returns the content and then fails, like a request body of a broken connection.
+type failingReader struct {
+	content io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestVersionsOfFailedWrites(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Versioning.Directories = []string{"/limited"}
+	startTestQuotas(t, cfg)
+	batchWrite(t, cfg, "/limited/a.txt", "abc")
+	batchWrite(t, cfg, "/limited/a.txt", "def")
+	expectVersions := func(operation string) {
+		t.Helper()
+		versions, err := readVersions(cfg, "/limited/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 1 {
+			t.Errorf("%s: expected single version, actual %d", operation, len(versions))
+		}
+	}
+	expectVersions("batch write")
+	encoded := base64.StdEncoding.EncodeToString([]byte("abcdefghijkl"))
+	req := httptest.NewRequest(HttpPOST, routeFileWrite, strings.NewReader(encoded))
+	req.RemoteAddr = ""
+	_, errorDto := fileWrite(cfg, req, "/limited/a.txt")
+	expectQuotaExceeded(t, "write", errorDto)
+	expectVersions("write exceeding quota")
+	req = httptest.NewRequest(HttpPOST, routeFileWrite, &failingReader{strings.NewReader("Z2hp")})
+	req.RemoteAddr = ""
+	if _, errorDto = fileWrite(cfg, req, "/limited/a.txt"); errorDto == nil {
+		t.Error("write of broken content succeeded")
+	}
+	expectVersions("write of broken content")
+	transaction := batchTransaction{cfg: cfg}
+	_, errorDto = transaction.write("/limited/a.txt", encoded)
+	expectQuotaExceeded(t, "batch write", errorDto)
+	expectVersions("batch write exceeding quota")
+}
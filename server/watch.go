@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPollInterval = 2                // Default interval in seconds between file system snapshots, when polling.
+	keepAliveInterval   = 15 * time.Second // Interval between keep alive messages sent to idle subscribers.
+)
+
+// watchEvents streams events concerning the directory with specified name to the client.
+// Clients requesting WebSocket upgrade receive events as text messages, all other
+// clients receive Server-Sent Events. Clients may resume the stream by passing
+// the identifier of the last received event in 'Last-Event-ID' header or 'since' parameter.
+func watchEvents(cfg *Configuration, w http.ResponseWriter, req *http.Request, name string, recursive bool, since uint64) *ErrorDto {
+	if cfg.events == nil {
+		return errorDto(errWatchingNotAvailable, name)
+	}
+	name = path.Clean(name)
+	if isWebsocketRequest(req) {
+		conn, err := upgradeWebsocket(w, req)
+		if err != nil {
+			logError(err)
+			return errorDto(errWatchingNotAvailable, err.Error())
+		}
+		subscriber := cfg.events.subscribe(since)
+		defer cfg.events.unsubscribe(subscriber)
+		defer conn.close()
+		closed := conn.readLoop()
+		for {
+			select {
+			case event, ok := <-subscriber.events:
+				if !ok {
+					return nil
+				}
+				if eventMatches(event, name, recursive) {
+					data, _ := json.Marshal(event)
+					if err := conn.writeText(data); err != nil {
+						return nil
+					}
+				}
+			case <-closed:
+				return nil
+			}
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errorDto(errWatchingNotAvailable, name)
+	}
+	subscriber := cfg.events.subscribe(since)
+	defer cfg.events.unsubscribe(subscriber)
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		// streams are long-lived, the write deadline is extended before every write
+		_ = controller.SetWriteDeadline(time.Now().Add(2 * keepAliveInterval))
+		select {
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return nil
+			}
+			if eventMatches(event, name, recursive) {
+				data, _ := json.Marshal(event)
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+			}
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case <-req.Context().Done():
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+// sinceParam reads the identifier of the last event received by the client.
+func sinceParam(w http.ResponseWriter, req *http.Request) (uint64, bool) {
+	since, ok := optionalSingleParam(w, req, "since", req.Header.Get("Last-Event-ID"))
+	if !ok {
+		return 0, false
+	}
+	if since == "" {
+		return 0, true
+	}
+	value, err := strconv.ParseUint(since, 10, 64)
+	if err != nil {
+		writeResultError(w, errorDto(errInvalidParameterValue, "since ("+since+")"))
+		return 0, false
+	}
+	return value, true
+}
+
+// fileSnapshot stores attributes used to detect file changes when polling.
+type fileSnapshot struct {
+	size      int64
+	modified  time.Time
+	directory bool
+}
+
+// pollInterval returns the interval between file system snapshots.
+func (c *WatchConfiguration) pollInterval() time.Duration {
+	if c.PollInterval <= 0 {
+		return defaultPollInterval * time.Second
+	}
+	return time.Duration(c.PollInterval) * time.Second
+}
+
+// takeSnapshot walks the whole directory tree and records attributes of all files and directories.
+func takeSnapshot(cfg *Configuration) map[string]fileSnapshot {
+	snapshot := make(map[string]fileSnapshot)
+	_ = filepath.Walk(cfg.RootDirectory, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if cfg.isStatePath(fullName) {
+			return filepath.SkipDir
+		}
+		if fullName != cfg.RootDirectory {
+			snapshot[relativeName(cfg, fullName)] = fileSnapshot{size: info.Size(), modified: info.ModTime(), directory: info.IsDir()}
+		}
+		return nil
+	})
+	return snapshot
+}
+
+// relativeName converts absolute path into file or directory name relative to root directory.
+func relativeName(cfg *Configuration, fullName string) string {
+	return "/" + filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(fullName, cfg.RootDirectory), string(filepath.Separator)))
+}
+
+// startPolling detects file system changes by comparing periodic snapshots of the directory tree,
+// until the returned stop function is called. Used when native file system notifications
+// are not available.
+func startPolling(cfg *Configuration) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cfg.Watch.pollInterval())
+		defer ticker.Stop()
+		previous := takeSnapshot(cfg)
+		for {
+			select {
+			case <-ticker.C:
+				current := takeSnapshot(cfg)
+				for name, now := range current {
+					if before, ok := previous[name]; !ok {
+						cfg.events.publish(&Event{Type: EventCreate, Name: name, Directory: now.directory, Size: now.size, Source: eventSourceFs})
+					} else if !now.directory && (before.size != now.size || !before.modified.Equal(now.modified)) {
+						cfg.events.publish(&Event{Type: EventWrite, Name: name, Size: now.size, Source: eventSourceFs})
+					}
+				}
+				for name, before := range previous {
+					if _, ok := current[name]; !ok {
+						cfg.events.publish(&Event{Type: EventDelete, Name: name, Directory: before.directory, Source: eventSourceFs})
+					}
+				}
+				previous = current
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// startWatching starts watching the file system for changes made outside tarolas.
+// Native notifications are used when available, otherwise the file system is polled.
+func startWatching(cfg *Configuration) func() {
+	if stop, err := startNativeWatching(cfg); err == nil {
+		return stop
+	} else {
+		logError(fmt.Errorf("native file system watching not available, polling instead: %v", err))
+	}
+	return startPolling(cfg)
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher reports file system changes using Linux inotify interface.
+// Every directory in the tree is watched separately, new directories are added as they appear.
+type inotifyWatcher struct {
+	cfg         *Configuration
+	fd          int
+	file        *os.File
+	mutex       sync.Mutex
+	directories map[int32]string // Watched directories by watch descriptor.
+}
+
+// startNativeWatching starts watching the directory tree using inotify.
+func startNativeWatching(cfg *Configuration) (func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	watcher := &inotifyWatcher{cfg: cfg, fd: fd, directories: make(map[int32]string)}
+	// non-blocking descriptor is handled by runtime poller, so closing the file interrupts reading
+	watcher.file = os.NewFile(uintptr(fd), "inotify")
+	if err = watcher.addTree(cfg.RootDirectory); err != nil {
+		_ = watcher.file.Close()
+		return nil, err
+	}
+	go watcher.run()
+	return func() { _ = watcher.file.Close() }, nil
+}
+
+// addTree adds the directory and all its subdirectories to watched directories.
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(fullName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if w.cfg.isStatePath(fullName) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			wd, err := syscall.InotifyAddWatch(w.fd, fullName, inotifyMask)
+			if err != nil {
+				return err
+			}
+			w.mutex.Lock()
+			w.directories[int32(wd)] = fullName
+			w.mutex.Unlock()
+		}
+		return nil
+	})
+}
+
+// run reads and publishes inotify events until the watcher is closed.
+func (w *inotifyWatcher) run() {
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := w.file.Read(buffer)
+		if err != nil {
+			return
+		}
+		moves := make(map[uint32]*Event)
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameBytes := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+			w.mutex.Lock()
+			directory, ok := w.directories[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.directories, raw.Wd)
+			}
+			w.mutex.Unlock()
+			if !ok || raw.Len == 0 {
+				continue
+			}
+			fullName := filepath.Join(directory, string(nameBytes[:clen(nameBytes)]))
+			if w.cfg.isStatePath(fullName) {
+				continue
+			}
+			name := relativeName(w.cfg, fullName)
+			isDir := raw.Mask&syscall.IN_ISDIR != 0
+			switch {
+			case raw.Mask&syscall.IN_CREATE != 0:
+				if isDir {
+					_ = w.addTree(fullName)
+				}
+				w.cfg.events.publish(&Event{Type: EventCreate, Name: name, Directory: isDir, Source: eventSourceFs})
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				var size int64
+				if info, err := os.Stat(fullName); err == nil {
+					size = info.Size()
+				}
+				w.cfg.events.publish(&Event{Type: EventWrite, Name: name, Size: size, Source: eventSourceFs})
+			case raw.Mask&syscall.IN_DELETE != 0:
+				w.cfg.events.publish(&Event{Type: EventDelete, Name: name, Directory: isDir, Source: eventSourceFs})
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				moves[raw.Cookie] = &Event{Type: EventMove, Name: name, Directory: isDir, Source: eventSourceFs}
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				if isDir {
+					_ = w.addTree(fullName)
+				}
+				if event, ok := moves[raw.Cookie]; ok {
+					delete(moves, raw.Cookie)
+					event.Target = name
+					w.cfg.events.publish(event)
+				} else {
+					// moved in from outside the watched tree
+					w.cfg.events.publish(&Event{Type: EventCreate, Name: name, Directory: isDir, Source: eventSourceFs})
+				}
+			}
+		}
+		// items moved outside the watched tree are reported as deleted
+		for _, event := range moves {
+			event.Type = EventDelete
+			w.cfg.events.publish(event)
+		}
+	}
+}
+
+// clen returns the length of null-terminated name.
+func clen(name []byte) int {
+	for i, b := range name {
+		if b == 0 {
+			return i
+		}
+	}
+	return len(name)
+}
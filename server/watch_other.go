@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// startNativeWatching reports that native file system notifications are not supported
+// on this platform, so the file system is polled instead.
+func startNativeWatching(_ *Configuration) (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
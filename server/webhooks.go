@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webhooksDirectory     = "webhooks"  // Name of the directory for webhook deliveries, created in state directory.
+	webhooksQueue         = "queue"     // Name of the directory with pending deliveries.
+	webhooksDead          = "dead"      // Name of the directory with deliveries that exceeded the number of attempts.
+	defaultMaxAttempts    = 10          // Default maximum number of delivery attempts.
+	defaultRetryDelay     = 1           // Default delay in seconds before the first retry.
+	defaultMaxRetryDelay  = 3600        // Default maximum delay in seconds between retries.
+	defaultWebhookTimeout = 10          // Default timeout in seconds of single delivery attempt.
+	webhookQueueScan      = time.Second // Interval between scans of the queue for deliveries to retry.
+)
+
+// webhookEvents lists the types of events emitted by handlers, that webhooks are notified about.
+var webhookEvents = map[string]bool{EventCreate: true, EventWrite: true, EventAppend: true, EventDelete: true, EventMove: true}
+
+// webhookDelivery is a pending notification of single subscription about single event,
+// stored in persistent queue until delivered.
+type webhookDelivery struct {
+	Id           string    `json:"id"`
+	Subscription string    `json:"subscription"` // Identifier of the subscription.
+	Url          string    `json:"url"`
+	Event        *Event    `json:"event"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"nextAttempt"`
+	LastError    string    `json:"lastError,omitempty"`
+}
+
+// webhookDispatcher enqueues events for matching subscriptions and delivers them in background,
+// retrying failed deliveries with exponential backoff. Each subscription is delivered
+// by its own worker, so unavailable endpoints do not delay the other subscriptions.
+type webhookDispatcher struct {
+	cfg     *Configuration
+	queue   string          // Directory with pending deliveries.
+	dead    string          // Directory with undeliverable deliveries.
+	counter atomic.Uint64   // Counter making delivery identifiers unique.
+	wakeup  chan struct{}   // Signals new deliveries to the worker.
+	done    chan struct{}   // Closed when the dispatcher is stopped.
+	mutex   sync.Mutex      // Guards the set of busy subscriptions.
+	busy    map[string]bool // Subscriptions, which deliveries are in progress.
+	client  *http.Client
+}
+
+// startWebhooks creates the webhook dispatcher, registers it as event listener
+// and starts delivering in background until the returned stop function is called.
+// Deliveries queued before the restart of the server are delivered too.
+func startWebhooks(cfg *Configuration) (func(), error) {
+	directory := filepath.Join(cfg.stateDirectory(), webhooksDirectory)
+	dispatcher := &webhookDispatcher{
+		cfg:    cfg,
+		queue:  filepath.Join(directory, webhooksQueue),
+		dead:   filepath.Join(directory, webhooksDead),
+		wakeup: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		client: &http.Client{Timeout: time.Duration(valueOrDefault(cfg.Webhooks.Timeout, defaultWebhookTimeout)) * time.Second},
+	}
+	for _, dir := range []string{dispatcher.queue, dispatcher.dead} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	cfg.events.listen(dispatcher.enqueue)
+	go dispatcher.run()
+	return func() { close(dispatcher.done) }, nil
+}
+
+// valueOrDefault returns the value when positive, otherwise the default value.
+func valueOrDefault(value, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// enqueue stores deliveries of the event for all matching subscriptions in persistent queue.
+// Changes replicated from the primary are not notified, the primary notifies about them.
+func (d *webhookDispatcher) enqueue(event *Event) {
+	if event.Source != eventSourceApi || !webhookEvents[event.Type] {
+		return
+	}
+	queued := false
+	for _, subscription := range d.cfg.Webhooks.Subscriptions {
+		if !subscription.matches(event) {
+			continue
+		}
+		now := time.Now().UTC()
+		delivery := webhookDelivery{
+			Id:           fmt.Sprintf("%020d-%06d", now.UnixNano(), d.counter.Add(1)%1000000),
+			Subscription: subscription.id(),
+			Url:          subscription.Url,
+			Event:        event,
+			NextAttempt:  now,
+		}
+		if err := d.store(d.queue, &delivery); err != nil {
+			logError(err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case d.wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// id returns the identifier of the subscription, its URL when the identifier is not configured.
+func (s *WebhookSubscription) id() string {
+	if s.Id != "" {
+		return s.Id
+	}
+	return s.Url
+}
+
+// subscription returns the subscription with specified identifier, nil when not configured.
+func (d *webhookDispatcher) subscription(id string) *WebhookSubscription {
+	for i := range d.cfg.Webhooks.Subscriptions {
+		if d.cfg.Webhooks.Subscriptions[i].id() == id {
+			return &d.cfg.Webhooks.Subscriptions[i]
+		}
+	}
+	return nil
+}
+
+// matches returns true when the subscription is interested in specified event.
+func (s *WebhookSubscription) matches(event *Event) bool {
+	if len(s.Events) > 0 {
+		found := false
+		for _, eventType := range s.Events {
+			found = found || eventType == event.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	return s.Directory == "" || inDirectory(event.Name, s.Directory)
+}
+
+// store writes the delivery into specified directory.
+func (d *webhookDispatcher) store(directory string, delivery *webhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	temporary := filepath.Join(directory, "."+delivery.Id)
+	if err = os.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, filepath.Join(directory, delivery.Id+".json"))
+}
+
+// run delivers queued notifications until the dispatcher is stopped.
+func (d *webhookDispatcher) run() {
+	ticker := time.NewTicker(webhookQueueScan)
+	defer ticker.Stop()
+	for {
+		d.deliverDue()
+		select {
+		case <-d.wakeup:
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// deliverDue attempts to deliver all queued notifications, for which the time of the next attempt has come.
+// Notifications of each subscription are delivered in the order they were queued, by the worker
+// of the subscription. Subscriptions with deliveries still in progress are skipped until the next scan.
+func (d *webhookDispatcher) deliverDue() {
+	entries, err := os.ReadDir(d.queue)
+	if err != nil {
+		logError(err)
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	now := time.Now().UTC()
+	due := make(map[*WebhookSubscription][]string)
+	for _, name := range names {
+		fileName := filepath.Join(d.queue, name)
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logError(err)
+			}
+			continue
+		}
+		var delivery webhookDelivery
+		if err = json.Unmarshal(data, &delivery); err != nil {
+			logError(err)
+			continue
+		}
+		if delivery.NextAttempt.After(now) {
+			continue
+		}
+		subscription := d.subscription(delivery.Subscription)
+		if subscription == nil {
+			// the subscription was removed from configuration, its notifications are not delivered
+			logError(fmt.Errorf("webhook delivery %s dropped, subscription %q is not configured", delivery.Id, delivery.Subscription))
+			if err := os.Remove(fileName); err != nil {
+				logError(err)
+			}
+			continue
+		}
+		due[subscription] = append(due[subscription], fileName)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.busy == nil {
+		d.busy = make(map[string]bool)
+	}
+	for subscription, fileNames := range due {
+		if d.busy[subscription.id()] {
+			continue
+		}
+		d.busy[subscription.id()] = true
+		go d.deliverSubscription(subscription, fileNames, now)
+	}
+}
+
+// deliverSubscription attempts to deliver queued notifications of single subscription.
+func (d *webhookDispatcher) deliverSubscription(subscription *WebhookSubscription, fileNames []string, now time.Time) {
+	defer func() {
+		d.mutex.Lock()
+		delete(d.busy, subscription.id())
+		d.mutex.Unlock()
+	}()
+	for _, fileName := range fileNames {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		var delivery webhookDelivery
+		if err = json.Unmarshal(data, &delivery); err != nil {
+			logError(err)
+			continue
+		}
+		if err = d.deliver(subscription, &delivery); err == nil {
+			if err := os.Remove(fileName); err != nil {
+				logError(err)
+			}
+			continue
+		}
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		target := d.queue
+		if delivery.Attempts >= valueOrDefault(d.cfg.Webhooks.MaxAttempts, defaultMaxAttempts) {
+			logError(fmt.Errorf("webhook delivery %s to %s abandoned after %d attempts: %v", delivery.Id, delivery.Url, delivery.Attempts, err))
+			target = d.dead
+		} else {
+			delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		}
+		if err := d.store(target, &delivery); err != nil {
+			logError(err)
+		} else if target == d.dead {
+			_ = os.Remove(fileName)
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, doubled after each failed attempt.
+func (d *webhookDispatcher) backoff(attempts int) time.Duration {
+	delay := time.Duration(valueOrDefault(d.cfg.Webhooks.RetryDelay, defaultRetryDelay)) * time.Second
+	maxDelay := time.Duration(valueOrDefault(d.cfg.Webhooks.MaxRetryDelay, defaultMaxRetryDelay)) * time.Second
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// deliver sends single notification. The body is signed with subscription secret (HMAC SHA256),
+// the signature is sent in 'X-Tarolas-Signature' header. Any response status other than 2xx
+// is treated as a failure.
+func (d *webhookDispatcher) deliver(subscription *WebhookSubscription, delivery *webhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(HttpPOST, delivery.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tarolas-Event", delivery.Event.Type)
+	req.Header.Set("X-Tarolas-Delivery", delivery.Id)
+	req.Header.Set("X-Tarolas-Attempt", strconv.Itoa(delivery.Attempts+1))
+	if subscription.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(subscription.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Tarolas-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookRequest is the notification received by the test webhook receiver.
+type webhookRequest struct {
+	header   http.Header
+	body     []byte
+	received time.Time
+}
+
+// startWebhookReceiver starts the server receiving notifications, it responds
+// with the status returned by the status function.
+func startWebhookReceiver(t *testing.T, status func() int) (*httptest.Server, chan *webhookRequest) {
+	requests := make(chan *webhookRequest, 16)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests <- &webhookRequest{header: req.Header.Clone(), body: body, received: time.Now()}
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, requests
+}
+
+// webhookConfiguration returns the configuration with single subscription of specified URL.
+func webhookConfiguration(t *testing.T, url string) *Configuration {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Webhooks.Subscriptions = []WebhookSubscription{{Id: "test", Url: url, Events: []string{EventWrite}, Secret: "secret"}}
+	cfg.Webhooks.RetryDelay = 1
+	cfg.events = newEventHub(0, 0)
+	return cfg
+}
+
+// receiveWebhook waits for the next notification received by the test webhook receiver.
+func receiveWebhook(t *testing.T, requests chan *webhookRequest) *webhookRequest {
+	t.Helper()
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(10 * time.Second):
+		t.Fatal("webhook notification not received")
+		return nil
+	}
+}
+
+// queuedDeliveries returns deliveries stored in the queue of the configured state directory.
+func queuedDeliveries(t *testing.T, cfg *Configuration) []*webhookDelivery {
+	t.Helper()
+	queue := filepath.Join(cfg.stateDirectory(), webhooksDirectory, webhooksQueue)
+	names, err := filepath.Glob(filepath.Join(queue, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries := make([]*webhookDelivery, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivery := &webhookDelivery{}
+		if err = json.Unmarshal(data, delivery); err != nil {
+			t.Fatal(err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// waitForEmptyQueue waits until delivered notifications are removed from the queue.
+func waitForEmptyQueue(t *testing.T, cfg *Configuration) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queuedDeliveries(t, cfg)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("delivered notification not removed from queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDeliveryIsSignedAndRetried(t *testing.T) {
+	var attempts atomic.Int32
+	receiver, requests := startWebhookReceiver(t, func() int {
+		if attempts.Add(1) == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	cfg := webhookConfiguration(t, receiver.URL)
+	stop, err := startWebhooks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	// events not subscribed and events not emitted by handlers are not delivered
+	cfg.events.publish(&Event{Type: EventDelete, Name: "/skipped.txt"})
+	cfg.events.publish(&Event{Type: EventWrite, Name: "/skipped.txt", Source: eventSourceFs})
+	cfg.events.publish(&Event{Type: EventWrite, Name: "/a.txt", Size: 3})
+
+	first := receiveWebhook(t, requests)
+	var event Event
+	if err = json.Unmarshal(first.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventWrite || event.Name != "/a.txt" || event.Size != 3 {
+		t.Errorf("unexpected event %+v", event)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(first.body)
+	if expected, actual := "sha256="+hex.EncodeToString(mac.Sum(nil)), first.header.Get("X-Tarolas-Signature"); actual != expected {
+		t.Errorf("expected signature %q, actual %q", expected, actual)
+	}
+	if actual := first.header.Get("X-Tarolas-Attempt"); actual != "1" {
+		t.Errorf("expected first attempt, actual %q", actual)
+	}
+
+	second := receiveWebhook(t, requests)
+	if actual := second.header.Get("X-Tarolas-Attempt"); actual != "2" {
+		t.Errorf("expected second attempt, actual %q", actual)
+	}
+	if second.header.Get("X-Tarolas-Delivery") != first.header.Get("X-Tarolas-Delivery") {
+		t.Error("retried notification has different delivery identifier")
+	}
+	// the delay is counted from the start of the scan of the queue, which precedes the first attempt
+	if delay := second.received.Sub(first.received); delay < 900*time.Millisecond {
+		t.Errorf("expected retry after the retry delay, actual %v", delay)
+	}
+	waitForEmptyQueue(t, cfg)
+	select {
+	case request := <-requests:
+		t.Errorf("unexpected notification %s", request.body)
+	default:
+	}
+}
+
+func TestWebhookQueueIsPersistent(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	receiver, requests := startWebhookReceiver(t, func() int { return int(status.Load()) })
+	cfg := webhookConfiguration(t, receiver.URL)
+	stop, err := startWebhooks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.events.publish(&Event{Type: EventWrite, Name: "/a.txt"})
+	receiveWebhook(t, requests)
+	stop()
+	// the failed attempt is recorded asynchronously after the response was received
+	deadline := time.Now().Add(5 * time.Second)
+	deliveries := queuedDeliveries(t, cfg)
+	for (len(deliveries) != 1 || deliveries[0].Attempts != 1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		deliveries = queuedDeliveries(t, cfg)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected single queued delivery, actual %d", len(deliveries))
+	}
+	if deliveries[0].Subscription != "test" || deliveries[0].Attempts != 1 || deliveries[0].LastError == "" {
+		t.Errorf("unexpected queued delivery %+v", deliveries[0])
+	}
+
+	// the queued delivery is delivered after restart
+	status.Store(http.StatusNoContent)
+	restarted := webhookConfiguration(t, receiver.URL)
+	restarted.RootDirectory = cfg.RootDirectory
+	stop, err = startWebhooks(restarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	request := receiveWebhook(t, requests)
+	if actual := request.header.Get("X-Tarolas-Delivery"); actual != deliveries[0].Id {
+		t.Errorf("expected delivery %q, actual %q", deliveries[0].Id, actual)
+	}
+	waitForEmptyQueue(t, restarted)
+}
+
+func TestWebhookUnavailableEndpointDoesNotDelayOthers(t *testing.T) {
+	release := make(chan struct{})
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(unavailable.Close)
+	t.Cleanup(func() { close(release) })
+	receiver, requests := startWebhookReceiver(t, func() int { return http.StatusOK })
+	cfg := webhookConfiguration(t, unavailable.URL)
+	cfg.Webhooks.Subscriptions = append(cfg.Webhooks.Subscriptions, WebhookSubscription{Id: "available", Url: receiver.URL})
+	cfg.Webhooks.Timeout = 30
+	stop, err := startWebhooks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	for _, name := range []string{"/a.txt", "/b.txt"} {
+		cfg.events.publish(&Event{Type: EventWrite, Name: name})
+		select {
+		case request := <-requests:
+			var event Event
+			if err = json.Unmarshal(request.body, &event); err != nil || event.Name != name {
+				t.Errorf("expected event of %s, actual %s (%v)", name, request.body, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification of %s delayed by unavailable endpoint", name)
+		}
+	}
+}
+
+func TestWebhookDeliveryOfRemovedSubscriptionIsDropped(t *testing.T) {
+	receiver, requests := startWebhookReceiver(t, func() int { return http.StatusOK })
+	cfg := webhookConfiguration(t, receiver.URL)
+	dispatcher := &webhookDispatcher{cfg: cfg, queue: t.TempDir(), wakeup: make(chan struct{}, 1)}
+	dispatcher.enqueue(&Event{Type: EventWrite, Name: "/a.txt", Source: eventSourceApi})
+	cfg.Webhooks.Subscriptions[0].Id = "other"
+	dispatcher.deliverDue()
+	if entries, _ := os.ReadDir(dispatcher.queue); len(entries) != 0 {
+		t.Errorf("expected empty queue, actual %d entries", len(entries))
+	}
+	select {
+	case request := <-requests:
+		t.Errorf("unexpected notification %s", request.body)
+	default:
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	dispatcher := &webhookDispatcher{cfg: &Configuration{}}
+	dispatcher.cfg.Webhooks.RetryDelay = 2
+	dispatcher.cfg.Webhooks.MaxRetryDelay = 10
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if actual := dispatcher.backoff(test.attempts); actual != test.expected {
+			t.Errorf("attempts %d: expected %v, actual %v", test.attempts, test.expected, actual)
+		}
+	}
+}
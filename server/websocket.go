@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	websocketGuid         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // Defined in RFC 6455, used to compute accept key.
+	websocketOpText       = 0x1                                    // Text frame.
+	websocketOpClose      = 0x8                                    // Connection close frame.
+	websocketOpPing       = 0x9                                    // Ping frame.
+	websocketOpPong       = 0xA                                    // Pong frame.
+	websocketMaxFrameSize = 1 << 16                                // Maximum size of accepted client frame payload.
+)
+
+var errWebsocketFrameTooLarge = errors.New("websocket frame too large")
+
+// websocketConnection is a minimal server side implementation of WebSocket protocol (RFC 6455),
+// sufficient to push text messages to clients and to answer control frames.
+type websocketConnection struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex // Guards writing frames, control frames are answered from reading goroutine.
+}
+
+// isWebsocketRequest returns true when the client requests upgrading the connection to WebSocket.
+func isWebsocketRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// upgradeWebsocket performs the opening handshake and takes over the connection.
+func upgradeWebsocket(w http.ResponseWriter, req *http.Request) (*websocketConnection, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" || req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("invalid websocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can not be hijacked")
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// deadlines of read and write timeouts are cleared explicitly, the connection lives as long as the client watches
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(key + websocketGuid))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &websocketConnection{conn: conn, reader: buffer.Reader}, nil
+}
+
+// writeFrame writes single unmasked frame with specified operation code.
+func (c *websocketConnection) writeFrame(opCode byte, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	header := []byte{0x80 | opCode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// writeText sends text message to the client.
+func (c *websocketConnection) writeText(message []byte) error {
+	return c.writeFrame(websocketOpText, message)
+}
+
+// readFrame reads single client frame and returns its operation code and unmasked payload.
+func (c *websocketConnection) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+	opCode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > websocketMaxFrameSize {
+		return 0, nil, errWebsocketFrameTooLarge
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opCode, payload, nil
+}
+
+// readLoop reads client frames until the connection is closed, answering pings and close requests.
+// Data frames sent by the client are ignored. The returned channel is closed when the loop ends.
+func (c *websocketConnection) readLoop() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opCode, payload, err := c.readFrame()
+			if err != nil {
+				return
+			}
+			switch opCode {
+			case websocketOpPing:
+				if err = c.writeFrame(websocketOpPong, payload); err != nil {
+					return
+				}
+			case websocketOpClose:
+				_ = c.writeFrame(websocketOpClose, payload)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// close sends close frame and closes the underlying connection.
+func (c *websocketConnection) close() {
+	_ = c.writeFrame(websocketOpClose, []byte{0x03, 0xE8})
+	_ = c.conn.Close()
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketOutlivesTimeouts(t *testing.T) {
+	cfg := &Configuration{RootDirectory: t.TempDir()}
+	cfg.Timeouts.Read = 1
+	cfg.Timeouts.Write = 1
+	url := startTestServer(t, cfg)
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	handshake := "GET " + routeWatch + "?name=/ HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err = conn.Write([]byte(handshake)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, actual %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	// the change is made after the read and write timeouts elapsed
+	time.Sleep(1500 * time.Millisecond)
+	post(t, url+routeFileWrite+"?name=/a.txt", "", base64.StdEncoding.EncodeToString([]byte("abc")), http.StatusOK)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		t.Fatalf("websocket closed by timeout: %v", err)
+	}
+	if header[0] != 0x80|websocketOpText || header[1] >= 126 {
+		t.Fatalf("unexpected frame header %x", header)
+	}
+	payload := make([]byte, header[1])
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err = json.Unmarshal(payload, &event); err != nil || event.Name != "/a.txt" {
+		t.Errorf("unexpected event %s (%v)", payload, err)
+	}
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is single meaningful line of YAML document, without comment.
+type yamlLine struct {
+	number int    // Line number in the document, starting from 1.
+	indent int    // Number of leading spaces.
+	text   string // Content without indentation and comment.
+}
+
+// yamlParser parses the subset of YAML used for configuration files: block mappings and sequences,
+// flow collections written on single line, plain, single and double quoted scalars and comments.
+// Anchors, aliases, tags, block scalars and multiple documents are not supported.
+type yamlParser struct {
+	lines    []yamlLine
+	position int
+}
+
+// parseYaml parses YAML document into tree of maps, lists and scalars.
+func parseYaml(document string) (interface{}, error) {
+	parser := &yamlParser{}
+	for i, line := range strings.Split(strings.ReplaceAll(document, "\r\n", "\n"), "\n") {
+		text := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed in indentation", i+1)
+		}
+		text = strings.TrimSpace(stripYamlComment(text))
+		if text == "" || text == "---" || text == "..." || strings.HasPrefix(text, "%") {
+			continue
+		}
+		parser.lines = append(parser.lines, yamlLine{number: i + 1, indent: len(line) - len(strings.TrimLeft(line, " ")), text: text})
+	}
+	if len(parser.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	value, err := parser.block(parser.lines[0].indent)
+	if err == nil && parser.position < len(parser.lines) {
+		err = parser.failure("unexpected indentation")
+	}
+	return value, err
+}
+
+// stripYamlComment removes comment starting with '#' not placed in quoted scalar.
+// Quotes are recognized only when they open a scalar, quotes inside plain scalars
+// (e.g. in '/data/o'brien') are ordinary characters.
+func stripYamlComment(text string) string {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensScalar(text[:i]):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// opensScalar returns true when the scalar starts after the specified text: at the beginning
+// of the line, after the mapping key, sequence item indicator or flow collection separator.
+func opensScalar(preceding string) bool {
+	trimmed := strings.TrimRight(preceding, " \t")
+	if trimmed == "" {
+		return true
+	}
+	switch trimmed[len(trimmed)-1] {
+	case '[', '{', ',':
+		return true
+	case ':', '-', '?':
+		return len(trimmed) < len(preceding)
+	}
+	return false
+}
+
+// failure creates error reporting the problem at the current line.
+func (p *yamlParser) failure(message string) error {
+	number := 0
+	if p.position < len(p.lines) {
+		number = p.lines[p.position].number
+	} else if len(p.lines) > 0 {
+		number = p.lines[len(p.lines)-1].number
+	}
+	return fmt.Errorf("yaml: line %d: %s", number, message)
+}
+
+// isSequenceItem returns true when the text starts sequence item.
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence starting at the current line with specified indentation.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.position].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// nested parses the value placed on the lines following the line with specified indentation,
+// the value is a block with larger indentation, or a sequence with the same indentation.
+func (p *yamlParser) nested(indent int, sequenceAllowed bool) (interface{}, error) {
+	if p.position >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.position]
+	if next.indent > indent {
+		return p.block(next.indent)
+	}
+	if sequenceAllowed && next.indent == indent && isSequenceItem(next.text) {
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// sequence parses items of block sequence with specified indentation.
+func (p *yamlParser) sequence(indent int) ([]interface{}, error) {
+	result := make([]interface{}, 0)
+	for p.position < len(p.lines) {
+		line := p.lines[p.position]
+		if line.indent < indent || (line.indent == indent && !isSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.failure("unexpected indentation")
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var value interface{}
+		var err error
+		switch {
+		case rest == "":
+			p.position++
+			value, err = p.nested(indent, false)
+		case isSequenceItem(rest) || isYamlEntry(rest):
+			// the item is a block starting on the same line, it continues with the indentation of its first line
+			offset := indent + len(line.text) - len(rest)
+			p.lines[p.position] = yamlLine{number: line.number, indent: offset, text: rest}
+			value, err = p.block(offset)
+		default:
+			value, err = p.scalar(rest)
+			p.position++
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// mapping parses entries of block mapping with specified indentation.
+func (p *yamlParser) mapping(indent int) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for p.position < len(p.lines) {
+		line := p.lines[p.position]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.failure("unexpected indentation")
+		}
+		if isSequenceItem(line.text) {
+			return nil, p.failure("sequence item not expected in mapping")
+		}
+		key, rest, ok := splitYamlEntry(line.text)
+		if !ok {
+			return nil, p.failure("expected 'key: value'")
+		}
+		if _, exists := result[key]; exists {
+			return nil, p.failure("duplicate key '" + key + "'")
+		}
+		var value interface{}
+		var err error
+		if rest == "" {
+			p.position++
+			value, err = p.nested(indent, true)
+		} else {
+			value, err = p.scalar(rest)
+			p.position++
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// isYamlEntry returns true when the text starts a mapping entry.
+func isYamlEntry(text string) bool {
+	_, _, ok := splitYamlEntry(text)
+	return ok
+}
+
+// splitYamlEntry splits 'key: value' text into key and value.
+func splitYamlEntry(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 || end+1 >= len(text) || text[end+1] != ':' || (end+2 < len(text) && text[end+2] != ' ') {
+			return "", "", false
+		}
+		key, err := unquoteYaml(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(text[end+2:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote closing quoted scalar starting the text, -1 when missing.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unquoteYaml returns the value of single or double quoted scalar.
+func unquoteYaml(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	return strconv.Unquote(text)
+}
+
+// scalar parses the value written on single line.
+func (p *yamlParser) scalar(text string) (interface{}, error) {
+	flow := &yamlFlow{text: text}
+	value, err := flow.value("")
+	if err == nil {
+		flow.skipSpaces()
+		if flow.position < len(flow.text) {
+			err = fmt.Errorf("unexpected '%s'", flow.text[flow.position:])
+		}
+	}
+	if err != nil {
+		return nil, p.failure(err.Error())
+	}
+	return value, nil
+}
+
+// yamlFlow parses flow collections and scalars written on single line.
+type yamlFlow struct {
+	text     string
+	position int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.position < len(f.text) && (f.text[f.position] == ' ' || f.text[f.position] == '\t') {
+		f.position++
+	}
+}
+
+// value parses single value, plain scalars are terminated by any of specified characters.
+func (f *yamlFlow) value(terminators string) (interface{}, error) {
+	f.skipSpaces()
+	if f.position >= len(f.text) {
+		return nil, nil
+	}
+	switch f.text[f.position] {
+	case '[':
+		return f.sequence()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		end := closingQuote(f.text[f.position:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted scalar")
+		}
+		value, err := unquoteYaml(f.text[f.position : f.position+end+1])
+		f.position += end + 1
+		return value, err
+	case '&', '*', '!', '|', '>':
+		return nil, fmt.Errorf("anchors, aliases, tags and block scalars are not supported")
+	}
+	start := f.position
+	for f.position < len(f.text) && !strings.ContainsRune(terminators, rune(f.text[f.position])) {
+		f.position++
+	}
+	switch plain := strings.TrimSpace(f.text[start:f.position]); plain {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	default:
+		return plainScalar(plain), nil
+	}
+}
+
+// expect skips spaces and the expected character, returns false when other character follows.
+func (f *yamlFlow) expect(c byte) bool {
+	f.skipSpaces()
+	if f.position < len(f.text) && f.text[f.position] == c {
+		f.position++
+		return true
+	}
+	return false
+}
+
+// sequence parses flow sequence, e.g. '[a, b]'.
+func (f *yamlFlow) sequence() ([]interface{}, error) {
+	f.position++
+	result := make([]interface{}, 0)
+	for !f.expect(']') {
+		if f.position >= len(f.text) {
+			return nil, fmt.Errorf("unterminated flow sequence")
+		}
+		value, err := f.value(",]")
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+		if !f.expect(',') && !f.expect(']') {
+			return nil, fmt.Errorf("expected ',' or ']' in flow sequence")
+		} else if f.text[f.position-1] == ']' {
+			break
+		}
+	}
+	return result, nil
+}
+
+// mapping parses flow mapping, e.g. '{a: 1, b: 2}'.
+func (f *yamlFlow) mapping() (map[string]interface{}, error) {
+	f.position++
+	result := make(map[string]interface{})
+	for !f.expect('}') {
+		if f.position >= len(f.text) {
+			return nil, fmt.Errorf("unterminated flow mapping")
+		}
+		key, err := f.value(":,}")
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if plain, isPlain := key.(plainScalar); isPlain {
+			name, ok = string(plain), true
+		}
+		if !ok || !f.expect(':') {
+			return nil, fmt.Errorf("expected 'key: value' in flow mapping")
+		}
+		value, err := f.value(",}")
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+		if !f.expect(',') && !f.expect('}') {
+			return nil, fmt.Errorf("expected ',' or '}' in flow mapping")
+		} else if f.text[f.position-1] == '}' {
+			break
+		}
+	}
+	return result, nil
+}
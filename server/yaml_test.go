@@ -0,0 +1,75 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripYamlComment(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"rootDirectory: /data  # comment", "rootDirectory: /data  "},
+		{"# comment", ""},
+		{"rootDirectory: /data/o'brien  # comment", "rootDirectory: /data/o'brien  "},
+		{`rootDirectory: /data/"quoted"  # comment`, `rootDirectory: /data/"quoted"  `},
+		{"rootDirectory: /data#1", "rootDirectory: /data#1"},
+		{"secret: 'a # b'  # comment", "secret: 'a # b'  "},
+		{"secret: 'it''s # b'  # comment", "secret: 'it''s # b'  "},
+		{`secret: "a \" # b"  # comment`, `secret: "a \" # b"  `},
+		{"'quoted # key': value  # comment", "'quoted # key': value  "},
+		{"- 'a # b'  # comment", "- 'a # b'  "},
+		{"events: ['a # b', \"c # d\"]  # comment", "events: ['a # b', \"c # d\"]  "},
+		{"auth: {key: 'a # b'}  # comment", "auth: {key: 'a # b'}  "},
+		{"key:'a # b'", "key:'a "},
+	}
+	for _, test := range tests {
+		if actual := stripYamlComment(test.text); actual != test.expected {
+			t.Errorf("%q: expected %q, actual %q", test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestParseYaml(t *testing.T) {
+	tests := []struct {
+		document string
+		expected interface{}
+	}{
+		{"", map[string]interface{}{}},
+		{"a: 1\nb: text\n", map[string]interface{}{"a": plainScalar("1"), "b": plainScalar("text")}},
+		{"---\na:\n  b: 'x'\n  c: \"y\\n\"\n...\n", map[string]interface{}{"a": map[string]interface{}{"b": "x", "c": "y\n"}}},
+		{"a:\n  - 1\n  - x: 2\n    y: ~\n", map[string]interface{}{"a": []interface{}{plainScalar("1"), map[string]interface{}{"x": plainScalar("2"), "y": nil}}}},
+		{"a:\n- 1\n- 2\n", map[string]interface{}{"a": []interface{}{plainScalar("1"), plainScalar("2")}}},
+		{"a: [1, 'b', {c: d}]\n", map[string]interface{}{"a": []interface{}{plainScalar("1"), "b", map[string]interface{}{"c": plainScalar("d")}}}},
+		{"a: /data/o'brien  # comment\n", map[string]interface{}{"a": plainScalar("/data/o'brien")}},
+		{"# only comment\n\n", map[string]interface{}{}},
+	}
+	for _, test := range tests {
+		actual, err := parseYaml(test.document)
+		if err != nil {
+			t.Errorf("%q: %v", test.document, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %#v, actual %#v", test.document, test.expected, actual)
+		}
+	}
+}
+
+func TestParseYamlFailures(t *testing.T) {
+	tests := []struct {
+		document string
+		expected string
+	}{
+		{"a:\n\tb: 1\n", "tabs are not allowed"},
+		{"a: 'unterminated\n", "unterminated quoted scalar"},
+		{"a: &anchor 1\n", "not supported"},
+		{"a: [1, 2\n", "in flow sequence"},
+		{"a: 1\n  b: 2\n", "line 2"},
+	}
+	for _, test := range tests {
+		if _, err := parseYaml(test.document); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error containing %q, actual %v", test.document, test.expected, err)
+		}
+	}
+}